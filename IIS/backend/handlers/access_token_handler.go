@@ -0,0 +1,75 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessTokenHandler struct {
+	accessTokenService *services.AccessTokenService
+}
+
+func NewAccessTokenHandler(accessTokenService *services.AccessTokenService) *AccessTokenHandler {
+	return &AccessTokenHandler{accessTokenService}
+}
+
+func (h *AccessTokenHandler) GetTokens(c *gin.Context) {
+	userID, _ := c.Get("id")
+
+	tokens, err := h.accessTokenService.GetUserTokens(c.Request.Context(), userID.(int32))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain access tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *AccessTokenHandler) CreateToken(c *gin.Context) {
+	req := models.CreateAccessTokenRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	token, err := h.accessTokenService.CreateToken(c.Request.Context(), userID.(int32), req)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create access token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+func (h *AccessTokenHandler) RevokeToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid token ID"})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	err = h.accessTokenService.RevokeToken(c.Request.Context(), userID.(int32), int32(id))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Access token not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to revoke access token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Access token revoked"})
+}
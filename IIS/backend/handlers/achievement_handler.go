@@ -0,0 +1,104 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	"backend/internal/errors"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AchievementHandler struct {
+	achievementService   *services.AchievementService
+	authorizationService *services.AuthorizationService
+}
+
+func NewAchievementHandler(
+	achievementService *services.AchievementService,
+	authorizationService *services.AuthorizationService,
+) *AchievementHandler {
+	return &AchievementHandler{achievementService, authorizationService}
+}
+
+func (h *AchievementHandler) GetAchievements(c *gin.Context) {
+	achievements, err := h.achievementService.GetAchievements()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, achievements)
+}
+
+func (h *AchievementHandler) CreateAchievement(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	req := &models.AchievementRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		code, msg := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(code, gin.H{"message": msg})
+		return
+	}
+
+	achievement, err := h.achievementService.CreateAchievement(req)
+	if err != nil {
+		c.Error(errors.Wrap(err, "Achievement could not be created", http.StatusBadRequest))
+		return
+	}
+
+	c.JSON(http.StatusCreated, achievement)
+}
+
+func (h *AchievementHandler) UpdateAchievement(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(errors.Wrap(err, "Invalid achievement ID", http.StatusBadRequest))
+		return
+	}
+
+	req := &models.AchievementRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		code, msg := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(code, gin.H{"message": msg})
+		return
+	}
+
+	achievement, err := h.achievementService.UpdateAchievement(int32(id), req)
+	if err == errors.DBNotFound {
+		c.Error(errors.Wrap(err, "Achievement not found", http.StatusNotFound))
+		return
+	} else if err != nil {
+		c.Error(errors.Wrap(err, "Achievement could not be updated", http.StatusBadRequest))
+		return
+	}
+
+	c.JSON(http.StatusOK, achievement)
+}
+
+// EvaluateAchievements applies all active rules to the whole history,
+// used after a new rule was added.
+func (h *AchievementHandler) EvaluateAchievements(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	if err := h.achievementService.EvaluateAll(); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Achievements evaluated"})
+}
@@ -0,0 +1,74 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	auditService         *services.AuditService
+	authorizationService *services.AuthorizationService
+}
+
+func NewAuditHandler(auditService *services.AuditService, authorizationService *services.AuthorizationService) *AuditHandler {
+	return &AuditHandler{auditService, authorizationService}
+}
+
+// GetAuditLog lets admins search the whole log
+func (h *AuditHandler) GetAuditLog(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	query := models.AuditQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	entries, err := h.auditService.GetEntries(c.Request.Context(), query)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// GetTournamentAuditLog shows organizers the changes of their tournament
+func (h *AuditHandler) GetTournamentAuditLog(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid tournament ID"})
+		return
+	}
+	if !authorize(c, h.authorizationService, services.PermTournamentAudit, int32(id)) {
+		return
+	}
+
+	query := models.AuditQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	query.TournamentID = int32(id)
+
+	entries, err := h.auditService.GetTournamentEntries(c.Request.Context(), query)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
@@ -7,11 +7,14 @@ package handlers
 import (
 	errori "backend/internal/errors"
 	"backend/internal/jwt"
+	"backend/internal/password"
 	"backend/internal/validation"
 	"backend/models"
 	"backend/services"
 	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,11 +22,22 @@ import (
 
 type AuthorizationHandler struct {
 	registrationService *services.RegistrationService
+	sessionService      *services.SessionService
+	accountService      *services.AccountService
+	throttleService     *services.LoginThrottleService
 }
 
-func NewAuthorizationHandler(registrationService *services.RegistrationService) *AuthorizationHandler {
+func NewAuthorizationHandler(
+	registrationService *services.RegistrationService,
+	sessionService *services.SessionService,
+	accountService *services.AccountService,
+	throttleService *services.LoginThrottleService,
+) *AuthorizationHandler {
 	return &AuthorizationHandler{
 		registrationService: registrationService,
+		sessionService:      sessionService,
+		accountService:      accountService,
+		throttleService:     throttleService,
 	}
 }
 
@@ -34,6 +48,10 @@ func (h *AuthorizationHandler) Register(c *gin.Context) {
 		c.AbortWithStatusJSON(status, gin.H{"message": message})
 		return
 	}
+	if err := password.Validate(createUserRequest.Password); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
 
 	registeredUser, err := h.registrationService.RegisterUser(c.Request.Context(), createUserRequest)
 	if err != nil {
@@ -45,6 +63,10 @@ func (h *AuthorizationHandler) Register(c *gin.Context) {
 		return
 	}
 
+	if err := h.accountService.SendVerification(c.Request.Context(), registeredUser.ID, registeredUser.Email); err != nil {
+		log.Printf("verification email for user %d failed: %v", registeredUser.ID, err)
+	}
+
 	c.JSON(http.StatusCreated, registeredUser)
 }
 
@@ -56,44 +78,87 @@ func (h *AuthorizationHandler) Login(c *gin.Context) {
 		return
 	}
 
+	if !checkThrottle(c, h.throttleService, loginUserRequest.Email) {
+		return
+	}
+
+	// Unknown email and wrong password get the same response, the attempt is already counted
 	loginedUser, err := h.registrationService.LoginUser(c.Request.Context(), loginUserRequest)
-	if err != nil {
-		if errors.Is(err, errori.InvalidPassword) {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+	if errors.Is(err, errori.InvalidPassword) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		if err := h.throttleService.Forgive(c.Request.Context(), loginUserRequest.Email, c.ClientIP()); err != nil {
+			log.Printf("forgiving login attempt failed: %v", err)
 		}
-		if errors.Is(err, errori.DBNotFound) {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Such user does not exist"})
+		if !abortWithAccountStatus(c, err) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to log in"})
 		}
 		return
 	}
+	if err := h.throttleService.Success(c.Request.Context(), loginUserRequest.Email, c.ClientIP()); err != nil {
+		log.Printf("resetting failed logins failed: %v", err)
+	}
 
-	access, refresh := jwt.GenerateAllTokens(loginedUser.ID, loginedUser.Role)
-
-	// c.SetCookie("access", access, 15*60, "/", "", false, true)
-	// c.SetCookie("refresh", refresh, 30*24*60*60, "/auth/refresh", "", false, true)
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access",
-		Value:    access,
-		Path:     "/",
-		MaxAge:   15 * 60,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteNoneMode,
-	})
+	// No cookies are set until the second factor is verified
+	if services.TwoFactorRequired(loginedUser.Role, loginedUser.TOTPEnabled) {
+		c.JSON(http.StatusOK, models.LoginChallengeResponse{
+			TwoFactorRequired:  true,
+			EnrollmentRequired: !loginedUser.TOTPEnabled,
+			Challenge:          jwt.GenerateChallengeToken(loginedUser.ID, time.Now().Add(jwt.ChallengeTokenTTL)),
+		})
+		return
+	}
 
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "refresh",
-		Value:    refresh,
-		Path:     "/auth/refresh",
-		MaxAge:   30 * 24 * 60 * 60,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteNoneMode,
-	})
+	if !startSession(c, h.sessionService, loginedUser) {
+		return
+	}
 
 	c.JSON(http.StatusOK, loginedUser)
 }
 
+// checkThrottle counts the attempt as failed until the caller proves otherwise,
+// the attempt is refused while the account or the address is backing off.
+func checkThrottle(c *gin.Context, throttleService *services.LoginThrottleService, account string) bool {
+	wait, err := throttleService.Attempt(c.Request.Context(), account, c.ClientIP())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal error"})
+		return false
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": errori.TooManyAttempts.Error()})
+		return false
+	}
+
+	return true
+}
+
+// abortWithAccountStatus refuses suspended and banned users with the reason of it
+func abortWithAccountStatus(c *gin.Context, err error) bool {
+	var statusErr errori.AccountStatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, statusErr.Body())
+	return true
+}
+
+// startSession creates the session of the logged in user and sets its cookies.
+func startSession(c *gin.Context, sessionService *services.SessionService, user *models.LoginUserResponse) bool {
+	sessionID, refresh, err := sessionService.CreateSession(c.Request.Context(), user.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create session"})
+		return false
+	}
+
+	access := jwt.GenerateAccessToken(user.ID, user.Role, sessionID, time.Now().Add(jwt.AccessTokenTTL))
+	setAuthCookies(c, access, refresh)
+
+	return true
+}
+
 func (h *AuthorizationHandler) Refresh(c *gin.Context) {
 	refresh, err := c.Cookie("refresh")
 	if err != nil {
@@ -101,75 +166,187 @@ func (h *AuthorizationHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	user_id, err := jwt.ValidateRefreshToken(refresh)
+	user_id, sessionID, newRefresh, err := h.sessionService.RotateSession(c.Request.Context(), refresh, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
-		c.AbortWithError(http.StatusUnauthorized, err)
+		clearAuthCookies(c)
+		if errors.Is(err, errori.SessionExpired) || errors.Is(err, errori.TokenReused) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		} else {
+			c.AbortWithError(http.StatusInternalServerError, err)
+		}
 		return
 	}
 
+	// The access token has expired, the audit log takes the user from the session
+	c.Set("id", user_id)
+
 	loginedUser, err := h.registrationService.ReloginUser(c.Request.Context(), user_id)
 	if err != nil {
-		c.AbortWithError(http.StatusUnauthorized, err)
+		clearAuthCookies(c)
+		if !abortWithAccountStatus(c, err) {
+			c.AbortWithError(http.StatusUnauthorized, err)
+		}
 		return
 	}
 
-	newAccess := jwt.GenerateAccessToken(loginedUser.ID, loginedUser.Role, time.Now().Add(time.Minute*15))
-	// c.SetCookie("access", newAccess, 15*60, "/", "", false, true)
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access",
-		Value:    newAccess,
-		Path:     "/",
-		MaxAge:   15 * 60,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteNoneMode,
-	})
+	newAccess := jwt.GenerateAccessToken(loginedUser.ID, loginedUser.Role, sessionID, time.Now().Add(jwt.AccessTokenTTL))
+	setAuthCookies(c, newAccess, newRefresh)
 
 	c.JSON(http.StatusOK, loginedUser)
 }
 
 func (h *AuthorizationHandler) Logout(c *gin.Context) {
-	// c.SetCookie("access", "", -1, "/", "", false, true)
-	// c.SetCookie("refresh", "", -1, "/auth/refresh", "", false, true)
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "access",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		Expires:  time.Unix(0, 0),
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteNoneMode,
-	})
+	if refresh, err := c.Cookie("refresh"); err == nil {
+		if err := h.sessionService.RevokeByRefreshToken(c.Request.Context(), refresh); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to end session"})
+			return
+		}
+	}
 
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "refresh",
-		Value:    "",
-		Path:     "/auth/refresh",
-		MaxAge:   -1,
-		Expires:  time.Unix(0, 0),
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteNoneMode,
-	})
+	clearAuthCookies(c)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
 }
 
-func (h *AuthorizationHandler) GetMe(c *gin.Context) {
-	access, err := c.Cookie("access")
+func (h *AuthorizationHandler) ForgotPassword(c *gin.Context) {
+	req := models.ForgotPasswordRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	if err := h.accountService.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to send password reset email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account exists, a password reset email has been sent"})
+}
+
+func (h *AuthorizationHandler) ResetPassword(c *gin.Context) {
+	req := models.ResetPasswordRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	if err := password.Validate(req.Password); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	userID, err := h.accountService.ResetPassword(c.Request.Context(), req.Token, req.Password)
+	if errors.Is(err, errori.InvalidToken) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to reset password"})
+		return
+	}
+
+	if err := h.sessionService.RevokeUserSessions(c.Request.Context(), userID); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to revoke sessions"})
+		return
+	}
+	clearAuthCookies(c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+func (h *AuthorizationHandler) VerifyEmail(c *gin.Context) {
+	req := models.VerifyEmailRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	err := h.accountService.VerifyEmail(c.Request.Context(), req.Token)
+	if errors.Is(err, errori.InvalidToken) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	} else if errors.Is(err, errori.InvalidLogin) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+}
+
+func (h *AuthorizationHandler) ResendVerification(c *gin.Context) {
+	userID, _ := c.Get("id")
+
+	err := h.accountService.ResendVerification(c.Request.Context(), userID.(int32))
+	if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Email address is already verified"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+func (h *AuthorizationHandler) GetSessions(c *gin.Context) {
+	userID, _ := c.Get("id")
+	sessionID, _ := c.Get("sid")
+
+	sessions, err := h.sessionService.GetUserSessions(c.Request.Context(), userID.(int32), sessionID.(int32))
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Access token missing"})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain sessions"})
 		return
 	}
 
-	user_id, _, err := jwt.ValidateAccessToken(access)
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *AuthorizationHandler) RevokeSession(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid session ID"})
 		return
 	}
+	userID, _ := c.Get("id")
+	sessionID, _ := c.Get("sid")
 
-	user, err := h.registrationService.GetUserByID(c.Request.Context(), user_id)
+	err = h.sessionService.RevokeSession(c.Request.Context(), userID.(int32), int32(id))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Session not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to revoke session"})
+		return
+	}
+
+	if int32(id) == sessionID.(int32) {
+		clearAuthCookies(c)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+func (h *AuthorizationHandler) RevokeAllSessions(c *gin.Context) {
+	userID, _ := c.Get("id")
+
+	if err := h.sessionService.RevokeUserSessions(c.Request.Context(), userID.(int32)); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to revoke sessions"})
+		return
+	}
+
+	clearAuthCookies(c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked"})
+}
+
+func (h *AuthorizationHandler) GetMe(c *gin.Context) {
+	userID, _ := c.Get("id")
+
+	user, err := h.registrationService.GetUserByID(c.Request.Context(), userID.(int32))
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal error"})
 		return
@@ -179,11 +356,66 @@ func (h *AuthorizationHandler) GetMe(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":      user.ID,
-		"email":   user.Email,
-		"name":    user.Name,
-		"surname": user.Surname,
-		"role":    user.Role,
+	response := gin.H{
+		"id":             user.ID,
+		"email":          user.Email,
+		"name":           user.Name,
+		"surname":        user.Surname,
+		"role":           user.Role,
+		"email_verified": user.EmailVerified,
+	}
+	// The app starts from this response, so it also learns about impersonation here
+	if impersonator, impersonated := c.Get("impersonator"); impersonated {
+		response["impersonated_by"] = impersonator
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// setAuthCookies stores the tokens, the refresh cookie is sent only to /auth
+// endpoints, so that logout can end the session it belongs to.
+func setAuthCookies(c *gin.Context, access, refresh string) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "access",
+		Value:    access,
+		Path:     "/",
+		MaxAge:   int(jwt.AccessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
 	})
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "refresh",
+		Value:    refresh,
+		Path:     "/auth",
+		MaxAge:   int(jwt.RefreshTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+func clearAuthCookies(c *gin.Context) {
+	for _, cookie := range []struct{ name, path string }{
+		{"access", "/"},
+		{"refresh", "/auth"},
+		{"refresh", "/auth/refresh"},
+	} {
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     cookie.name,
+			Value:    "",
+			Path:     cookie.path,
+			MaxAge:   -1,
+			Expires:  time.Unix(0, 0),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteNoneMode,
+		})
+	}
+}
+
+func (h *AuthorizationHandler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwt.JWKS())
 }
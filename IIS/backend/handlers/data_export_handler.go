@@ -0,0 +1,73 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DataExportHandler struct {
+	dataExportService *services.DataExportService
+}
+
+func NewDataExportHandler(dataExportService *services.DataExportService) *DataExportHandler {
+	return &DataExportHandler{dataExportService}
+}
+
+func (h *DataExportHandler) GetExports(c *gin.Context) {
+	userID, _ := c.Get("id")
+
+	exports, err := h.dataExportService.GetExports(c.Request.Context(), userID.(int32))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain data exports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, exports)
+}
+
+// RequestExport only starts the export, the user is notified by email once it is ready
+func (h *DataExportHandler) RequestExport(c *gin.Context) {
+	userID, _ := c.Get("id")
+
+	export, err := h.dataExportService.RequestExport(c.Request.Context(), userID.(int32))
+	if errors.Is(err, errori.AlreadyExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "An export is already in progress"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to request data export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, export)
+}
+
+func (h *DataExportHandler) DownloadExport(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid export ID"})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	archive, err := h.dataExportService.GetArchive(c.Request.Context(), userID.(int32), int32(id))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Export not found or not ready"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain data export"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="iis-export-%d.zip"`, id))
+	c.Data(http.StatusOK, "application/zip", archive)
+}
@@ -0,0 +1,122 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImpersonationHandler lets support staff see the application as the reporting user
+type ImpersonationHandler struct {
+	impersonationService *services.ImpersonationService
+	authorizationService *services.AuthorizationService
+}
+
+func NewImpersonationHandler(impersonationService *services.ImpersonationService, authorizationService *services.AuthorizationService) *ImpersonationHandler {
+	return &ImpersonationHandler{impersonationService, authorizationService}
+}
+
+func (h *ImpersonationHandler) Impersonate(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	uID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid user ID"})
+		return
+	}
+
+	req := models.ImpersonateRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	adminID, _ := c.Get("id")
+
+	token, err := h.impersonationService.Start(c.Request.Context(), adminID.(int32), int32(uID), req.Reason)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Admins cannot be impersonated"})
+		return
+	} else if errors.Is(err, errori.AccountSuspended) || errors.Is(err, errori.AccountBanned) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to start impersonation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+func (h *ImpersonationHandler) EndImpersonation(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid impersonation ID"})
+		return
+	}
+	adminID, _ := c.Get("id")
+
+	err = h.impersonationService.End(c.Request.Context(), adminID.(int32), int32(id))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Impersonation not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to end impersonation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+}
+
+func (h *ImpersonationHandler) GetImpersonations(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	impersonations, err := h.impersonationService.GetImpersonations(c.Request.Context())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain impersonations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, impersonations)
+}
+
+func (h *ImpersonationHandler) GetImpersonationActions(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid impersonation ID"})
+		return
+	}
+
+	actions, err := h.impersonationService.GetActions(c.Request.Context(), int32(id))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain impersonation actions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, actions)
+}
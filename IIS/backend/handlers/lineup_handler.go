@@ -0,0 +1,224 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LineupHandler covers players teams register for a tournament, the team edits
+// its lineup until it locks and then asks the organizers for substitutions.
+type LineupHandler struct {
+	lineupService        *services.LineupService
+	tournamentService    *services.TournamentService
+	authorizationService *services.AuthorizationService
+}
+
+func NewLineupHandler(
+	lineupService *services.LineupService,
+	tournamentService *services.TournamentService,
+	authorizationService *services.AuthorizationService,
+) *LineupHandler {
+	return &LineupHandler{lineupService, tournamentService, authorizationService}
+}
+
+// participant parses the tournament and its team participant from the route
+func (h *LineupHandler) participant(c *gin.Context) (int32, int32, int32, bool) {
+	tournamentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid tournament ID"})
+		return 0, 0, 0, false
+	}
+	participantID, err := strconv.Atoi(c.Param("pid"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid participant ID"})
+		return 0, 0, 0, false
+	}
+
+	teamID, err := h.lineupService.GetParticipantTeam(c.Request.Context(), int32(tournamentID), int32(participantID))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Team is not registered for this tournament"})
+		return 0, 0, 0, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain participant"})
+		return 0, 0, 0, false
+	}
+
+	return int32(tournamentID), int32(participantID), teamID, true
+}
+
+func (h *LineupHandler) GetLineup(c *gin.Context) {
+	tournamentID, participantID, _, ok := h.participant(c)
+	if !ok {
+		return
+	}
+
+	lineup, err := h.lineupService.GetLineup(c.Request.Context(), tournamentID, participantID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain lineup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, lineup)
+}
+
+func (h *LineupHandler) UpdateLineup(c *gin.Context) {
+	tournamentID, participantID, teamID, ok := h.participant(c)
+	if !ok {
+		return
+	}
+
+	req := models.UpdateLineupRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamRegister, teamID) {
+		return
+	}
+
+	squad, err := h.lineupService.SquadOf(c.Request.Context(), teamID, req.UserIDs)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Cannot check tournament constraints"})
+		return
+	}
+	limits, err := h.tournamentService.GetTeamSizeLimits(int(tournamentID))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Cannot check tournament constraints"})
+		return
+	}
+	if message := teamSizeViolation(limits, squad); message != "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": message})
+		return
+	}
+
+	err = h.lineupService.SetLineup(c.Request.Context(), participantID, req.UserIDs)
+	if errors.Is(err, errori.LineupLocked) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Lineup is locked, request a substitution instead"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Lineup can list only active players of the team"})
+		return
+	} else if errors.Is(err, errori.AlreadyExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Some players are registered for this tournament by another team"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to update lineup"})
+		return
+	}
+
+	lineup, err := h.lineupService.GetLineup(c.Request.Context(), tournamentID, participantID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain lineup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, lineup)
+}
+
+func (h *LineupHandler) RequestSubstitution(c *gin.Context) {
+	_, participantID, teamID, ok := h.participant(c)
+	if !ok {
+		return
+	}
+
+	req := models.CreateSubstitutionRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamRegister, teamID) {
+		return
+	}
+	userID, _ := c.Get("id")
+
+	substitution, err := h.lineupService.RequestSubstitution(c.Request.Context(), participantID, userID.(int32), req)
+	if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Substitutions are requested only for locked lineups of unfinished tournaments"})
+		return
+	} else if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "The leaving player has to be in the lineup and the incoming one an active player outside it"})
+		return
+	} else if errors.Is(err, errori.AlreadyExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Substitution of this player is already requested"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to request substitution"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, substitution)
+}
+
+func (h *LineupHandler) GetSubstitutions(c *gin.Context) {
+	tournamentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid tournament ID"})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTournamentParticipants, int32(tournamentID)) {
+		return
+	}
+
+	substitutions, err := h.lineupService.GetSubstitutions(c.Request.Context(), int32(tournamentID))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain substitutions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, substitutions)
+}
+
+func (h *LineupHandler) ResolveSubstitution(c *gin.Context) {
+	tournamentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid tournament ID"})
+		return
+	}
+	substitutionID, err := strconv.Atoi(c.Param("sid"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid substitution ID"})
+		return
+	}
+
+	req := models.ResolveSubstitutionRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTournamentParticipants, int32(tournamentID)) {
+		return
+	}
+	userID, _ := c.Get("id")
+
+	err = h.lineupService.ResolveSubstitution(c.Request.Context(), int32(tournamentID), int32(substitutionID), userID.(int32), req.Result == "Approve")
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Pending substitution not found"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The players no longer qualify for this substitution"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to resolve substitution"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Substitution was resolved successfully"})
+}
@@ -0,0 +1,140 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/internal/jwt"
+	"backend/internal/oidc"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler struct {
+	oidcService    *services.OIDCService
+	sessionService *services.SessionService
+	providers      map[string]*oidc.Provider
+}
+
+func NewOIDCHandler(
+	oidcService *services.OIDCService,
+	sessionService *services.SessionService,
+	providers map[string]*oidc.Provider,
+) *OIDCHandler {
+	return &OIDCHandler{oidcService, sessionService, providers}
+}
+
+func (h *OIDCHandler) GetProviders(c *gin.Context) {
+	providers := []models.OIDCProvider{}
+	for _, name := range oidc.Names(h.providers) {
+		providers = append(providers, models.OIDCProvider{Name: name, DisplayName: h.providers[name].DisplayName})
+	}
+
+	c.JSON(http.StatusOK, providers)
+}
+
+// Login redirects the browser to the provider's login page
+func (h *OIDCHandler) Login(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Unknown identity provider"})
+		return
+	}
+
+	authURL, state, err := h.oidcService.BeginLogin(c.Request.Context(), provider)
+	if err != nil {
+		log.Printf("oidc login with %s failed: %v", provider.Name, err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"message": "Identity provider is not available"})
+		return
+	}
+
+	setStateCookie(c, state, int((10 * time.Minute).Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes the login and redirects back to the frontend
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Unknown identity provider"})
+		return
+	}
+
+	cookieState, _ := c.Cookie("oidc_state")
+	setStateCookie(c, "", -1)
+
+	if c.Query("error") != "" {
+		redirectToApp(c, "/login", url.Values{"error": {c.Query("error")}})
+		return
+	}
+
+	state := c.Query("state")
+	if state == "" || state != cookieState {
+		redirectToApp(c, "/login", url.Values{"error": {"Invalid login state"}})
+		return
+	}
+
+	user, err := h.oidcService.CompleteLogin(c.Request.Context(), provider, state, c.Query("code"), c.Request.UserAgent(), c.ClientIP())
+	if errors.Is(err, errori.InvalidToken) {
+		redirectToApp(c, "/login", url.Values{"error": {"Login expired, try again"}})
+		return
+	} else if errors.Is(err, errori.UnverifiedEmail) || errors.Is(err, errori.AccountSuspended) || errors.Is(err, errori.AccountBanned) {
+		redirectToApp(c, "/login", url.Values{"error": {err.Error()}})
+		return
+	} else if err != nil {
+		log.Printf("oidc callback of %s failed: %v", provider.Name, err)
+		redirectToApp(c, "/login", url.Values{"error": {"Login with identity provider failed"}})
+		return
+	}
+
+	// The second factor is finished by the frontend with /auth/login/2fa
+	if services.TwoFactorRequired(user.Role, user.TOTPEnabled) {
+		challenge := jwt.GenerateChallengeToken(user.ID, time.Now().Add(jwt.ChallengeTokenTTL))
+		query := url.Values{"challenge": {challenge}}
+		if !user.TOTPEnabled {
+			query.Set("enrollment", "true")
+		}
+		redirectToApp(c, "/login", query)
+		return
+	}
+
+	if !startSession(c, h.sessionService, user) {
+		return
+	}
+
+	redirectToApp(c, "/", nil)
+}
+
+// setStateCookie keeps the state of the pending login, it has to be sent
+// on the top level redirect from the provider, so SameSite is lax.
+func setStateCookie(c *gin.Context, state string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "oidc_state",
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func redirectToApp(c *gin.Context, path string, query url.Values) {
+	target := strings.TrimRight(os.Getenv("APP_URL"), "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	c.Redirect(http.StatusFound, target)
+}
@@ -38,7 +38,7 @@ func (h *OverviewHandler) GetOverview(c *gin.Context) {
 		return
 	}
 
-	teams, err := h.teamService.GetTeams(1, 5, -1, "")
+	teams, err := h.teamService.GetTeams(1, 5, -1, "", false)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "internal error"})
 		return
@@ -0,0 +1,38 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	"backend/services"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorize asks the authorization service whether the authenticated user
+// has the permission on the resource, and aborts the request otherwise.
+func authorize(c *gin.Context, authorizationService *services.AuthorizationService, permission services.Permission, resourceID int32) bool {
+	userID, exists := c.Get("id")
+	if !exists {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "You must be authorized"})
+		return false
+	}
+	role, _ := c.Get("role")
+	roleName, _ := role.(string)
+
+	allowed, err := authorizationService.Can(c.Request.Context(), userID.(int32), roleName, permission, resourceID)
+	if err != nil {
+		log.Printf("authorization of %s failed: %v", permission, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal error"})
+		return false
+	}
+	if !allowed {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You cannot access this resource"})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,97 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	"backend/internal/errors"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PredictionHandler struct {
+	predictionService *services.PredictionService
+}
+
+func NewPredictionHandler(predictionService *services.PredictionService) *PredictionHandler {
+	return &PredictionHandler{predictionService}
+}
+
+func (h *PredictionHandler) GetMyPredictions(c *gin.Context) {
+	tID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(errors.Wrap(err, "Invalid tournament ID", http.StatusBadRequest))
+		return
+	}
+	userID, _ := c.Get("id")
+
+	predictions, err := h.predictionService.GetUserPredictions(int32(tID), userID.(int32))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, predictions)
+}
+
+func (h *PredictionHandler) SavePredictions(c *gin.Context) {
+	tID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(errors.Wrap(err, "Invalid tournament ID", http.StatusBadRequest))
+		return
+	}
+
+	req := &models.PredictionRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		code, msg := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(code, gin.H{"message": msg})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	if err := h.predictionService.SavePredictions(int32(tID), userID.(int32), req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	predictions, err := h.predictionService.GetUserPredictions(int32(tID), userID.(int32))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, predictions)
+}
+
+func (h *PredictionHandler) GetLeaderboard(c *gin.Context) {
+	tID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(errors.Wrap(err, "Invalid tournament ID", http.StatusBadRequest))
+		return
+	}
+
+	pageInt, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || pageInt < 1 {
+		c.Error(errors.Wrap(err, "Invalid page", http.StatusBadRequest))
+		return
+	}
+	limitInt, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limitInt < 1 {
+		c.Error(errors.Wrap(err, "Invalid limit", http.StatusBadRequest))
+		return
+	}
+
+	leaderboard, err := h.predictionService.GetLeaderboard(int32(tID), pageInt, limitInt)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, leaderboard)
+}
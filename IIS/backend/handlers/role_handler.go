@@ -0,0 +1,136 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoleHandler lets owners of tournaments and teams grant roles to other users
+type RoleHandler struct {
+	authorizationService *services.AuthorizationService
+}
+
+func NewRoleHandler(authorizationService *services.AuthorizationService) *RoleHandler {
+	return &RoleHandler{authorizationService}
+}
+
+func (h *RoleHandler) GetTournamentRoles(c *gin.Context) {
+	h.getRoles(c, "tournament")
+}
+
+func (h *RoleHandler) GetTeamRoles(c *gin.Context) {
+	h.getRoles(c, "team")
+}
+
+func (h *RoleHandler) GrantTournamentRole(c *gin.Context) {
+	req := models.GrantTournamentRoleRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	h.grantRole(c, "tournament", services.PermTournamentRoles, req.UserID, req.Role)
+}
+
+func (h *RoleHandler) GrantTeamRole(c *gin.Context) {
+	req := models.GrantTeamRoleRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	h.grantRole(c, "team", services.PermTeamRoles, req.UserID, req.Role)
+}
+
+func (h *RoleHandler) RevokeTournamentRole(c *gin.Context) {
+	h.revokeRole(c, "tournament", services.PermTournamentRoles)
+}
+
+func (h *RoleHandler) RevokeTeamRole(c *gin.Context) {
+	h.revokeRole(c, "team", services.PermTeamRoles)
+}
+
+func (h *RoleHandler) getRoles(c *gin.Context, resource string) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid ID"})
+		return
+	}
+
+	roles, err := h.authorizationService.GetRoles(c.Request.Context(), resource, int32(id))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, roles)
+}
+
+func (h *RoleHandler) grantRole(c *gin.Context, resource string, permission services.Permission, userID int32, role string) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid ID"})
+		return
+	}
+	if !authorize(c, h.authorizationService, permission, int32(id)) {
+		return
+	}
+	grantedBy, _ := c.Get("id")
+
+	assignmentID, err := h.authorizationService.GrantRole(c.Request.Context(), resource, int32(id), userID, role, grantedBy.(int32))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Resource not found"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "The role cannot be granted to this user"})
+		return
+	} else if errors.Is(err, errori.AlreadyExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The user already has this role"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to grant role"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": assignmentID})
+}
+
+func (h *RoleHandler) revokeRole(c *gin.Context, resource string, permission services.Permission) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid ID"})
+		return
+	}
+	assignmentID, err := strconv.Atoi(c.Param("rid"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid role ID"})
+		return
+	}
+	if !authorize(c, h.authorizationService, permission, int32(id)) {
+		return
+	}
+
+	err = h.authorizationService.RevokeRole(c.Request.Context(), resource, int32(id), int32(assignmentID))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Role not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to revoke role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role revoked"})
+}
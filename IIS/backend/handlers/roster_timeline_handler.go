@@ -0,0 +1,109 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RosterTimelineHandler answers when players joined and left teams
+type RosterTimelineHandler struct {
+	rosterTimelineService *services.RosterTimelineService
+	teamService           *services.TeamService
+	userService           *services.UserService
+}
+
+func NewRosterTimelineHandler(rosterTimelineService *services.RosterTimelineService, teamService *services.TeamService,
+	userService *services.UserService) *RosterTimelineHandler {
+	return &RosterTimelineHandler{rosterTimelineService, teamService, userService}
+}
+
+func (h *RosterTimelineHandler) GetTeamTimeline(c *gin.Context) {
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid team ID"})
+		return
+	}
+
+	_, _, err = h.teamService.GetTeamById(teamID)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Team not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain team"})
+		return
+	}
+
+	timeline, err := h.rosterTimelineService.GetTeamTimeline(c.Request.Context(), int32(teamID))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain team timeline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, timeline)
+}
+
+func (h *RosterTimelineHandler) GetPlayerTimeline(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid player ID"})
+		return
+	}
+
+	user, err := h.userService.GetUserById(c.Request.Context(), int32(userID))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain player"})
+		return
+	}
+	if user == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Player not found"})
+		return
+	}
+
+	timeline, err := h.rosterTimelineService.GetPlayerTimeline(c.Request.Context(), int32(userID))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain player timeline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, timeline)
+}
+
+// GetTransfers returns the feed of all teams, or of one team with the team query parameter
+func (h *RosterTimelineHandler) GetTransfers(c *gin.Context) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "URL parameter page was not specified"})
+		return
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "URL parameter limit has to be between 1 and 100"})
+		return
+	}
+
+	teamID := 0
+	if team := c.Query("team"); team != "" {
+		teamID, err = strconv.Atoi(team)
+		if err != nil || teamID < 1 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid team ID"})
+			return
+		}
+	}
+
+	response, err := h.rosterTimelineService.GetTransfers(c.Request.Context(), page, limit, int32(teamID))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
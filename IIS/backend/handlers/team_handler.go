@@ -11,6 +11,7 @@ import (
 	"backend/services"
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,10 +21,14 @@ import (
 )
 
 type TeamHandler struct {
-	teamService       *services.TeamService
-	teamPlayerService *services.TeamPlayerService
-	userService       *services.UserService
-	s3Service         *services.S3Service
+	teamService          *services.TeamService
+	teamPlayerService    *services.TeamPlayerService
+	userService          *services.UserService
+	s3Service            *services.S3Service
+	achievementService   *services.AchievementService
+	statisticsService    *services.StatisticsService
+	authorizationService *services.AuthorizationService
+	pendingInviteService *services.PendingTeamInviteService
 }
 
 func NewTeamHandler(
@@ -31,8 +36,12 @@ func NewTeamHandler(
 	s3Service *services.S3Service,
 	teamPlayerService *services.TeamPlayerService,
 	userService *services.UserService,
+	achievementService *services.AchievementService,
+	statisticsService *services.StatisticsService,
+	authorizationService *services.AuthorizationService,
+	pendingInviteService *services.PendingTeamInviteService,
 ) *TeamHandler {
-	return &TeamHandler{teamService, teamPlayerService, userService, s3Service}
+	return &TeamHandler{teamService, teamPlayerService, userService, s3Service, achievementService, statisticsService, authorizationService, pendingInviteService}
 }
 
 func (h *TeamHandler) GetAllTeams(c *gin.Context) {
@@ -71,7 +80,7 @@ func (h *TeamHandler) GetTeams(c *gin.Context) {
 		}
 	}
 
-	response, err := h.teamService.GetTeams(pageInt, limitInt, userInt, searchText)
+	response, err := h.teamService.GetTeams(pageInt, limitInt, userInt, searchText, c.Query("archived") == "true")
 	if err != nil {
 		c.Error(err)
 		return
@@ -106,12 +115,13 @@ func (h *TeamHandler) GetTeamById(c *gin.Context) {
 		team.Image = url
 	}
 
-	team.Winrate, err = h.teamService.GetTeamWinrate(team.ID)
+	stats, err := h.statisticsService.GetTeamStatistics(team.ID)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
+	team.Winrate = stats.Winrate
 	if team.Winrate.Loses == 0 {
 		if team.Winrate.Wins == 0 {
 			team.Winrate.Percentage = 0
@@ -122,27 +132,18 @@ func (h *TeamHandler) GetTeamById(c *gin.Context) {
 		team.Winrate.Percentage = int((float64(team.Winrate.Wins) / (float64(team.Winrate.Wins) + float64(team.Winrate.Loses))) * 100)
 	}
 
-	team.Disciplines, err = h.teamService.GetTeamDisciplines(team.ID)
-	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		return
-	}
-
-	matchmap, err := h.teamService.GetTeamActivity(team.ID)
-	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		return
-	}
+	team.Disciplines = stats.Disciplines
+	team.Winnings = stats.Winnings
 
 	for i := time.Now().AddDate(0, -3, 0); i.Month() <= time.Now().Month(); i = i.AddDate(0, 1, 0) {
-		if monthstat, ok := matchmap[i.Month().String()]; ok {
+		if monthstat, ok := stats.Activity[i.Month().String()]; ok {
 			team.Activity = append(team.Activity, monthstat)
 		} else {
 			team.Activity = append(team.Activity, models.ActivityStatistic{Month: i.Month().String(), Personal: 0, Teams: 0})
 		}
 	}
 
-	team.Winnings, err = h.teamService.GetTeamWinnings(team.ID)
+	team.Achievements, err = h.achievementService.GetTeamAchievements(team.ID)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -151,23 +152,33 @@ func (h *TeamHandler) GetTeamById(c *gin.Context) {
 	c.JSON(http.StatusOK, team)
 }
 
-func (h *TeamHandler) UpdateTeamAvatar(c *gin.Context) {
+func (h *TeamHandler) GetTeamResults(c *gin.Context) {
 	idStr := c.Param("id")
-
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
 		c.Error(errors.Wrap(err, "Invalid team ID", http.StatusBadRequest))
 		return
 	}
 
-	team, err := h.teamService.GetTeamDetailById(id)
+	results, err := h.teamService.GetTeamResults(int32(id))
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
-	if authid, exists := c.Get("id"); !exists || authid != team.Manager.ID {
-		c.Error(errors.Wrap(nil, "You do not have right to change avatar", http.StatusForbidden))
+	c.JSON(http.StatusOK, results)
+}
+
+func (h *TeamHandler) UpdateTeamAvatar(c *gin.Context) {
+	idStr := c.Param("id")
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.Error(errors.Wrap(err, "Invalid team ID", http.StatusBadRequest))
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamUpdate, int32(id)) {
 		return
 	}
 
@@ -229,14 +240,13 @@ func (h *TeamHandler) ChangePlayerState(c *gin.Context) {
 		return
 	}
 
-	team, managerID, err := h.teamService.GetTeamById(tId)
-	if err != nil {
-		c.Error(err)
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, int32(tId)) {
 		return
 	}
 
-	if authid, exists := c.Get("id"); !exists || authid != managerID {
-		c.Error(errors.Wrap(nil, "You do not have right to player state", http.StatusBadRequest))
+	team, _, err := h.teamService.GetTeamById(tId)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 	resp.TeamBaseResponse = team
@@ -266,6 +276,8 @@ func (h *TeamHandler) ChangePlayerState(c *gin.Context) {
 	if err != nil {
 		if err == errors.NotAcceptable {
 			c.Error(errors.Wrap(nil, "Cannot delete team player since your team participating in some tournament", http.StatusBadRequest))
+		} else if err == errors.LineupLocked {
+			c.Error(errors.Wrap(nil, "Player is in a locked tournament lineup, request a substitution first", http.StatusConflict))
 		} else {
 			c.Error(err)
 		}
@@ -286,6 +298,47 @@ func (h *TeamHandler) ChangePlayerState(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// ChangePlayerSquad moves the player between starters and substitutes
+func (h *TeamHandler) ChangePlayerSquad(c *gin.Context) {
+	tId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(errors.Wrap(err, "Invalid team ID", http.StatusBadRequest))
+		return
+	}
+	pId, err := strconv.Atoi(c.Param("pid"))
+	if err != nil {
+		c.Error(errors.Wrap(err, "Invalid player ID", http.StatusBadRequest))
+		return
+	}
+
+	var req models.TeamPlayerSquadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(errors.Wrap(err, "Bad request", http.StatusBadRequest))
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, int32(tId)) {
+		return
+	}
+
+	err = h.teamPlayerService.SetSquad(int32(tId), int32(pId), req.Squad)
+	if err == errors.DBNotFound {
+		c.Error(errors.Wrap(nil, "Player was not playing in this team", http.StatusBadRequest))
+		return
+	} else if err != nil {
+		c.Error(err)
+		return
+	}
+
+	players, err := h.teamPlayerService.PlayersFromTeam(int32(tId))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, players)
+}
+
 func (h *TeamHandler) InvitePlayer(c *gin.Context) {
 	var req models.TeamPlayerInviteRequest
 	var resp models.TeamUserPlayer
@@ -302,14 +355,13 @@ func (h *TeamHandler) InvitePlayer(c *gin.Context) {
 		return
 	}
 
-	_, managerID, err := h.teamService.GetTeamById(id)
-	if err != nil {
-		c.Error(err)
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, int32(id)) {
 		return
 	}
 
-	if authid, exists := c.Get("id"); !exists || authid != managerID {
-		c.Error(errors.Wrap(nil, "You do not have right to player state", http.StatusForbidden))
+	_, managerID, err := h.teamService.GetTeamById(id)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -337,6 +389,25 @@ func (h *TeamHandler) InvitePlayer(c *gin.Context) {
 		return
 	}
 
+	// Inviting the user who asked to join accepts the request
+	if pID, err := h.teamPlayerService.FindJoinRequest(user.ID, int32(id)); err == nil {
+		if err := h.teamPlayerService.ResolveJoinRequest(int32(id), pID, true); err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, models.TeamUserPlayer{
+			PlayerID: pID,
+			UserID:   user.ID,
+			Name:     user.Name.String,
+			Surname:  user.Surname.String,
+			State:    "Active",
+		})
+		return
+	} else if err != errors.DBNotFound {
+		c.Error(err)
+		return
+	}
+
 	player, err := h.teamPlayerService.AddInvitedPlayer(user.ID, int32(id))
 	if err != nil {
 		c.Error(err)
@@ -410,12 +481,17 @@ func (h *TeamHandler) CreateTeam(c *gin.Context) {
 		return
 	}
 
-	team, err := h.teamService.CreateTeamWithInvites(req.Name, req.Description, authid.(int32), req.EmailInvites)
+	team, pending, err := h.teamService.CreateTeamWithInvites(req.Name, req.Description, authid.(int32), req.EmailInvites)
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
+	// The team exists already, undelivered invites are only logged
+	if err := h.pendingInviteService.SendInvites(team.Name, pending); err != nil {
+		log.Printf("pending invites of team %d were not sent: %v", team.ID, err)
+	}
+
 	c.JSON(http.StatusCreated, team)
 }
 
@@ -436,19 +512,7 @@ func (h *TeamHandler) UpdateTeam(c *gin.Context) {
 		return
 	}
 
-	authid, exists := c.Get("id")
-	if !exists {
-		c.Error(errors.Wrap(nil, "Missing authentication ID", http.StatusUnauthorized))
-		return
-	}
-
-	_, managerID, err := h.teamService.GetTeamById(id)
-	if err != nil {
-		c.Error(err)
-		return
-	}
-	if managerID != authid {
-		c.Error(errors.Wrap(nil, "You do not have right to update team infomation", http.StatusForbidden))
+	if !authorize(c, h.authorizationService, services.PermTeamUpdate, int32(id)) {
 		return
 	}
 
@@ -0,0 +1,398 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/internal/roster"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TeamMembershipHandler covers the ways to the team besides the manager's
+// email invite, i.e. join requests, shareable invite codes and invites
+// of emails without an account.
+type TeamMembershipHandler struct {
+	teamService           *services.TeamService
+	teamPlayerService     *services.TeamPlayerService
+	teamInviteCodeService *services.TeamInviteCodeService
+	pendingInviteService  *services.PendingTeamInviteService
+	rosterImportService   *services.RosterImportService
+	authorizationService  *services.AuthorizationService
+}
+
+func NewTeamMembershipHandler(
+	teamService *services.TeamService,
+	teamPlayerService *services.TeamPlayerService,
+	teamInviteCodeService *services.TeamInviteCodeService,
+	pendingInviteService *services.PendingTeamInviteService,
+	rosterImportService *services.RosterImportService,
+	authorizationService *services.AuthorizationService,
+) *TeamMembershipHandler {
+	return &TeamMembershipHandler{teamService, teamPlayerService, teamInviteCodeService, pendingInviteService, rosterImportService, authorizationService}
+}
+
+// teamID parses the team from the route and aborts when the team does not exist or is disbanded
+func (h *TeamMembershipHandler) teamID(c *gin.Context) (int32, int32, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid team ID"})
+		return 0, 0, false
+	}
+
+	team, managerID, err := h.teamService.GetTeamById(id)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Team not found"})
+		return 0, 0, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain team"})
+		return 0, 0, false
+	}
+	if team.ArchivedAt.Valid {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Team is disbanded"})
+		return 0, 0, false
+	}
+
+	return int32(id), managerID, true
+}
+
+func (h *TeamMembershipHandler) RequestJoin(c *gin.Context) {
+	teamID, managerID, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("id")
+	role, _ := c.Get("role")
+
+	if role == "Admin" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Admins cannot join teams"})
+		return
+	}
+	if userID.(int32) == managerID {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "You are the manager of this team"})
+		return
+	}
+
+	player, err := h.teamPlayerService.RequestJoin(userID.(int32), teamID)
+	if errors.Is(err, errori.AlreadyExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "You are in the team already or have a pending invite or request"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to request joining the team"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, player)
+}
+
+func (h *TeamMembershipHandler) CancelJoinRequest(c *gin.Context) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+	userID, _ := c.Get("id")
+
+	err := h.teamPlayerService.CancelJoinRequest(userID.(int32), teamID)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "You have no pending request to join this team"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to cancel the request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Request was cancelled"})
+}
+
+func (h *TeamMembershipHandler) GetJoinRequests(c *gin.Context) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, teamID) {
+		return
+	}
+
+	requests, err := h.teamPlayerService.GetJoinRequests(teamID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain join requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+func (h *TeamMembershipHandler) ResolveJoinRequest(c *gin.Context) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+
+	req := models.ResolveInviteRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, teamID) {
+		return
+	}
+
+	err := h.teamPlayerService.ResolveJoinRequest(teamID, req.PlayerID, req.Result == "Accept")
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Join request not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to resolve the request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Request was resolved successfully"})
+}
+
+func (h *TeamMembershipHandler) GetInviteCodes(c *gin.Context) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, teamID) {
+		return
+	}
+
+	codes, err := h.teamInviteCodeService.GetCodes(c.Request.Context(), teamID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain invite codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+func (h *TeamMembershipHandler) CreateInviteCode(c *gin.Context) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+
+	req := models.CreateTeamInviteCodeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, teamID) {
+		return
+	}
+	userID, _ := c.Get("id")
+
+	code, err := h.teamInviteCodeService.CreateCode(c.Request.Context(), teamID, userID.(int32), req)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to create invite code"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, code)
+}
+
+func (h *TeamMembershipHandler) RevokeInviteCode(c *gin.Context) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+	codeID, err := strconv.Atoi(c.Param("cid"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid invite code ID"})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, teamID) {
+		return
+	}
+
+	err = h.teamInviteCodeService.RevokeCode(c.Request.Context(), teamID, int32(codeID))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Invite code not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to revoke invite code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite code revoked"})
+}
+
+func (h *TeamMembershipHandler) GetPendingInvites(c *gin.Context) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, teamID) {
+		return
+	}
+
+	invites, err := h.pendingInviteService.GetInvites(c.Request.Context(), teamID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain pending invites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+func (h *TeamMembershipHandler) CancelPendingInvite(c *gin.Context) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+	inviteID, err := strconv.Atoi(c.Param("iid"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid invite ID"})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, teamID) {
+		return
+	}
+
+	err = h.pendingInviteService.CancelInvite(c.Request.Context(), teamID, int32(inviteID))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Pending invite not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to cancel the invite"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite was cancelled"})
+}
+
+// maxRosterFileSize limits uploaded rosters, a few hundred rows take far less
+const maxRosterFileSize = 1 << 20
+
+// PreviewRosterImport reports what importing the file would do without changing anything
+func (h *TeamMembershipHandler) PreviewRosterImport(c *gin.Context) {
+	h.importRoster(c, false)
+}
+
+// ImportRoster invites the players of the file, rows with errors are skipped
+func (h *TeamMembershipHandler) ImportRoster(c *gin.Context) {
+	h.importRoster(c, true)
+}
+
+func (h *TeamMembershipHandler) importRoster(c *gin.Context, apply bool) {
+	teamID, _, ok := h.teamID(c)
+	if !ok {
+		return
+	}
+	if !authorize(c, h.authorizationService, services.PermTeamPlayers, teamID) {
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Attach the roster as a CSV or XLSX file"})
+		return
+	}
+	if header.Size > maxRosterFileSize {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"message": "The file is too large"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to read the file"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxRosterFileSize))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to read the file"})
+		return
+	}
+
+	rows, err := roster.Parse(header.Filename, data)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	report, err := h.rosterImportService.Import(c.Request.Context(), teamID, userID.(int32), rows, apply)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Team not found"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to import the roster"})
+		return
+	}
+
+	if apply && report.PendingInvites > 0 {
+		emails := []string{}
+		for _, row := range report.Rows {
+			if row.Action == models.RosterImportPendingInvite {
+				emails = append(emails, row.Email)
+			}
+		}
+		// The invites are stored already, undelivered ones are only logged
+		team, _, err := h.teamService.GetTeamById(int(teamID))
+		if err == nil {
+			err = h.pendingInviteService.SendInvites(team.Name, emails)
+		}
+		if err != nil {
+			log.Printf("pending invites of team %d were not sent: %v", teamID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RedeemInviteCode joins the team of the code, the response is the joined team
+func (h *TeamMembershipHandler) RedeemInviteCode(c *gin.Context) {
+	req := models.RedeemTeamInviteCodeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	userID, _ := c.Get("id")
+	role, _ := c.Get("role")
+
+	if role == "Admin" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Admins cannot join teams"})
+		return
+	}
+
+	teamID, err := h.teamInviteCodeService.Redeem(c.Request.Context(), userID.(int32), req.Code)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Invite code is invalid, expired or used up"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "You are the manager of this team"})
+		return
+	} else if errors.Is(err, errori.AlreadyExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "You are in the team already"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to join the team"})
+		return
+	}
+
+	team, _, err := h.teamService.GetTeamById(int(teamID))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain team"})
+		return
+	}
+
+	c.JSON(http.StatusOK, team)
+}
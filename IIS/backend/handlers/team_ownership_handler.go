@@ -0,0 +1,154 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TeamOwnershipHandler struct {
+	teamOwnershipService *services.TeamOwnershipService
+	authorizationService *services.AuthorizationService
+}
+
+func NewTeamOwnershipHandler(teamOwnershipService *services.TeamOwnershipService, authorizationService *services.AuthorizationService) *TeamOwnershipHandler {
+	return &TeamOwnershipHandler{teamOwnershipService, authorizationService}
+}
+
+func (h *TeamOwnershipHandler) TransferTeam(c *gin.Context) {
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid team ID"})
+		return
+	}
+
+	req := models.TeamOwnershipTransferRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamOwnership, int32(teamID)) {
+		return
+	}
+
+	transfer, err := h.teamOwnershipService.Transfer(c.Request.Context(), int32(teamID), req.UserID)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Team not found"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "The team can be handed only to its active player"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to transfer the team"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, transfer)
+}
+
+func (h *TeamOwnershipHandler) CancelTransfer(c *gin.Context) {
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid team ID"})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamOwnership, int32(teamID)) {
+		return
+	}
+
+	err = h.teamOwnershipService.CancelTransfer(c.Request.Context(), int32(teamID))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "The team has no pending transfer"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to cancel the transfer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer was cancelled"})
+}
+
+// ResolveTransfer is answered by the player the team was offered to
+func (h *TeamOwnershipHandler) ResolveTransfer(c *gin.Context) {
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid team ID"})
+		return
+	}
+
+	req := models.ResolveTeamOwnershipTransferRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	err = h.teamOwnershipService.ResolveTransfer(c.Request.Context(), int32(teamID), userID.(int32), req.Result == "Accept")
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "The team was not offered to you"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The team changed since the offer, it can no longer be accepted"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to resolve the transfer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer was resolved successfully"})
+}
+
+func (h *TeamOwnershipHandler) GetPendingTransfers(c *gin.Context) {
+	userID, _ := c.Get("id")
+
+	transfers, err := h.teamOwnershipService.GetPendingTransfers(c.Request.Context(), userID.(int32))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to obtain team transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transfers)
+}
+
+func (h *TeamOwnershipHandler) DisbandTeam(c *gin.Context) {
+	teamID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid team ID"})
+		return
+	}
+
+	if !authorize(c, h.authorizationService, services.PermTeamOwnership, int32(teamID)) {
+		return
+	}
+
+	err = h.teamOwnershipService.Disband(c.Request.Context(), int32(teamID))
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Team not found"})
+		return
+	} else if errors.Is(err, errori.AlreadyExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "Team is already disbanded"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Team cannot be disbanded while playing a tournament"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to disband the team"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Team was disbanded"})
+}
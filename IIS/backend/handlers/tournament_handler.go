@@ -9,6 +9,7 @@ import (
 	"backend/internal/validation"
 	"backend/models"
 	"backend/services"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -16,16 +17,23 @@ import (
 )
 
 type TournamentHandler struct {
-	tournamentService *services.TournamentService
+	tournamentService    *services.TournamentService
+	achievementService   *services.AchievementService
+	statisticsService    *services.StatisticsService
+	authorizationService *services.AuthorizationService
 }
 
-func NewTournamentHandler(tournamentService *services.TournamentService) *TournamentHandler {
-	return &TournamentHandler{tournamentService}
+func NewTournamentHandler(
+	tournamentService *services.TournamentService,
+	achievementService *services.AchievementService,
+	statisticsService *services.StatisticsService,
+	authorizationService *services.AuthorizationService,
+) *TournamentHandler {
+	return &TournamentHandler{tournamentService, achievementService, statisticsService, authorizationService}
 }
 
 func (h *TournamentHandler) GetAdminTournaments(c *gin.Context) {
-	if role, exists := c.Get("role"); !exists || role != "Admin" {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You cannot access this resource"})
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
 		return
 	}
 
@@ -136,6 +144,11 @@ func (h *TournamentHandler) CreateTournament(c *gin.Context) {
 		return
 	}
 
+	if !services.ValidPayout(req.Payout, req.ExpectedMembers) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid payout table"})
+		return
+	}
+
 	managerID, exists := c.Get("id")
 	if !exists {
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Missing identity"})
@@ -165,32 +178,20 @@ func (h *TournamentHandler) UpdateTournament(c *gin.Context) {
 		return
 	}
 
-	managerID, exists := c.Get("id")
-	if !exists {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Missing identity"})
+	if !services.ValidPayout(req.Payout, req.ExpectedMembers) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid payout table"})
 		return
 	}
 
-	tournaments, err := h.tournamentService.GetTournamentsByManagerId(managerID.(int32))
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-		return
-	}
-
-	isOwner := false
-	for _, t := range tournaments {
-		if t.ID == int32(tID) {
-			isOwner = true
-			break
-		}
-	}
-	if !isOwner {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You cannot update this tournament"})
+	if !authorize(c, h.authorizationService, services.PermTournamentUpdate, int32(tID)) {
 		return
 	}
 
 	tournament, err := h.tournamentService.UpdateTournament(int32(tID), req)
-	if err != nil {
+	if err == errors.LineupLocked {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "The start cannot be moved once lineups are locked"})
+		return
+	} else if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 		return
 	}
@@ -207,26 +208,18 @@ func (h *TournamentHandler) DeleteTournament(c *gin.Context) {
 		return
 	}
 
-	managerID, exists := c.Get("id")
-	if !exists {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Missing identity"})
+	if !authorize(c, h.authorizationService, services.PermTournamentDelete, int32(id)) {
 		return
 	}
 
-	managerID, ok := managerID.(int32)
-	if !ok {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid identity"})
-		return
-	}
-
-	isManager, err := h.tournamentService.CanDelete(managerID.(int32), int32(id))
+	deletable, err := h.tournamentService.IsDeletable(int32(id))
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
-	if !isManager {
-		c.Error(errors.Wrap(nil, "You cannot delete this tournament", http.StatusForbidden))
+	if !deletable {
+		c.Error(errors.Wrap(nil, "Only rejected tournaments can be deleted", http.StatusForbidden))
 		return
 	}
 
@@ -253,8 +246,16 @@ func (h *TournamentHandler) GetTournamentBracket(c *gin.Context) {
 
 func (h *TournamentHandler) StartTournament(c *gin.Context) {
 	id := c.Param("id")
+	tID, err := strconv.Atoi(id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid tournament ID"})
+		return
+	}
+	if !authorize(c, h.authorizationService, services.PermTournamentStart, int32(tID)) {
+		return
+	}
 
-	err := h.tournamentService.StartTournament(id)
+	err = h.tournamentService.StartTournament(id)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 		return
@@ -272,38 +273,7 @@ func (h *TournamentHandler) UpdateTournamentState(c *gin.Context) {
 		return
 	}
 
-	if role, exists := c.Get("role"); exists && role == "Admin" {
-		err = h.tournamentService.UpdateTournamentState(newStateRequest.ID, newStateRequest.State)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-			return
-		}
-		c.JSON(http.StatusNoContent, nil)
-		return
-	}
-
-	managerID, exists := c.Get("id")
-	if !exists {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Missing identity"})
-		return
-	}
-
-	tournaments, err := h.tournamentService.GetTournamentsByManagerId(managerID.(int32))
-	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		return
-	}
-
-	isOwner := false
-	for _, t := range tournaments {
-		if t.ID == newStateRequest.ID {
-			isOwner = true
-			break
-		}
-	}
-
-	if !isOwner {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You cannot change tournament state"})
+	if !authorize(c, h.authorizationService, services.PermTournamentState, newStateRequest.ID) {
 		return
 	}
 
@@ -318,6 +288,14 @@ func (h *TournamentHandler) UpdateTournamentState(c *gin.Context) {
 
 func (h *TournamentHandler) UpdateTournamentBracket(c *gin.Context) {
 	id := c.Param("id")
+	tID, err := strconv.Atoi(id)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid tournament ID"})
+		return
+	}
+	if !authorize(c, h.authorizationService, services.PermTournamentResults, int32(tID)) {
+		return
+	}
 
 	req := &models.TournamentBracket{}
 	if err := c.ShouldBindJSON(req); err != nil {
@@ -325,16 +303,25 @@ func (h *TournamentHandler) UpdateTournamentBracket(c *gin.Context) {
 		c.AbortWithStatusJSON(code, gin.H{"message": msg})
 		return
 	}
-	manager, exists := c.Get("id")
-	err := h.tournamentService.CheckTournamentBracket(id, req, manager.(int32), exists)
+	err = h.tournamentService.CheckTournamentBracket(id, req)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 	updTournaments, err := h.tournamentService.UpdateTournamentBracket(id, req)
-	if err != nil {
+	// Lineups are checked against the memberships within the update
+	if err == errors.EmptyLineup || err == errors.InvalidLineup {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 		return
 	}
+	if err := h.statisticsService.RefreshTournament(int32(tID)); err != nil {
+		log.Printf("statistics refresh of tournament %d failed: %v", tID, err)
+	}
+	if err := h.achievementService.EvaluateTournament(int32(tID)); err != nil {
+		log.Printf("achievement evaluation of tournament %d failed: %v", tID, err)
+	}
 	c.JSON(http.StatusOK, updTournaments)
 }
@@ -21,13 +21,19 @@ type TournamentParticipantHandler struct {
 	tournamentParticipantService *services.TournamentParticipantService
 	tournamentService            *services.TournamentService
 	teamService                  *services.TeamService
+	achievementService           *services.AchievementService
+	statisticsService            *services.StatisticsService
+	authorizationService         *services.AuthorizationService
 }
 
 func NewTournamentParticipantHandler(
 	tournamentParticipantService *services.TournamentParticipantService,
 	tournamentService *services.TournamentService,
-	teamService *services.TeamService) *TournamentParticipantHandler {
-	return &TournamentParticipantHandler{tournamentParticipantService, tournamentService, teamService}
+	teamService *services.TeamService,
+	achievementService *services.AchievementService,
+	statisticsService *services.StatisticsService,
+	authorizationService *services.AuthorizationService) *TournamentParticipantHandler {
+	return &TournamentParticipantHandler{tournamentParticipantService, tournamentService, teamService, achievementService, statisticsService, authorizationService}
 }
 
 func (h *TournamentParticipantHandler) GetAllTournamentParticipants(c *gin.Context) {
@@ -83,12 +89,13 @@ func (h *TournamentParticipantHandler) GetPlayerById(c *gin.Context) {
 		return
 	}
 
-	player.Winrate, err = h.tournamentParticipantService.GetPlayerWinrate(player.ID)
+	stats, err := h.statisticsService.GetPlayerStatistics(player.ID)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
 	}
 
+	player.Winrate = stats.Winrate
 	if player.Winrate.Loses == 0 {
 		if player.Winrate.Wins == 0 {
 			player.Winrate.Percentage = 0
@@ -99,27 +106,18 @@ func (h *TournamentParticipantHandler) GetPlayerById(c *gin.Context) {
 		player.Winrate.Percentage = int((float64(player.Winrate.Wins) / (float64(player.Winrate.Wins) + float64(player.Winrate.Loses))) * 100)
 	}
 
-	player.Disciplines, err = h.tournamentParticipantService.GetPlayerDisciplines(player.ID)
-	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		return
-	}
-
-	matchmap, err := h.tournamentParticipantService.GetPlayerActivity(player.ID)
-	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
-		return
-	}
+	player.Disciplines = stats.Disciplines
+	player.Winnings = stats.Winnings
 
 	for i := time.Now().AddDate(0, -3, 0); i.Month() <= time.Now().Month(); i = i.AddDate(0, 1, 0) {
-		if monthstat, ok := matchmap[i.Month().String()]; ok {
+		if monthstat, ok := stats.Activity[i.Month().String()]; ok {
 			player.Activity = append(player.Activity, monthstat)
 		} else {
 			player.Activity = append(player.Activity, models.ActivityStatistic{Month: i.Month().String(), Personal: 0, Teams: 0})
 		}
 	}
 
-	player.Winnings, err = h.tournamentParticipantService.GetPlayerWinnings(player.ID)
+	player.Achievements, err = h.achievementService.GetPlayerAchievements(player.ID)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -128,6 +126,23 @@ func (h *TournamentParticipantHandler) GetPlayerById(c *gin.Context) {
 	c.JSON(http.StatusOK, player)
 }
 
+func (h *TournamentParticipantHandler) GetPlayerResults(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid player ID"})
+		return
+	}
+
+	results, err := h.tournamentParticipantService.GetPlayerResults(int32(id))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
 func (h *TournamentParticipantHandler) CreateParticipant(c *gin.Context) {
 	id := c.Param("id")
 	tID, err := strconv.Atoi(id)
@@ -159,7 +174,7 @@ func (h *TournamentParticipantHandler) CreateParticipant(c *gin.Context) {
 			return
 		}
 
-		_, managerID, err := h.teamService.GetTeamById(int(req.TeamID.Int32))
+		_, _, err := h.teamService.GetTeamById(int(req.TeamID.Int32))
 
 		if err != nil {
 			if err == errori.DBNotFound {
@@ -170,27 +185,23 @@ func (h *TournamentParticipantHandler) CreateParticipant(c *gin.Context) {
 			return
 		}
 
-		if managerID != authid {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You cannot invite this team"})
+		if !authorize(c, h.authorizationService, services.PermTeamRegister, req.TeamID.Int32) {
 			return
 		}
 
-		teamPlayers := h.teamService.CountActiveTeamPlayers(req.TeamID.Int32)
-		min_limit, max_limit, err := h.tournamentService.GetTeamPlayerConstraint(tID)
-		log.Println(teamPlayers, err)
-		if err != nil || teamPlayers == -1 {
+		squad, err := h.teamService.CountActiveSquad(req.TeamID.Int32)
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Cannot check tournament constraints"})
 			return
-		} else if min_limit.Valid {
-			if min_limit.Int32 > teamPlayers {
-				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "There are not enough players on the team for this tournament"})
-				return
-			}
-		} else if max_limit.Valid {
-			if max_limit.Int32 < teamPlayers {
-				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "There are many players in the team for this tournament"})
-				return
-			}
+		}
+		limits, err := h.tournamentService.GetTeamSizeLimits(tID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Cannot check tournament constraints"})
+			return
+		}
+		if message := teamSizeViolation(limits, squad); message != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": message})
+			return
 		}
 
 		_, err = h.tournamentParticipantService.TeamOrPlayerPaticipatesTournament(int32(tID), req.TeamID.Int32)
@@ -252,8 +263,7 @@ func (h *TournamentParticipantHandler) ResolveParticipant(c *gin.Context) {
 		return
 	}
 
-	if authid, exists := c.Get("id"); !exists || authid != tournament.Manager.ID {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You do not have right to accept or reject players"})
+	if !authorize(c, h.authorizationService, services.PermTournamentParticipants, int32(tID)) {
 		return
 	}
 
@@ -262,5 +272,29 @@ func (h *TournamentParticipantHandler) ResolveParticipant(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 		return
 	}
+	if err := h.statisticsService.RefreshParticipant(req.TournamentParticipantID); err != nil {
+		log.Printf("statistics refresh of participant %d failed: %v", req.TournamentParticipantID, err)
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Success"})
 }
+
+// teamSizeViolation checks the active players of the team against the tournament limits,
+// substitutes are counted as starters when the tournament does not limit them.
+func teamSizeViolation(limits models.TeamSizeLimits, squad models.TeamSquadCount) string {
+	starters := squad.Starters
+	if !limits.MaxSubstitutes.Valid {
+		starters += squad.Substitutes
+	}
+
+	if limits.MinLimit.Valid && starters < limits.MinLimit.Int32 {
+		return "There are not enough players on the team for this tournament"
+	}
+	if limits.MaxLimit.Valid && starters > limits.MaxLimit.Int32 {
+		return "There are many players in the team for this tournament"
+	}
+	if limits.MaxSubstitutes.Valid && squad.Substitutes > limits.MaxSubstitutes.Int32 {
+		return "There are too many substitutes in the team for this tournament"
+	}
+
+	return ""
+}
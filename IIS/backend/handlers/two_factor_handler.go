@@ -0,0 +1,203 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package handlers
+
+import (
+	errori "backend/internal/errors"
+	"backend/internal/jwt"
+	"backend/internal/validation"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TwoFactorHandler struct {
+	twoFactorService    *services.TwoFactorService
+	registrationService *services.RegistrationService
+	sessionService      *services.SessionService
+	throttleService     *services.LoginThrottleService
+}
+
+func NewTwoFactorHandler(
+	twoFactorService *services.TwoFactorService,
+	registrationService *services.RegistrationService,
+	sessionService *services.SessionService,
+	throttleService *services.LoginThrottleService,
+) *TwoFactorHandler {
+	return &TwoFactorHandler{twoFactorService, registrationService, sessionService, throttleService}
+}
+
+// abortWithCodeError maps errors of the code verification to responses
+func abortWithCodeError(c *gin.Context, err error) {
+	if errors.Is(err, errori.InvalidCode) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Two-factor authentication is not in the required state"})
+	} else {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to verify the code"})
+	}
+}
+
+// Login finishes the login started by password. Admins without 2FA confirm
+// their enrollment here and receive recovery codes.
+func (h *TwoFactorHandler) Login(c *gin.Context) {
+	req := models.TwoFactorLoginRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	userID, err := jwt.ValidateChallengeToken(req.Challenge)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid or expired challenge"})
+		return
+	}
+
+	account := services.TwoFactorAccount(userID)
+	if !checkThrottle(c, h.throttleService, account) {
+		return
+	}
+
+	user, err := h.registrationService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		if err := h.throttleService.Forgive(c.Request.Context(), account, c.ClientIP()); err != nil {
+			log.Printf("forgiving code attempt failed: %v", err)
+		}
+		if !abortWithAccountStatus(c, err) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Such user does not exist"})
+		}
+		return
+	}
+
+	response := models.TwoFactorLoginResponse{}
+	if user.TOTPEnabled {
+		err = h.twoFactorService.VerifyCode(c.Request.Context(), userID, req.Code)
+	} else {
+		response.RecoveryCodes, err = h.twoFactorService.ConfirmEnrollment(c.Request.Context(), userID, req.Code)
+		user.TOTPEnabled = err == nil
+	}
+	// Only a wrong code stays counted
+	if err != nil && !errors.Is(err, errori.InvalidCode) {
+		if err := h.throttleService.Forgive(c.Request.Context(), account, c.ClientIP()); err != nil {
+			log.Printf("forgiving code attempt failed: %v", err)
+		}
+	}
+	if err != nil {
+		abortWithCodeError(c, err)
+		return
+	}
+	if err := h.throttleService.Success(c.Request.Context(), account, c.ClientIP()); err != nil {
+		log.Printf("resetting failed codes failed: %v", err)
+	}
+
+	if !startSession(c, h.sessionService, user) {
+		return
+	}
+
+	response.LoginUserResponse = *user
+	c.JSON(http.StatusOK, response)
+}
+
+// LoginEnroll provides the secret to admins, who have to enroll before the first login.
+func (h *TwoFactorHandler) LoginEnroll(c *gin.Context) {
+	req := models.TwoFactorEnrollRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	userID, err := jwt.ValidateChallengeToken(req.Challenge)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid or expired challenge"})
+		return
+	}
+	// No session exists yet, the audit log takes the user from the challenge
+	c.Set("id", userID)
+
+	h.beginEnrollment(c, userID)
+}
+
+func (h *TwoFactorHandler) Enroll(c *gin.Context) {
+	userID, _ := c.Get("id")
+
+	h.beginEnrollment(c, userID.(int32))
+}
+
+func (h *TwoFactorHandler) beginEnrollment(c *gin.Context, userID int32) {
+	secret, uri, err := h.twoFactorService.BeginEnrollment(c.Request.Context(), userID)
+	if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Two-factor authentication is already enabled"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TwoFactorEnrollment{Secret: secret, URI: uri})
+}
+
+func (h *TwoFactorHandler) ConfirmEnrollment(c *gin.Context) {
+	req := models.TwoFactorCodeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	codes, err := h.twoFactorService.ConfirmEnrollment(c.Request.Context(), userID.(int32), req.Code)
+	if err != nil {
+		abortWithCodeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	if role, exists := c.Get("role"); exists && role == "Admin" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Two-factor authentication is mandatory for admins"})
+		return
+	}
+
+	req := models.TwoFactorCodeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	if err := h.twoFactorService.Disable(c.Request.Context(), userID.(int32), req.Code); err != nil {
+		abortWithCodeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	req := models.TwoFactorCodeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	userID, _ := c.Get("id")
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(c.Request.Context(), userID.(int32), req.Code)
+	if err != nil {
+		abortWithCodeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
@@ -6,25 +6,33 @@ package handlers
 
 import (
 	errori "backend/internal/errors"
+	"backend/internal/password"
 	"backend/internal/validation"
 	"backend/models"
 	"backend/services"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserHandler struct {
-	userService       *services.UserService
-	matchService      *services.MatchService
-	teamService       *services.TeamService
-	tournamentService *services.TournamentService
-	teamPlayerService *services.TeamPlayerService
-	s3Service         *services.S3Service
+	userService          *services.UserService
+	matchService         *services.MatchService
+	teamService          *services.TeamService
+	tournamentService    *services.TournamentService
+	teamPlayerService    *services.TeamPlayerService
+	s3Service            *services.S3Service
+	sessionService       *services.SessionService
+	accountService       *services.AccountService
+	authorizationService *services.AuthorizationService
+	throttleService      *services.LoginThrottleService
+	accountStatusService *services.AccountStatusService
 }
 
 func NewUserHandler(
@@ -33,13 +41,17 @@ func NewUserHandler(
 	teamService *services.TeamService,
 	tournamentService *services.TournamentService,
 	teamPlayerService *services.TeamPlayerService,
-	s3Service *services.S3Service) *UserHandler {
-	return &UserHandler{userService, matchService, teamService, tournamentService, teamPlayerService, s3Service}
+	s3Service *services.S3Service,
+	sessionService *services.SessionService,
+	accountService *services.AccountService,
+	authorizationService *services.AuthorizationService,
+	throttleService *services.LoginThrottleService,
+	accountStatusService *services.AccountStatusService) *UserHandler {
+	return &UserHandler{userService, matchService, teamService, tournamentService, teamPlayerService, s3Service, sessionService, accountService, authorizationService, throttleService, accountStatusService}
 }
 
 func (h *UserHandler) GetAllUsers(c *gin.Context) {
-	if role, exists := c.Get("role"); !exists || role != "Admin" {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You cannot access this resource"})
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
 		return
 	}
 
@@ -68,8 +80,7 @@ func (h *UserHandler) GetAllUsers(c *gin.Context) {
 }
 
 func (h *UserHandler) AdminUpdateUser(c *gin.Context) {
-	if role, exists := c.Get("role"); !exists || role != "Admin" {
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You cannot change user"})
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
 		return
 	}
 
@@ -88,22 +99,109 @@ func (h *UserHandler) AdminUpdateUser(c *gin.Context) {
 	}
 
 	if req.Password != "" {
-		pwdHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err := password.Validate(req.Password); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		pwdHash, err := password.Hash(req.Password)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to encrypt password"})
 			return
 		}
-		req.Password = string(pwdHash)
+		req.Password = pwdHash
 	}
 
-	err = h.userService.AdminUpdateUser(context.Background(), int32(uID), req)
-	if err != nil {
+	emailChanged, err := h.userService.AdminUpdateUser(context.Background(), int32(uID), req)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	} else if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Cannot update user"})
 		return
 	}
+
+	// Like a change made by the user, the new address has to be confirmed by its owner
+	if emailChanged {
+		if err := h.accountService.SendVerification(c.Request.Context(), int32(uID), req.Email); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to send verification email"})
+			return
+		}
+	}
+
+	if req.Password != "" {
+		if err := h.sessionService.RevokeUserSessions(context.Background(), int32(uID)); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to revoke user sessions"})
+			return
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{})
 }
 
+// UpdateUserStatus suspends, bans, deletes or reactivates the user
+func (h *UserHandler) UpdateUserStatus(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	uID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid user ID"})
+		return
+	}
+
+	var req models.UpdateAccountStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+	if req.Status == services.StatusSuspended && !req.Until.After(time.Now()) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Suspension must end in the future"})
+		return
+	}
+
+	adminID, _ := c.Get("id")
+	err = h.accountStatusService.SetStatus(c.Request.Context(), int32(uID), adminID.(int32), req)
+	if errors.Is(err, errori.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Status of this account cannot be changed"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to change account status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account status changed"})
+}
+
+// UnlockUser lifts the login lockout of the user
+func (h *UserHandler) UnlockUser(c *gin.Context) {
+	if !authorize(c, h.authorizationService, services.PermAdministration, 0) {
+		return
+	}
+
+	uID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.userService.GetUserById(c.Request.Context(), int32(uID))
+	if err != nil || user == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	}
+
+	if err := h.throttleService.Unlock(c.Request.Context(), user.Email, user.ID); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
 func (h *UserHandler) GetMe(c *gin.Context) {
 	userID, exists := c.Get("id")
 	if !exists {
@@ -121,13 +219,20 @@ func (h *UserHandler) GetMe(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":      user.ID,
-		"email":   user.Email,
-		"name":    user.Name.String,
-		"surname": user.Surname.String,
-		"role":    user.Role,
-	})
+	response := gin.H{
+		"id":             user.ID,
+		"email":          user.Email,
+		"name":           user.Name.String,
+		"surname":        user.Surname.String,
+		"role":           user.Role,
+		"email_verified": user.EmailVerified,
+	}
+	// The frontend shows a banner while support staff act as the user
+	if impersonator, impersonated := c.Get("impersonator"); impersonated {
+		response["impersonated_by"] = impersonator
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *UserHandler) UpdateMe(c *gin.Context) {
@@ -144,17 +249,73 @@ func (h *UserHandler) UpdateMe(c *gin.Context) {
 		return
 	}
 
-	err := h.userService.UpdateUser(c.Request.Context(), userID.(int32), req)
+	user, err := h.userService.GetUserById(c.Request.Context(), userID.(int32))
+	if err != nil || user == nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to update user"})
+		return
+	}
+
+	err = h.userService.UpdateUser(c.Request.Context(), userID.(int32), req)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to update user"})
 		return
 	}
 
+	// The new email is applied only once the user confirms it
+	if req.Email != user.Email {
+		if err := h.accountService.SendVerification(c.Request.Context(), user.ID, req.Email); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to send verification email"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Profile updated, confirm the new email address to apply it",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Profile updated successfully",
 	})
 }
 
+// DeleteMe anonymizes the account of the user, played matches stay in the brackets
+func (h *UserHandler) DeleteMe(c *gin.Context) {
+	userID, _ := c.Get("id")
+	sessionID, _ := c.Get("sid")
+
+	// Accounts without password may send no body at all
+	var req models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		status, message := validation.BuildValidationErrorResponse(err)
+		c.AbortWithStatusJSON(status, gin.H{"message": message})
+		return
+	}
+
+	err := h.accountStatusService.DeleteAccount(c.Request.Context(), userID.(int32), sessionID.(int32), req)
+	if errors.Is(err, errori.InvalidPassword) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid password"})
+		return
+	} else if errors.Is(err, errori.InvalidCode) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	} else if errors.Is(err, errori.LoginRequired) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Sign in again to delete the account"})
+		return
+	} else if errors.Is(err, errori.NotAcceptable) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Admin accounts cannot be deleted"})
+		return
+	} else if errors.Is(err, errori.StillOwner) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed to delete account"})
+		return
+	}
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
 func (h *UserHandler) GetProfile(c *gin.Context) {
 	userID, exists := c.Get("id")
 	if !exists {
@@ -7,13 +7,48 @@ package errors
 import (
 	"errors"
 	"net/http"
+	"time"
 )
 
-var InvalidPassword = errors.New("Invalid password")
+var InvalidPassword = errors.New("Invalid email or password")
 var InvalidLogin = errors.New("User with this email already exists")
 var InternalError = errors.New("Internal error")
 var DBNotFound = errors.New("No rows")
 var NotAcceptable = errors.New("Not acceptable")
+var SessionExpired = errors.New("Session expired")
+var TokenReused = errors.New("Refresh token was already used")
+var InvalidToken = errors.New("Invalid or expired token")
+var InvalidCode = errors.New("Invalid authentication code")
+var UnverifiedEmail = errors.New("Email address is not verified")
+var AlreadyExists = errors.New("Already exists")
+var TooManyAttempts = errors.New("Too many failed attempts, try again later")
+var AccountSuspended = errors.New("Account is suspended")
+var AccountBanned = errors.New("Account is banned")
+var LineupLocked = errors.New("Lineup is locked")
+var EmptyLineup = errors.New("Lineup of a played match cannot be empty")
+var InvalidLineup = errors.New("Lineup can only list players who were in the team at the time of the match")
+var LoginRequired = errors.New("Sign in again to confirm")
+var StillOwner = errors.New("Transfer or disband your teams and finish your tournaments first")
+
+// AccountStatusError tells the user why the account cannot be used and until when
+type AccountStatusError struct {
+	Err    error
+	Reason string
+	Until  *time.Time
+}
+
+func (e AccountStatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e AccountStatusError) Unwrap() error {
+	return e.Err
+}
+
+// Body is the response sent instead of the requested resource
+func (e AccountStatusError) Body() map[string]any {
+	return map[string]any{"message": e.Error(), "reason": e.Reason, "until": e.Until}
+}
 
 type APIError struct {
 	Code       string `json:"code"`
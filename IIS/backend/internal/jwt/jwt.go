@@ -5,88 +5,184 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"os"
 	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateAllTokens(id int32, role string) (string, string) {
-	now := time.Now()
+const AccessTokenTTL = time.Minute * 15
+const RefreshTokenTTL = time.Hour * 24 * 30
+const ChallengeTokenTTL = time.Minute * 5
+const ImpersonationTokenTTL = time.Minute * 15
 
-	return GenerateAccessToken(id, role, now.Add(time.Minute*15)), generateRefreshToken(id, now.Add(time.Hour*24*30))
+// Claims are the identity carried by a valid access token
+type Claims struct {
+	ID        int32
+	Role      string
+	SessionID int32
 }
 
-func GenerateAccessToken(id int32, role string, exp time.Time) string {
-	access_token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// ImpersonationClaims identify both the impersonated user and the admin acting as them
+type ImpersonationClaims struct {
+	ID              int32
+	Role            string
+	ImpersonatorID  int32
+	ImpersonationID int32
+}
+
+func GenerateAccessToken(id int32, role string, sessionID int32, exp time.Time) string {
+	access_token_string, _ := keyRing().sign(jwt.MapClaims{
 		"sub":  strconv.Itoa(int(id)),
-		"exp":  &jwt.NumericDate{exp},
+		"exp":  jwt.NewNumericDate(exp),
 		"role": role,
+		"sid":  sessionID,
 	})
 
-	access_token_string, _ := access_token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-
 	return access_token_string
 }
 
-func generateRefreshToken(id int32, exp time.Time) string {
-	refresh_token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Subject:   strconv.Itoa(int(id)),
-		ExpiresAt: &jwt.NumericDate{exp},
+// GenerateChallengeToken proves the password was verified while the second
+// factor is still pending. It carries no role, so it is not accepted as access token.
+func GenerateChallengeToken(id int32, exp time.Time) string {
+	challenge_token_string, _ := keyRing().sign(jwt.MapClaims{
+		"sub": strconv.Itoa(int(id)),
+		"exp": jwt.NewNumericDate(exp),
+		"typ": "challenge",
 	})
 
-	retfresh_token_string, _ := refresh_token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-	return retfresh_token_string
+	return challenge_token_string
 }
 
-func ValidateRefreshToken(refresh_string string) (int32, error) {
-	token, err := jwt.Parse(refresh_string, func(t *jwt.Token) (any, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
+// GenerateImpersonationToken is an access token of the user marked by typ, the admin
+// is the actor as in RFC 8693. It has no session, so it is refused as cookie.
+func GenerateImpersonationToken(id int32, role string, impersonatorID, impersonationID int32, exp time.Time) string {
+	impersonation_token_string, _ := keyRing().sign(jwt.MapClaims{
+		"sub":  strconv.Itoa(int(id)),
+		"exp":  jwt.NewNumericDate(exp),
+		"role": role,
+		"typ":  "impersonation",
+		"act":  map[string]string{"sub": strconv.Itoa(int(impersonatorID))},
+		"imp":  impersonationID,
 	})
+
+	return impersonation_token_string
+}
+
+func ValidateImpersonationToken(impersonation_string string) (ImpersonationClaims, error) {
+	token, err := jwt.Parse(impersonation_string, keyRing().keyFunc)
 	if err != nil {
-		return -1, err
+		return ImpersonationClaims{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "impersonation" {
+		return ImpersonationClaims{}, errors.New("Invalid token")
+	}
+
+	id, err := subjectAsInt32(token.Claims)
+	if err != nil {
+		return ImpersonationClaims{}, err
+	}
+
+	role, ok := claims["role"].(string)
+	if !ok {
+		return ImpersonationClaims{}, errors.New("Invalid token")
+	}
+
+	act, ok := claims["act"].(map[string]any)
+	if !ok {
+		return ImpersonationClaims{}, errors.New("Invalid token")
+	}
+	actor, ok := act["sub"].(string)
+	if !ok {
+		return ImpersonationClaims{}, errors.New("Invalid token")
+	}
+	impersonatorID, err := strconv.ParseInt(actor, 10, 32)
+	if err != nil {
+		return ImpersonationClaims{}, errors.New("Invalid token")
+	}
+
+	imp, ok := claims["imp"].(float64)
+	if !ok {
+		return ImpersonationClaims{}, errors.New("Invalid token")
 	}
 
-	exp, err := token.Claims.GetExpirationTime()
+	return ImpersonationClaims{ID: id, Role: role, ImpersonatorID: int32(impersonatorID), ImpersonationID: int32(imp)}, nil
+}
+
+func ValidateChallengeToken(challenge_string string) (int32, error) {
+	token, err := jwt.Parse(challenge_string, keyRing().keyFunc)
 	if err != nil {
+		return -1, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != "challenge" {
 		return -1, errors.New("Invalid token")
 	}
 
-	if time.Now().After(exp.Time) {
-		return -1, errors.New("Refresh token expired")
+	return subjectAsInt32(token.Claims)
+}
+
+// GenerateOpaqueToken returns a random token, e.g. refresh token, and its hash.
+// Only the hash is stored, the token itself is handed to the client.
+func GenerateOpaqueToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
 	}
 
-	id, err := subjectAsInt32(token.Claims)
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
 
-	return id, err
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func ValidateAccessToken(access_string string) (int32, string, error) {
-	token, err := jwt.Parse(access_string, func(t *jwt.Token) (any, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+func ValidateAccessToken(access_string string) (Claims, error) {
+	token, err := jwt.Parse(access_string, keyRing().keyFunc)
 	if err != nil {
-		return -1, "", err
+		return Claims{}, err
 	}
 
+	// Challenge and impersonation tokens are signed by the same keys
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return -1, "", errors.New("Invalid token")
+	if _, typed := claims["typ"]; !ok || typed {
+		return Claims{}, errors.New("Invalid token")
 	}
 
 	exp, err := claims.GetExpirationTime()
 	if err != nil {
-		return -1, "", errors.New("Invalid token")
+		return Claims{}, errors.New("Invalid token")
 	}
 	if time.Now().After(exp.Time) {
-		return -1, "", errors.New("Access token expired")
+		return Claims{}, errors.New("Access token expired")
 	}
 
 	id, err := subjectAsInt32(token.Claims)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	role, ok := claims["role"].(string)
+	if !ok {
+		return Claims{}, errors.New("Invalid token")
+	}
+
+	sid, ok := claims["sid"].(float64)
+	if !ok {
+		return Claims{}, errors.New("Invalid token")
+	}
 
-	return id, claims["role"].(string), err
+	return Claims{ID: id, Role: role, SessionID: int32(sid)}, nil
 }
 
 func subjectAsInt32(claims jwt.Claims) (int32, error) {
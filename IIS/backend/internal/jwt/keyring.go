@@ -0,0 +1,257 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is a signing key identified by the kid header. Retired keys have
+// no private part and are kept only to verify tokens issued before rotation.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	signing any
+	verify  any
+}
+
+// KeyRing holds the active signing key and all keys accepted for verification
+type KeyRing struct {
+	active *Key
+	keys   map[string]*Key
+	// Tokens issued before key IDs were introduced carry no kid
+	legacy *Key
+	// With asymmetric keys the legacy secret is accepted only until then
+	legacyUntil time.Time
+}
+
+var (
+	ring     *KeyRing
+	ringErr  error
+	ringOnce sync.Once
+)
+
+// LoadKeys reads the key ring from the environment, it is called once on startup,
+// so that misconfiguration is found before the first login.
+//
+// With JWT_KEYS_DIR every "<kid>.pem" file in the directory is a key, either
+// a private RSA or Ed25519 key, or a public key of a retired one. JWT_SIGNING_KEY
+// selects the key used for signing. Without JWT_KEYS_DIR tokens are signed by HS256
+// with JWT_SECRET, JWT_PREVIOUS_SECRETS (comma separated) are still accepted.
+//
+// Once asymmetric keys are active JWT_SECRET is ignored, unless
+// JWT_ACCEPT_LEGACY_SECRET_UNTIL (RFC 3339) lets tokens signed by it in until then.
+func LoadKeys() error {
+	ringOnce.Do(func() {
+		ring, ringErr = loadKeyRing()
+	})
+	return ringErr
+}
+
+func keyRing() *KeyRing {
+	if err := LoadKeys(); err != nil {
+		panic(err)
+	}
+	return ring
+}
+
+func loadKeyRing() (*KeyRing, error) {
+	r := &KeyRing{keys: map[string]*Key{}}
+	secret := os.Getenv("JWT_SECRET")
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if secret == "" {
+			return nil, errors.New("neither JWT_KEYS_DIR nor JWT_SECRET is set")
+		}
+		r.legacy = hmacKey(secret)
+		r.add(r.legacy)
+		r.active = r.legacy
+		for _, previous := range strings.Split(os.Getenv("JWT_PREVIOUS_SECRETS"), ",") {
+			if previous = strings.TrimSpace(previous); previous != "" {
+				r.add(hmacKey(previous))
+			}
+		}
+		return r, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		key, err := loadKeyFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		r.add(key)
+	}
+
+	signingID := os.Getenv("JWT_SIGNING_KEY")
+	active, ok := r.keys[signingID]
+	if !ok || active.signing == nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY %q is not a private key in %s", signingID, dir)
+	}
+	r.active = active
+
+	if until := os.Getenv("JWT_ACCEPT_LEGACY_SECRET_UNTIL"); until != "" {
+		deadline, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_ACCEPT_LEGACY_SECRET_UNTIL: %w", err)
+		}
+		if secret == "" {
+			return nil, errors.New("JWT_ACCEPT_LEGACY_SECRET_UNTIL is set without JWT_SECRET")
+		}
+		r.legacy = hmacKey(secret)
+		r.legacyUntil = deadline
+		r.add(r.legacy)
+	}
+
+	return r, nil
+}
+
+func (r *KeyRing) add(key *Key) {
+	r.keys[key.ID] = key
+}
+
+// hmacKey derives the kid from the secret, so every instance names it the same
+func hmacKey(secret string) *Key {
+	sum := sha256.Sum256([]byte(secret))
+	return &Key{
+		ID:      "hs-" + hex.EncodeToString(sum[:4]),
+		Method:  jwt.SigningMethodHS256,
+		signing: []byte(secret),
+		verify:  []byte(secret),
+	}
+}
+
+func loadKeyFile(file string) (*Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	key := &Key{ID: strings.TrimSuffix(filepath.Base(file), ".pem")}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.signing = signer
+		parsed = signer.Public()
+	}
+
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+		key.verify = public
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.verify = public
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+
+	return key, nil
+}
+
+func (r *KeyRing) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(r.active.Method, claims)
+	token.Header["kid"] = r.active.ID
+
+	return token.SignedString(r.active.signing)
+}
+
+// keyFunc picks the verification key by kid and refuses tokens whose
+// algorithm differs from the key, e.g. HS256 signed by a public key.
+func (r *KeyRing) keyFunc(t *jwt.Token) (any, error) {
+	key := r.legacy
+	if kid, ok := t.Header["kid"].(string); ok {
+		key = r.keys[kid]
+	}
+	if key == nil {
+		return nil, errors.New("Unknown signing key")
+	}
+	if key == r.legacy && !r.legacyUntil.IsZero() && time.Now().After(r.legacyUntil) {
+		return nil, errors.New("Legacy signing key is no longer accepted")
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("Unexpected signing method")
+	}
+
+	return key.verify, nil
+}
+
+// JWKS returns the public keys as JSON Web Key Set, HMAC secrets are never published.
+func JWKS() map[string]any {
+	r := keyRing()
+
+	ids := make([]string, 0, len(r.keys))
+	for id := range r.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	keys := []map[string]string{}
+	for _, id := range ids {
+		key := r.keys[id]
+		switch public := key.verify.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": key.ID,
+				"use": "sig",
+				"alg": key.Method.Alg(),
+				"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"kid": key.ID,
+				"use": "sig",
+				"alg": key.Method.Alg(),
+				"x":   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return map[string]any{"keys": keys}
+}
@@ -0,0 +1,86 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Sender delivers plain text emails
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// NewSenderFromEnv returns SMTP sender when SMTP_HOST is configured,
+// otherwise emails are only written to the log.
+func NewSenderFromEnv() Sender {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogSender{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+
+	return &SMTPSender{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+}
+
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(to, subject, body string) error {
+	// Authentication is skipped for local test servers without credentials
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{to}, []byte(msg))
+}
+
+type LogSender struct{}
+
+func (LogSender) Send(to, subject, body string) error {
+	log.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// Link builds an URL of the frontend page handling the token
+func Link(path, token string) string {
+	return fmt.Sprintf("%s?token=%s", Page(path), token)
+}
+
+// Page builds an URL of the frontend page
+func Page(path string) string {
+	return strings.TrimRight(os.Getenv("APP_URL"), "/") + path
+}
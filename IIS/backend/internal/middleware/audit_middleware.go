@@ -0,0 +1,176 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package middleware
+
+import (
+	"backend/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type AuditRecorder interface {
+	Snapshot(ctx context.Context, entityType string, entityID int32) ([]byte, error)
+	Record(ctx context.Context, entry models.AuditEntry) error
+}
+
+// EntityID finds the changed entity. It is called before the handler with no response,
+// and when it finds nothing, again after the handler with the response body.
+type EntityID func(c *gin.Context, response []byte) (int32, bool)
+
+// Param takes the entity from the route, e.g. :id
+func Param(name string) EntityID {
+	return func(c *gin.Context, response []byte) (int32, bool) {
+		id, err := strconv.Atoi(c.Param(name))
+		return int32(id), err == nil
+	}
+}
+
+// Actor is the authenticated user, used when users change their own account
+func Actor(c *gin.Context, response []byte) (int32, bool) {
+	id, exists := c.Get("id")
+	if !exists {
+		return 0, false
+	}
+	return id.(int32), true
+}
+
+// BodyField takes the entity from the JSON request, the body is left for the handler
+func BodyField(name string) EntityID {
+	return func(c *gin.Context, response []byte) (int32, bool) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return 0, false
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var fields map[string]any
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return 0, false
+		}
+		id, ok := fields[name].(float64)
+		return int32(id), ok
+	}
+}
+
+// Created takes the ID of a new entity from the response
+func Created(c *gin.Context, response []byte) (int32, bool) {
+	if response == nil {
+		return 0, false
+	}
+
+	var created struct {
+		ID *int32 `json:"id"`
+	}
+	if err := json.Unmarshal(response, &created); err != nil || created.ID == nil {
+		return 0, false
+	}
+	return *created.ID, true
+}
+
+// Auditor records changes made through the API to the audit log
+type Auditor struct {
+	recorder AuditRecorder
+}
+
+func NewAuditor(recorder AuditRecorder) *Auditor {
+	return &Auditor{recorder}
+}
+
+// auditWriter keeps the response, so that IDs of created entities are known
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Audit records the action when the handler succeeds. The entity is snapshot before
+// and after the handler, entities of tournaments are listed in the tournament's log.
+// Recording never fails the request, the change is already done at that point.
+func (a *Auditor) Audit(action, entityType string, entityID EntityID) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var id int32
+		var known bool
+		if entityID != nil {
+			id, known = entityID(c, nil)
+		}
+
+		var before []byte
+		if known {
+			var err error
+			if before, err = a.recorder.Snapshot(ctx, entityType, id); err != nil {
+				log.Printf("audit snapshot of %s %d failed: %v", entityType, id, err)
+			}
+		}
+
+		var writer *auditWriter
+		if entityID != nil && !known {
+			writer = &auditWriter{ResponseWriter: c.Writer}
+			c.Writer = writer
+		}
+
+		c.Next()
+
+		// Failed requests change nothing
+		if c.IsAborted() || len(c.Errors) > 0 || c.Writer.Status() >= 400 {
+			return
+		}
+
+		if writer != nil {
+			id, known = entityID(c, writer.body.Bytes())
+		}
+
+		entry := models.AuditEntry{
+			Action:     action,
+			EntityType: entityType,
+			Before:     before,
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Status:     int32(c.Writer.Status()),
+			IP:         c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+		}
+		if known {
+			entry.EntityID = pgtype.Int4{Int32: id, Valid: true}
+			if strings.HasPrefix(entityType, "tournament") {
+				entry.TournamentID = entry.EntityID
+			}
+
+			after, err := a.recorder.Snapshot(ctx, entityType, id)
+			if err != nil {
+				log.Printf("audit snapshot of %s %d failed: %v", entityType, id, err)
+			}
+			entry.After = after
+		}
+		if actor, exists := c.Get("id"); exists {
+			entry.ActorID = pgtype.Int4{Int32: actor.(int32), Valid: true}
+		}
+		if impersonator, exists := c.Get("impersonator"); exists {
+			entry.ImpersonatorID = pgtype.Int4{Int32: impersonator.(int32), Valid: true}
+		}
+
+		if err := a.recorder.Record(ctx, entry); err != nil {
+			log.Printf("audit of %s failed: %v", action, err)
+		}
+	}
+}
@@ -5,27 +5,217 @@
 package middleware
 
 import (
+	apiErrors "backend/internal/errors"
 	"backend/internal/jwt"
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func JWTAuthMiddleware(c *gin.Context) {
+// Scopes of personal access tokens
+const (
+	ScopeRead         = "read"
+	ScopeResultsWrite = "results:write"
+	ScopeTeamsManage  = "teams:manage"
+)
+
+type SessionChecker interface {
+	IsSessionActive(ctx context.Context, sessionID int32) (bool, error)
+}
+
+type TokenResolver interface {
+	ResolveToken(ctx context.Context, token string) (int32, string, []string, error)
+}
+
+// AccountChecker refuses suspended, banned and deleted accounts
+type AccountChecker interface {
+	CheckAccount(ctx context.Context, userID int32) error
+}
+
+type ImpersonationRecorder interface {
+	IsImpersonationActive(ctx context.Context, impersonationID int32) (bool, error)
+	RecordAction(ctx context.Context, impersonationID int32, method, path string, status int) error
+}
+
+// Authenticator accepts either the access cookie of a browser session,
+// a personal access token or an impersonation token sent as "Authorization: Bearer".
+type Authenticator struct {
+	sessions       SessionChecker
+	tokens         TokenResolver
+	accounts       AccountChecker
+	impersonations ImpersonationRecorder
+	// Write routes allowed while impersonating, keyed by "METHOD /route/:param"
+	impersonatedWrites map[string]bool
+}
+
+func NewAuthenticator(sessions SessionChecker, tokens TokenResolver, accounts AccountChecker, impersonations ImpersonationRecorder) *Authenticator {
+	return &Authenticator{sessions, tokens, accounts, impersonations, map[string]bool{}}
+}
+
+// AllowImpersonation lets impersonating admins call the write route, given as registered
+// in the router. Impersonation is read-only otherwise.
+func (a *Authenticator) AllowImpersonation(method, path string) {
+	a.impersonatedWrites[method+" "+path] = true
+}
+
+// Authenticate accepts cookies everywhere, access tokens only for reading.
+func (a *Authenticator) Authenticate(c *gin.Context) {
+	scope := ""
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		scope = ScopeRead
+	}
+
+	a.authenticate(c, scope, true)
+}
+
+// Require accepts cookies and access tokens granted the scope.
+func (a *Authenticator) Require(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.authenticate(c, scope, true)
+	}
+}
+
+// SessionOnly refuses access tokens and impersonation, used for account management,
+// so that a leaked token cannot e.g. create further tokens.
+func (a *Authenticator) SessionOnly(c *gin.Context) {
+	a.authenticate(c, "", false)
+}
+
+func (a *Authenticator) authenticate(c *gin.Context, scope string, allowImpersonation bool) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if !found {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid authorization header"})
+			return
+		}
+		if claims, err := jwt.ValidateImpersonationToken(token); err == nil {
+			a.authenticateImpersonation(c, claims, allowImpersonation)
+			return
+		}
+		a.authenticateToken(c, token, scope)
+		return
+	}
+
 	access, err := c.Cookie("access")
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "You must be authorized"})
 		return
 	}
 
-	id, role, err := jwt.ValidateAccessToken(access)
+	claims, err := jwt.ValidateAccessToken(access)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
 		return
 	}
 
-	c.Set("id", id)
+	active, err := a.sessions.IsSessionActive(c.Request.Context(), claims.SessionID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal error"})
+		return
+	}
+	if !active {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": apiErrors.SessionExpired.Error()})
+		return
+	}
+	if !a.checkAccount(c, claims.ID) {
+		return
+	}
+
+	c.Set("id", claims.ID)
+	c.Set("role", claims.Role)
+	c.Set("sid", claims.SessionID)
+
+	c.Next()
+}
+
+func (a *Authenticator) authenticateToken(c *gin.Context, token, scope string) {
+	if scope == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "This action cannot be performed with an access token"})
+		return
+	}
+
+	userID, role, scopes, err := a.tokens.ResolveToken(c.Request.Context(), token)
+	if errors.Is(err, apiErrors.InvalidToken) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal error"})
+		return
+	}
+
+	if !slices.Contains(scopes, scope) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Access token is missing scope " + scope})
+		return
+	}
+	if !a.checkAccount(c, userID) {
+		return
+	}
+
+	c.Set("id", userID)
 	c.Set("role", role)
+	c.Set("scopes", scopes)
+
+	c.Next()
+}
+
+// authenticateImpersonation lets the admin look around as the user. Every response is
+// flagged and every request recorded, writes are refused unless explicitly allowed.
+func (a *Authenticator) authenticateImpersonation(c *gin.Context, claims jwt.ImpersonationClaims, allowed bool) {
+	defer a.recordImpersonation(c, claims.ImpersonationID)
+	c.Header("X-Impersonated-By", strconv.Itoa(int(claims.ImpersonatorID)))
+
+	readOnly := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+	if !allowed || !readOnly && !a.impersonatedWrites[c.Request.Method+" "+c.FullPath()] {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "This action cannot be performed while impersonating"})
+		return
+	}
+
+	active, err := a.impersonations.IsImpersonationActive(c.Request.Context(), claims.ImpersonationID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal error"})
+		return
+	}
+	if !active {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Impersonation has ended"})
+		return
+	}
+	if !a.checkAccount(c, claims.ID) {
+		return
+	}
+
+	c.Set("id", claims.ID)
+	c.Set("role", claims.Role)
+	c.Set("impersonator", claims.ImpersonatorID)
 
 	c.Next()
 }
+
+// recordImpersonation runs after the handler, so that the result is known
+func (a *Authenticator) recordImpersonation(c *gin.Context, impersonationID int32) {
+	if err := a.impersonations.RecordAction(c.Request.Context(), impersonationID, c.Request.Method, c.Request.URL.Path, c.Writer.Status()); err != nil {
+		log.Printf("recording impersonation %d failed: %v", impersonationID, err)
+	}
+}
+
+func (a *Authenticator) checkAccount(c *gin.Context, userID int32) bool {
+	err := a.accounts.CheckAccount(c.Request.Context(), userID)
+	var statusErr apiErrors.AccountStatusError
+	if errors.As(err, &statusErr) {
+		c.AbortWithStatusJSON(http.StatusForbidden, statusErr.Body())
+		return false
+	} else if errors.Is(err, apiErrors.DBNotFound) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Account no longer exists"})
+		return false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal error"})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,86 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package middleware
+
+import (
+	"backend/internal/jwt"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type activeImpersonations struct{}
+
+func (activeImpersonations) IsImpersonationActive(ctx context.Context, impersonationID int32) (bool, error) {
+	return true, nil
+}
+
+func (activeImpersonations) RecordAction(ctx context.Context, impersonationID int32, method, path string, status int) error {
+	return nil
+}
+
+type activeAccounts struct{}
+
+func (activeAccounts) CheckAccount(ctx context.Context, userID int32) error {
+	return nil
+}
+
+func TestImpersonationIsReadOnly(t *testing.T) {
+	t.Setenv("JWT_SECRET", "impersonation test secret")
+	t.Setenv("JWT_KEYS_DIR", "")
+	if err := jwt.LoadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.GenerateImpersonationToken(7, "Registered", 1, 3, time.Now().Add(time.Minute))
+
+	gin.SetMode(gin.TestMode)
+	auth := NewAuthenticator(nil, nil, activeAccounts{}, activeImpersonations{})
+	auth.AllowImpersonation(http.MethodPost, "/teams/:id/preview")
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		router.Handle(method, "/teams/:id", auth.Authenticate, ok)
+	}
+	router.POST("/teams/:id/preview", auth.Authenticate, ok)
+	router.PUT("/teams/:id/preview", auth.Authenticate, ok)
+	router.GET("/account", auth.SessionOnly, ok)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/teams/5", http.StatusOK},
+		{http.MethodHead, "/teams/5", http.StatusOK},
+		{http.MethodPost, "/teams/5", http.StatusForbidden},
+		{http.MethodPut, "/teams/5", http.StatusForbidden},
+		{http.MethodPatch, "/teams/5", http.StatusForbidden},
+		{http.MethodDelete, "/teams/5", http.StatusForbidden},
+		// Only the allowed method of the allowed route
+		{http.MethodPost, "/teams/5/preview", http.StatusOK},
+		{http.MethodPut, "/teams/5/preview", http.StatusForbidden},
+		{http.MethodGet, "/account", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.path, nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+
+			if response.Code != test.status {
+				t.Errorf("got status %d, want %d", response.Code, test.status)
+			}
+			if response.Header().Get("X-Impersonated-By") != "1" {
+				t.Errorf("response is not flagged as impersonated")
+			}
+		})
+	}
+}
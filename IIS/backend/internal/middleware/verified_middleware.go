@@ -0,0 +1,40 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VerificationChecker interface {
+	IsEmailVerified(ctx context.Context, userID int32) (bool, error)
+}
+
+// RequireVerifiedEmail blocks users who have not confirmed their email address,
+// it must follow the authentication.
+func RequireVerifiedEmail(checker VerificationChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, exists := c.Get("id")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "You must be authorized"})
+			return
+		}
+
+		verified, err := checker.IsEmailVerified(c.Request.Context(), id.(int32))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal error"})
+			return
+		}
+		if !verified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "You must verify your email address first"})
+			return
+		}
+
+		c.Next()
+	}
+}
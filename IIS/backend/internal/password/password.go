@@ -0,0 +1,183 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package password
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords found in public breach corpora, one per line
+//
+//go:embed common.txt
+var commonList string
+
+var common = func() map[string]struct{} {
+	set := map[string]struct{}{}
+	scanner := bufio.NewScanner(strings.NewReader(commonList))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			set[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return set
+}()
+
+var (
+	ErrTooShort = errors.New("Password is too short")
+	ErrTooLong  = errors.New("Password is too long")
+	ErrCommon   = errors.New("Password is too common, choose another one")
+)
+
+// Parameters recommended by OWASP for argon2id
+const (
+	argonMemory  = 64 * 1024
+	argonTime    = 3
+	argonThreads = 2
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+// bcrypt hashes only the first 72 bytes of the password
+const bcryptMaxBytes = 72
+
+// Policy contains no character class rules on purpose, length matters more,
+// passphrases with spaces and symbols are welcome. MaxBytes limits the encoded
+// length, zero means no limit.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	MaxBytes  int
+}
+
+// PolicyFromEnv reads PASSWORD_MIN_LENGTH, 10 characters by default.
+// With bcrypt the password is limited to what bcrypt can hash.
+func PolicyFromEnv() Policy {
+	policy := Policy{MinLength: 10, MaxLength: 128}
+	if minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && minLength > 0 {
+		policy.MinLength = minLength
+	}
+	if algorithm() == "bcrypt" {
+		policy.MaxBytes = bcryptMaxBytes
+	}
+	return policy
+}
+
+func (p Policy) Validate(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w, use at least %d characters", ErrTooShort, p.MinLength)
+	}
+	if length > p.MaxLength {
+		return ErrTooLong
+	}
+	// Characters outside ASCII take several bytes
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		return fmt.Errorf("%w, use at most %d bytes", ErrTooLong, p.MaxBytes)
+	}
+	if _, found := common[strings.ToLower(password)]; found {
+		return ErrCommon
+	}
+
+	return nil
+}
+
+// Validate checks the password against the policy from the environment
+func Validate(password string) error {
+	return PolicyFromEnv().Validate(password)
+}
+
+// algorithm returns PASSWORD_HASH, argon2id unless bcrypt is configured
+func algorithm() string {
+	if os.Getenv("PASSWORD_HASH") == "bcrypt" {
+		return "bcrypt"
+	}
+	return "argon2id"
+}
+
+func bcryptCost() int {
+	if cost, err := strconv.Atoi(os.Getenv("PASSWORD_BCRYPT_COST")); err == nil && cost >= bcrypt.MinCost && cost <= bcrypt.MaxCost {
+		return cost
+	}
+	return bcrypt.DefaultCost
+}
+
+// Hash hashes the password by the configured algorithm
+func Hash(password string) (string, error) {
+	if algorithm() == "bcrypt" {
+		if len(password) > bcryptMaxBytes {
+			return "", ErrTooLong
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost())
+		return string(hash), err
+	}
+
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify compares the password with the stored hash. The second result tells
+// that the hash uses older algorithm or parameters and should be replaced.
+func Verify(hash, password string) (bool, bool) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		var version, memory, time, threads int
+		parts := strings.Split(hash, "$")
+		if len(parts) != 6 {
+			return false, false
+		}
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+			return false, false
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+			return false, false
+		}
+		saltBytes, err := base64.RawStdEncoding.DecodeString(parts[4])
+		if err != nil {
+			return false, false
+		}
+		keyBytes, err := base64.RawStdEncoding.DecodeString(parts[5])
+		if err != nil {
+			return false, false
+		}
+
+		computed := argon2.IDKey([]byte(password), saltBytes, uint32(time), uint32(memory), uint8(threads), uint32(len(keyBytes)))
+		if subtle.ConstantTimeCompare(computed, keyBytes) != 1 {
+			return false, false
+		}
+
+		outdated := algorithm() != "argon2id" || version != argon2.Version ||
+			memory != argonMemory || time != argonTime || threads != argonThreads
+		return true, outdated
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	outdated := algorithm() != "bcrypt" || err != nil || cost < bcryptCost()
+
+	return true, outdated
+}
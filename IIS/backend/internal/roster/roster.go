@@ -0,0 +1,322 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package roster
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxRows limits the size of one import
+const MaxRows = 500
+
+var ErrUnsupportedFormat = errors.New("Only .csv and .xlsx files are supported")
+
+// Row is one player of the imported roster, Line is the line in the file
+type Row struct {
+	Line  int
+	Email string
+	Name  string
+	Role  string
+}
+
+// record holds the cells of one line of the file
+type record struct {
+	line  int
+	cells []string
+}
+
+// Parse reads the roster from the CSV or XLSX file. The first row is a header naming
+// the columns email, name and role in any order, the role column is optional.
+func Parse(filename string, data []byte) ([]Row, error) {
+	var records []record
+	var err error
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		records, err = readCSV(data)
+	case ".xlsx":
+		records, err = readXLSX(data)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("The file is empty")
+	}
+
+	columns := map[string]int{}
+	for i, title := range records[0].cells {
+		columns[strings.ToLower(strings.TrimSpace(title))] = i
+	}
+	for _, required := range []string{"email", "name"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("The header has no %s column", required)
+		}
+	}
+
+	cell := func(r record, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(r.cells) {
+			return ""
+		}
+		return strings.TrimSpace(r.cells[i])
+	}
+
+	rows := []Row{}
+	for _, r := range records[1:] {
+		row := Row{Line: r.line, Email: cell(r, "email"), Name: cell(r, "name"), Role: cell(r, "role")}
+		// Blank lines are common at the end of exported sheets
+		if row.Email == "" && row.Name == "" && row.Role == "" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) > MaxRows {
+		return nil, fmt.Errorf("The file has more than %d players", MaxRows)
+	}
+
+	return rows, nil
+}
+
+func readCSV(data []byte) ([]record, error) {
+	// Spreadsheet applications prepend the byte order mark
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	// Excel in many locales separates the values by semicolons
+	if line, _, _ := bytes.Cut(data, []byte("\n")); bytes.Count(line, []byte(";")) > bytes.Count(line, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	records := []record{}
+	for {
+		cells, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("The file is not a valid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record{line, cells})
+	}
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Attrs []xml.Attr `xml:",any,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Items []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Number int `xml:"r,attr"`
+		Cells  []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline struct {
+				Text string `xml:"t"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX reads the cells of the first worksheet as text
+func readXLSX(data []byte) ([]record, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("The file is not a valid XLSX: %w", err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	sheet, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := files[sheet]; !ok {
+		return nil, fmt.Errorf("The file is not a valid XLSX: no worksheet")
+	}
+
+	var shared xlsxSharedStrings
+	if file, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeXML(file, &shared); err != nil {
+			return nil, err
+		}
+	}
+	sharedText := func(i int) string {
+		if i < 0 || i >= len(shared.Items) {
+			return ""
+		}
+		item := shared.Items[i]
+		if len(item.Runs) == 0 {
+			return item.Text
+		}
+		var text strings.Builder
+		for _, run := range item.Runs {
+			text.WriteString(run.Text)
+		}
+		return text.String()
+	}
+
+	var worksheet xlsxWorksheet
+	if err := decodeXML(files[sheet], &worksheet); err != nil {
+		return nil, err
+	}
+
+	records := []record{}
+	for i, row := range worksheet.Rows {
+		// Empty rows are left out of the sheet, the row numbers are kept when present
+		line := row.Number
+		if line == 0 {
+			line = i + 1
+		}
+		cells := []string{}
+		for j, cell := range row.Cells {
+			column := columnIndex(cell.Ref)
+			if column < 0 {
+				column = j
+			}
+			// Only the first columns hold the roster, notes far to the right are ignored
+			if column >= 64 {
+				continue
+			}
+			for len(cells) <= column {
+				cells = append(cells, "")
+			}
+
+			switch cell.Type {
+			case "s":
+				index, _ := strconv.Atoi(cell.Value)
+				cells[column] = sharedText(index)
+			case "inlineStr":
+				cells[column] = cell.Inline.Text
+			default:
+				cells[column] = cell.Value
+			}
+		}
+		records = append(records, record{line, cells})
+	}
+
+	return records, nil
+}
+
+// firstSheet finds the worksheet shown first in the workbook. The workbook lists the sheets
+// in order and its relationships name their files, the file names say nothing about the order.
+func firstSheet(files map[string]*zip.File) (string, error) {
+	workbookFile, hasWorkbook := files["xl/workbook.xml"]
+	relsFile, hasRels := files["xl/_rels/workbook.xml.rels"]
+	if !hasWorkbook || !hasRels {
+		// Files written by hand or by simple tools may skip the workbook, the lowest sheet is taken
+		sheets := []string{}
+		for name := range files {
+			if strings.HasPrefix(name, "xl/worksheets/") && strings.HasSuffix(name, ".xml") {
+				sheets = append(sheets, name)
+			}
+		}
+		if len(sheets) == 0 {
+			return "", fmt.Errorf("The file is not a valid XLSX: no worksheet")
+		}
+		sort.Strings(sheets)
+		return sheets[0], nil
+	}
+
+	var workbook xlsxWorkbook
+	if err := decodeXML(workbookFile, &workbook); err != nil {
+		return "", err
+	}
+	var rels xlsxRelationships
+	if err := decodeXML(relsFile, &rels); err != nil {
+		return "", err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", fmt.Errorf("The file is not a valid XLSX: no worksheet")
+	}
+
+	// The relationship id is namespaced, strict and transitional files use different namespaces
+	id := ""
+	for _, attr := range workbook.Sheets[0].Attrs {
+		if attr.Name.Local == "id" && attr.Name.Space != "" {
+			id = attr.Value
+			break
+		}
+	}
+	for _, rel := range rels.Items {
+		if rel.ID != id {
+			continue
+		}
+		// Targets are relative to the workbook unless they start at the root of the package
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+
+	return "", fmt.Errorf("The file is not a valid XLSX: no worksheet")
+}
+
+func decodeXML(file *zip.File, v any) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := xml.NewDecoder(io.LimitReader(reader, 16<<20)).Decode(v); err != nil {
+		return fmt.Errorf("The file is not a valid XLSX: %w", err)
+	}
+
+	return nil
+}
+
+// columnIndex converts the column of the cell reference like "C7" to 2
+func columnIndex(ref string) int {
+	column := 0
+	letters := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A'+1)
+		letters++
+	}
+	if letters == 0 {
+		return -1
+	}
+
+	return column - 1
+}
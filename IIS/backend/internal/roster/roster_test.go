@@ -0,0 +1,171 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package roster
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const (
+	workbookStart = `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	workbookEnd = `</sheets></workbook>`
+	relsStart   = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	relsEnd     = `</Relationships>`
+	sheetStart  = `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	sheetEnd    = `</sheetData></worksheet>`
+)
+
+// xlsx packs the files into an archive the way spreadsheet applications do
+func xlsx(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, content := range files {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		rows []Row
+	}{
+		{
+			name: "comma",
+			data: "email,name,role\na@x.cz,Anna,Captain\nb@x.cz,Bob,\n",
+			rows: []Row{{2, "a@x.cz", "Anna", "Captain"}, {3, "b@x.cz", "Bob", ""}},
+		},
+		{
+			name: "byte order mark",
+			data: "\xef\xbb\xbfEmail,Name\na@x.cz,Anna\n",
+			rows: []Row{{2, "a@x.cz", "Anna", ""}},
+		},
+		{
+			name: "semicolon",
+			data: "name;email;role\nAnna;a@x.cz;Coach\n\"Novák, Bob\";b@x.cz;\n",
+			rows: []Row{{2, "a@x.cz", "Anna", "Coach"}, {3, "b@x.cz", "Novák, Bob", ""}},
+		},
+		{
+			name: "blank rows",
+			data: "email,name\n\na@x.cz,Anna\n,\n , \nb@x.cz,Bob\n,,\n",
+			rows: []Row{{3, "a@x.cz", "Anna", ""}, {6, "b@x.cz", "Bob", ""}},
+		},
+		{
+			name: "short rows",
+			data: "email,name,role\na@x.cz\n",
+			rows: []Row{{2, "a@x.cz", "", ""}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := Parse("roster.csv", []byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rows, test.rows) {
+				t.Errorf("got %+v, want %+v", rows, test.rows)
+			}
+		})
+	}
+}
+
+func TestParseXLSX(t *testing.T) {
+	shared := `<sst><si><t>email</t></si><si><t>name</t></si><si><r><t>An</t></r><r><t>na</t></r></si><si><t>a@x.cz</t></si></sst>`
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		rows  []Row
+	}{
+		{
+			name: "shared strings",
+			files: map[string]string{
+				"xl/workbook.xml":            workbookStart + `<sheet name="Roster" sheetId="1" r:id="rId1"/>` + workbookEnd,
+				"xl/_rels/workbook.xml.rels": relsStart + `<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>` + relsEnd,
+				"xl/sharedStrings.xml":       shared,
+				"xl/worksheets/sheet1.xml": sheetStart +
+					`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>` +
+					`<row r="2"><c r="A2" t="s"><v>3</v></c><c r="B2" t="s"><v>2</v></c></row>` + sheetEnd,
+			},
+			rows: []Row{{2, "a@x.cz", "Anna", ""}},
+		},
+		{
+			name: "inline strings",
+			files: map[string]string{
+				"xl/workbook.xml":            workbookStart + `<sheet name="Roster" sheetId="1" r:id="rId1"/>` + workbookEnd,
+				"xl/_rels/workbook.xml.rels": relsStart + `<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>` + relsEnd,
+				"xl/worksheets/sheet1.xml": sheetStart +
+					`<row r="1"><c r="A1" t="inlineStr"><is><t>name</t></is></c><c r="B1" t="inlineStr"><is><t>email</t></is></c></row>` +
+					`<row r="2"><c r="A2" t="inlineStr"><is><t>Bob</t></is></c><c r="B2" t="inlineStr"><is><t>b@x.cz</t></is></c></row>` + sheetEnd,
+			},
+			rows: []Row{{2, "b@x.cz", "Bob", ""}},
+		},
+		{
+			name: "sparse cells",
+			files: map[string]string{
+				"xl/workbook.xml":            workbookStart + `<sheet name="Roster" sheetId="1" r:id="rId1"/>` + workbookEnd,
+				"xl/_rels/workbook.xml.rels": relsStart + `<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>` + relsEnd,
+				"xl/worksheets/sheet1.xml": sheetStart +
+					`<row r="1"><c r="A1" t="inlineStr"><is><t>email</t></is></c><c r="C1" t="inlineStr"><is><t>name</t></is></c>` +
+					`<c r="D1" t="inlineStr"><is><t>role</t></is></c></row>` +
+					`<row r="4"><c r="A4" t="inlineStr"><is><t>a@x.cz</t></is></c><c r="D4" t="inlineStr"><is><t>Coach</t></is></c></row>` +
+					`<row r="7"><c r="C7" t="inlineStr"><is><t>Bob</t></is></c></row>` + sheetEnd,
+			},
+			rows: []Row{{4, "a@x.cz", "", "Coach"}, {7, "", "Bob", ""}},
+		},
+		{
+			name: "workbook order",
+			files: map[string]string{
+				"xl/workbook.xml": workbookStart + `<sheet name="Roster" sheetId="2" r:id="rId7"/>` +
+					`<sheet name="Notes" sheetId="1" r:id="rId3"/>` + workbookEnd,
+				"xl/_rels/workbook.xml.rels": relsStart + `<Relationship Id="rId3" Target="worksheets/sheet1.xml"/>` +
+					`<Relationship Id="rId7" Target="/xl/worksheets/sheet2.xml"/>` + relsEnd,
+				"xl/worksheets/sheet1.xml": sheetStart +
+					`<row r="1"><c r="A1" t="inlineStr"><is><t>notes</t></is></c></row>` + sheetEnd,
+				"xl/worksheets/sheet2.xml": sheetStart +
+					`<row r="1"><c r="A1" t="inlineStr"><is><t>email</t></is></c><c r="B1" t="inlineStr"><is><t>name</t></is></c></row>` +
+					`<row r="2"><c r="A2" t="inlineStr"><is><t>a@x.cz</t></is></c><c r="B2" t="inlineStr"><is><t>Anna</t></is></c></row>` + sheetEnd,
+			},
+			rows: []Row{{2, "a@x.cz", "Anna", ""}},
+		},
+		{
+			name: "no workbook",
+			files: map[string]string{
+				"xl/worksheets/sheet1.xml": sheetStart +
+					`<row r="1"><c r="A1" t="inlineStr"><is><t>email</t></is></c><c r="B1" t="inlineStr"><is><t>name</t></is></c></row>` +
+					`<row r="2"><c r="A2" t="inlineStr"><is><t>a@x.cz</t></is></c><c r="B2"><v>42</v></c></row>` + sheetEnd,
+			},
+			rows: []Row{{2, "a@x.cz", "42", ""}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := Parse("roster.xlsx", xlsx(t, test.files))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rows, test.rows) {
+				t.Errorf("got %+v, want %+v", rows, test.rows)
+			}
+		})
+	}
+}
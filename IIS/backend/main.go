@@ -6,9 +6,14 @@ package main
 
 import (
 	"backend/handlers"
+	"backend/internal/jwt"
+	"backend/internal/mail"
 	"backend/internal/middleware"
+	"backend/internal/oidc"
 	"backend/services"
 	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -45,11 +50,19 @@ func main() {
 	}
 
 	router := gin.Default()
+	// Client IP is used for login throttling, so X-Forwarded-For is trusted only from known proxies
+	var trustedProxies []string
+	if proxies_env := os.Getenv("TRUSTED_PROXIES"); proxies_env != "" {
+		trustedProxies = strings.Split(proxies_env, ",")
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		panic(err)
+	}
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     splitted_origins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "X-Impersonated-By"},
 		AllowCredentials: true,
 	}))
 	router.Use(middleware.ErrorHandler())
@@ -62,63 +75,208 @@ func main() {
 	registrationService := services.NewRegistrationService(dbPool, userService)
 	matchService := services.NewMatchService(dbPool)
 	teamPlayerService := services.NewTeamPlayerService(dbPool)
+	achievementService := services.NewAchievementService(dbPool)
+	predictionService := services.NewPredictionService(dbPool)
+	statisticsService := services.NewStatisticsService(dbPool)
+	sessionService := services.NewSessionService(dbPool)
+	mailer := mail.NewSenderFromEnv()
+	accountService := services.NewAccountService(dbPool, mailer)
+	twoFactorService := services.NewTwoFactorService(dbPool)
+	oidcService := services.NewOIDCService(dbPool, registrationService)
+	accessTokenService := services.NewAccessTokenService(dbPool)
+	authorizationService := services.NewAuthorizationService(dbPool)
+	throttleService := services.NewLoginThrottleService(services.NewLoginAttemptStoreFromEnv(dbPool))
+	accountStatusService := services.NewAccountStatusService(dbPool)
+	dataExportService := services.NewDataExportService(dbPool, s3Service, mailer)
+	impersonationService := services.NewImpersonationService(dbPool)
+	auditService := services.NewAuditService(dbPool)
+	teamInviteCodeService := services.NewTeamInviteCodeService(dbPool)
+	teamOwnershipService := services.NewTeamOwnershipService(dbPool)
+	pendingTeamInviteService := services.NewPendingTeamInviteService(dbPool, mailer)
+	rosterImportService := services.NewRosterImportService(dbPool)
+	rosterTimelineService := services.NewRosterTimelineService(dbPool)
+	lineupService := services.NewLineupService(dbPool)
+
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-stats" {
+		drifted, err := statisticsService.Rebuild()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("statistics rebuilt, %d subjects drifted: %s\n", len(drifted), strings.Join(drifted, ", "))
+		return
+	}
 
-	userHandler := handlers.NewUserHandler(userService, matchService, teamService, tournamentService, teamPlayerService, s3Service)
-	authHandler := handlers.NewAuthorizationHandler(registrationService)
+	if err := jwt.LoadKeys(); err != nil {
+		panic(err)
+	}
+	if err := dataExportService.ResumePending(ctx); err != nil {
+		panic(err)
+	}
+
+	userHandler := handlers.NewUserHandler(userService, matchService, teamService, tournamentService, teamPlayerService, s3Service, sessionService, accountService, authorizationService, throttleService, accountStatusService)
+	authHandler := handlers.NewAuthorizationHandler(registrationService, sessionService, accountService, throttleService)
 	overviewHandler := handlers.NewOverviewHandler(tournamentParticipantService, tournamentService, teamService, s3Service)
-	teamHandler := handlers.NewTeamHandler(teamService, s3Service, teamPlayerService, userService)
-	tournamentHandler := handlers.NewTournamentHandler(tournamentService)
-	tournamentParticipantHandler := handlers.NewTournamentParticipantHandler(tournamentParticipantService, tournamentService, teamService)
+	teamHandler := handlers.NewTeamHandler(teamService, s3Service, teamPlayerService, userService, achievementService, statisticsService, authorizationService, pendingTeamInviteService)
+	tournamentHandler := handlers.NewTournamentHandler(tournamentService, achievementService, statisticsService, authorizationService)
+	tournamentParticipantHandler := handlers.NewTournamentParticipantHandler(tournamentParticipantService, tournamentService, teamService, achievementService, statisticsService, authorizationService)
 	matchHandler := handlers.NewMatchHandler(matchService)
+	achievementHandler := handlers.NewAchievementHandler(achievementService, authorizationService)
+	predictionHandler := handlers.NewPredictionHandler(predictionService)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService, registrationService, sessionService, throttleService)
+	oidcHandler := handlers.NewOIDCHandler(oidcService, sessionService, oidc.ProvidersFromEnv())
+	accessTokenHandler := handlers.NewAccessTokenHandler(accessTokenService)
+	roleHandler := handlers.NewRoleHandler(authorizationService)
+	dataExportHandler := handlers.NewDataExportHandler(dataExportService)
+	impersonationHandler := handlers.NewImpersonationHandler(impersonationService, authorizationService)
+	auditHandler := handlers.NewAuditHandler(auditService, authorizationService)
+	teamMembershipHandler := handlers.NewTeamMembershipHandler(teamService, teamPlayerService, teamInviteCodeService, pendingTeamInviteService, rosterImportService, authorizationService)
+	teamOwnershipHandler := handlers.NewTeamOwnershipHandler(teamOwnershipService, authorizationService)
+	rosterTimelineHandler := handlers.NewRosterTimelineHandler(rosterTimelineService, teamService, userService)
+	lineupHandler := handlers.NewLineupHandler(lineupService, tournamentService, authorizationService)
+
+	// Browser sessions use cookies, scripts use personal access tokens with scopes,
+	// support staff impersonating a user send the impersonation token instead
+	auth := middleware.NewAuthenticator(sessionService, accessTokenService, accountStatusService, impersonationService)
+	// Impersonation is read-only, apart from writes which change nothing
+	auth.AllowImpersonation(http.MethodPost, "/teams/:id/roster-import/preview")
+	manageTeams := auth.Require(middleware.ScopeTeamsManage)
+	writeResults := auth.Require(middleware.ScopeResultsWrite)
+
+	// Managing teams and tournaments requires a confirmed email address
+	verified := middleware.RequireVerifiedEmail(accountService)
+
+	// Every change is recorded with the changed entity, taken from the route unless stated otherwise
+	audit := middleware.NewAuditor(auditService).Audit
+	id := middleware.Param("id")
 
 	// Team endpoints
 	router.GET("/teams", teamHandler.GetTeams)
-	router.POST("/teams", middleware.JWTAuthMiddleware, teamHandler.CreateTeam)
+	router.POST("/teams", manageTeams, verified, audit("team.create", "team", middleware.Created), teamHandler.CreateTeam)
 	router.GET("/teams/:id", teamHandler.GetTeamById)
-	router.PUT("/teams/:id", middleware.JWTAuthMiddleware, teamHandler.UpdateTeam)
-	router.POST("/teams/:id/invite", middleware.JWTAuthMiddleware, teamHandler.InvitePlayer)
-	router.PUT("/teams/:id/invite", middleware.JWTAuthMiddleware, teamHandler.ResolveInvite)
-	router.PUT("/teams/:id/avatar", middleware.JWTAuthMiddleware, teamHandler.UpdateTeamAvatar)
-	router.PUT("/teams/:id/players/:pid/state", middleware.JWTAuthMiddleware, teamHandler.ChangePlayerState)
+	router.GET("/teams/:id/results", teamHandler.GetTeamResults)
+	router.GET("/teams/:id/timeline", rosterTimelineHandler.GetTeamTimeline)
+	router.PUT("/teams/:id", manageTeams, verified, audit("team.update", "team", id), teamHandler.UpdateTeam)
+	router.DELETE("/teams/:id", manageTeams, verified, audit("team.disband", "team", id), teamOwnershipHandler.DisbandTeam)
+	router.POST("/teams/:id/transfer", manageTeams, verified, audit("team.transfer.offer", "team", id), teamOwnershipHandler.TransferTeam)
+	router.PUT("/teams/:id/transfer", manageTeams, audit("team.transfer.resolve", "team", id), teamOwnershipHandler.ResolveTransfer)
+	router.DELETE("/teams/:id/transfer", manageTeams, verified, audit("team.transfer.cancel", "team", id), teamOwnershipHandler.CancelTransfer)
+	router.POST("/teams/:id/invite", manageTeams, verified, audit("team.invite", "team.roster", id), teamHandler.InvitePlayer)
+	router.PUT("/teams/:id/invite", manageTeams, verified, audit("team.invite.resolve", "team.roster", id), teamHandler.ResolveInvite)
+	router.PUT("/teams/:id/avatar", manageTeams, verified, audit("team.avatar", "team", id), teamHandler.UpdateTeamAvatar)
+	router.PUT("/teams/:id/players/:pid/state", manageTeams, verified, audit("team.player.state", "team.roster", id), teamHandler.ChangePlayerState)
+	router.PUT("/teams/:id/players/:pid/squad", manageTeams, verified, audit("team.player.squad", "team.roster", id), teamHandler.ChangePlayerSquad)
+	router.GET("/teams/:id/join-requests", manageTeams, teamMembershipHandler.GetJoinRequests)
+	router.POST("/teams/:id/join-requests", manageTeams, audit("team.join.request", "team.roster", id), teamMembershipHandler.RequestJoin)
+	router.PUT("/teams/:id/join-requests", manageTeams, verified, audit("team.join.resolve", "team.roster", id), teamMembershipHandler.ResolveJoinRequest)
+	router.DELETE("/teams/:id/join-requests", manageTeams, audit("team.join.cancel", "team.roster", id), teamMembershipHandler.CancelJoinRequest)
+	router.GET("/teams/:id/invite-codes", manageTeams, teamMembershipHandler.GetInviteCodes)
+	router.POST("/teams/:id/invite-codes", manageTeams, verified, audit("team.invite_code.create", "team", id), teamMembershipHandler.CreateInviteCode)
+	router.DELETE("/teams/:id/invite-codes/:cid", manageTeams, verified, audit("team.invite_code.revoke", "team", id), teamMembershipHandler.RevokeInviteCode)
+	router.GET("/teams/:id/pending-invites", manageTeams, teamMembershipHandler.GetPendingInvites)
+	router.DELETE("/teams/:id/pending-invites/:iid", manageTeams, verified, audit("team.pending_invite.cancel", "team.pending_invites", id), teamMembershipHandler.CancelPendingInvite)
+	router.POST("/teams/:id/roster-import/preview", manageTeams, verified, teamMembershipHandler.PreviewRosterImport)
+	router.POST("/teams/:id/roster-import", manageTeams, verified, audit("team.roster.import", "team.roster", id), teamMembershipHandler.ImportRoster)
+	router.POST("/teams/join", manageTeams, audit("team.invite_code.redeem", "team.roster", middleware.Created), teamMembershipHandler.RedeemInviteCode)
+	router.GET("/teams/:id/roles", roleHandler.GetTeamRoles)
+	router.POST("/teams/:id/roles", manageTeams, verified, audit("team.role.grant", "team.roles", id), roleHandler.GrantTeamRole)
+	router.DELETE("/teams/:id/roles/:rid", manageTeams, verified, audit("team.role.revoke", "team.roles", id), roleHandler.RevokeTeamRole)
 
 	// Tournament endpoints
 	router.GET("/tournaments", tournamentHandler.GetTournaments)
 	router.GET("/tournaments/:id", tournamentHandler.GetTournamentById)
 	router.GET("/tournaments/:id/bracket", tournamentHandler.GetTournamentBracket)
-	router.PUT("/tournaments/:id/bracket", middleware.JWTAuthMiddleware, tournamentHandler.UpdateTournamentBracket)
-	router.POST("/tournaments/:id/participants", middleware.JWTAuthMiddleware, tournamentParticipantHandler.CreateParticipant)
-	router.PUT("/tournaments/:id/participants", middleware.JWTAuthMiddleware, tournamentParticipantHandler.ResolveParticipant)
-	router.POST("/tournaments", middleware.JWTAuthMiddleware, tournamentHandler.CreateTournament)
-	router.PUT("/tournaments/:id", middleware.JWTAuthMiddleware, tournamentHandler.UpdateTournament)
-	router.DELETE("/tournaments/:id", middleware.JWTAuthMiddleware, tournamentHandler.DeleteTournament)
-	router.POST("/tournaments/:id/start", middleware.JWTAuthMiddleware, tournamentHandler.StartTournament)
+	router.PUT("/tournaments/:id/bracket", writeResults, verified, audit("tournament.bracket.update", "tournament.bracket", id), tournamentHandler.UpdateTournamentBracket)
+	router.POST("/tournaments/:id/participants", auth.Authenticate, verified, audit("tournament.participant.join", "tournament.participants", id), tournamentParticipantHandler.CreateParticipant)
+	router.PUT("/tournaments/:id/participants", auth.Authenticate, verified, audit("tournament.participant.resolve", "tournament.participants", id), tournamentParticipantHandler.ResolveParticipant)
+	router.GET("/tournaments/:id/participants/:pid/lineup", lineupHandler.GetLineup)
+	router.PUT("/tournaments/:id/participants/:pid/lineup", manageTeams, verified, audit("tournament.lineup.update", "tournament.lineups", id), lineupHandler.UpdateLineup)
+	router.POST("/tournaments/:id/participants/:pid/substitutions", manageTeams, verified, audit("tournament.substitution.request", "tournament.lineups", id), lineupHandler.RequestSubstitution)
+	router.GET("/tournaments/:id/substitutions", auth.Authenticate, lineupHandler.GetSubstitutions)
+	router.PUT("/tournaments/:id/substitutions/:sid", auth.Authenticate, verified, audit("tournament.substitution.resolve", "tournament.lineups", id), lineupHandler.ResolveSubstitution)
+	router.POST("/tournaments", auth.Authenticate, verified, audit("tournament.create", "tournament", middleware.Created), tournamentHandler.CreateTournament)
+	router.PUT("/tournaments/:id", auth.Authenticate, verified, audit("tournament.update", "tournament", id), tournamentHandler.UpdateTournament)
+	router.DELETE("/tournaments/:id", auth.Authenticate, verified, audit("tournament.delete", "tournament", id), tournamentHandler.DeleteTournament)
+	router.POST("/tournaments/:id/start", auth.Authenticate, verified, audit("tournament.start", "tournament.bracket", id), tournamentHandler.StartTournament)
+	router.GET("/tournaments/:id/predictions", auth.Authenticate, predictionHandler.GetMyPredictions)
+	router.PUT("/tournaments/:id/predictions", auth.Authenticate, audit("prediction.save", "prediction", id), predictionHandler.SavePredictions)
+	router.GET("/tournaments/:id/predictions/leaderboard", predictionHandler.GetLeaderboard)
+	router.GET("/tournaments/:id/roles", roleHandler.GetTournamentRoles)
+	router.GET("/tournaments/:id/audit", auth.Authenticate, auditHandler.GetTournamentAuditLog)
+	router.POST("/tournaments/:id/roles", auth.Authenticate, verified, audit("tournament.role.grant", "tournament.roles", id), roleHandler.GrantTournamentRole)
+	router.DELETE("/tournaments/:id/roles/:rid", auth.Authenticate, verified, audit("tournament.role.revoke", "tournament.roles", id), roleHandler.RevokeTournamentRole)
 
 	// Misc
 	router.GET("/players", tournamentParticipantHandler.GetPlayers)
 	router.GET("/players/:id", tournamentParticipantHandler.GetPlayerById)
+	router.GET("/players/:id/results", tournamentParticipantHandler.GetPlayerResults)
+	router.GET("/players/:id/teams", rosterTimelineHandler.GetPlayerTimeline)
+	router.GET("/transfers", rosterTimelineHandler.GetTransfers)
 	router.GET("/user", userHandler.SearchUser)
 	router.GET("/matches", matchHandler.GetMatches)
 	router.GET("/overview", overviewHandler.GetOverview)
+	router.GET("/achievements", achievementHandler.GetAchievements)
 
 	userGroup := router.Group("/user")
-	userGroup.Use(middleware.JWTAuthMiddleware)
+	userGroup.Use(auth.Authenticate)
 	userGroup.GET("/profile/me", userHandler.GetMe)
 	userGroup.GET("/profile/details", userHandler.GetProfile)
-	userGroup.PUT("/profile/me", userHandler.UpdateMe)
+	userGroup.PUT("/profile/me", audit("user.update", "user", middleware.Actor), userHandler.UpdateMe)
+	userGroup.GET("/team-transfers", teamOwnershipHandler.GetPendingTransfers)
+
+	// Account security cannot be managed with access tokens
+	accountGroup := router.Group("/user")
+	accountGroup.Use(auth.SessionOnly)
+	accountGroup.GET("/sessions", authHandler.GetSessions)
+	accountGroup.DELETE("/sessions/:id", audit("user.session.revoke", "user.account", middleware.Actor), authHandler.RevokeSession)
+	accountGroup.DELETE("/sessions", audit("user.session.revoke_all", "user.account", middleware.Actor), authHandler.RevokeAllSessions)
+	accountGroup.POST("/2fa/enroll", audit("user.2fa.enroll", "user", middleware.Actor), twoFactorHandler.Enroll)
+	accountGroup.POST("/2fa/verify", audit("user.2fa.confirm", "user", middleware.Actor), twoFactorHandler.ConfirmEnrollment)
+	accountGroup.POST("/2fa/disable", audit("user.2fa.disable", "user", middleware.Actor), twoFactorHandler.Disable)
+	accountGroup.POST("/2fa/recovery-codes", audit("user.2fa.recovery_codes", "user.account", middleware.Actor), twoFactorHandler.RegenerateRecoveryCodes)
+	accountGroup.GET("/tokens", accessTokenHandler.GetTokens)
+	accountGroup.POST("/tokens", audit("user.token.create", "user.account", middleware.Actor), accessTokenHandler.CreateToken)
+	accountGroup.DELETE("/tokens/:id", audit("user.token.revoke", "user.account", middleware.Actor), accessTokenHandler.RevokeToken)
+	accountGroup.DELETE("/profile/me", audit("user.delete", "user.account", middleware.Actor), userHandler.DeleteMe)
+	accountGroup.GET("/exports", dataExportHandler.GetExports)
+	accountGroup.POST("/exports", audit("user.export", "user.account", middleware.Actor), dataExportHandler.RequestExport)
+	accountGroup.GET("/exports/:id/download", dataExportHandler.DownloadExport)
 
 	adminGroup := router.Group("/admin")
-	adminGroup.Use(middleware.JWTAuthMiddleware)
+	adminGroup.Use(auth.Authenticate)
 	adminGroup.GET("/users", userHandler.GetAllUsers)
-	adminGroup.PUT("/users/:id", userHandler.AdminUpdateUser)
+	adminGroup.PUT("/users/:id", audit("user.admin_update", "user", id), userHandler.AdminUpdateUser)
+	adminGroup.POST("/users/:id/unlock", audit("user.unlock", "user", id), userHandler.UnlockUser)
+	adminGroup.PUT("/users/:id/status", audit("user.status", "user", id), userHandler.UpdateUserStatus)
+	adminGroup.POST("/users/:id/impersonate", audit("user.impersonate", "impersonation", middleware.Created), impersonationHandler.Impersonate)
+	adminGroup.GET("/impersonations", impersonationHandler.GetImpersonations)
+	adminGroup.GET("/impersonations/:id/actions", impersonationHandler.GetImpersonationActions)
+	adminGroup.GET("/audit", auditHandler.GetAuditLog)
+	adminGroup.DELETE("/impersonations/:id", audit("impersonation.end", "impersonation", id), impersonationHandler.EndImpersonation)
 	adminGroup.GET("/tournaments", tournamentHandler.GetAdminTournaments)
-	adminGroup.PUT("/tournaments/state", tournamentHandler.UpdateTournamentState)
+	adminGroup.PUT("/tournaments/state", audit("tournament.state", "tournament", middleware.BodyField("id")), tournamentHandler.UpdateTournamentState)
+	adminGroup.POST("/achievements", audit("achievement.create", "achievement", middleware.Created), achievementHandler.CreateAchievement)
+	adminGroup.PUT("/achievements/:id", audit("achievement.update", "achievement", id), achievementHandler.UpdateAchievement)
+	adminGroup.POST("/achievements/evaluate", audit("achievement.evaluate", "", nil), achievementHandler.EvaluateAchievements)
+
+	// Public keys for services verifying our access tokens
+	router.GET("/.well-known/jwks.json", authHandler.GetJWKS)
 
 	authUser := router.Group("/auth")
-	authUser.POST("/register", authHandler.Register)
+	authUser.POST("/register", audit("user.register", "user", middleware.Created), authHandler.Register)
 	authUser.POST("/login", authHandler.Login)
-	authUser.POST("/refresh", authHandler.Refresh)
+	authUser.POST("/login/2fa", twoFactorHandler.Login)
+	authUser.POST("/login/2fa/enroll", audit("user.2fa.enroll", "user", middleware.Actor), twoFactorHandler.LoginEnroll)
+	authUser.GET("/oidc/providers", oidcHandler.GetProviders)
+	authUser.GET("/oidc/:provider/login", oidcHandler.Login)
+	// Accounts created or linked by the callback are audited by the service, as it always redirects
+	authUser.GET("/oidc/:provider/callback", oidcHandler.Callback)
+	authUser.POST("/refresh", audit("user.session.refresh", "user.account", middleware.Actor), authHandler.Refresh)
 	authUser.POST("/logout", authHandler.Logout)
-	authUser.GET("/user/me", middleware.JWTAuthMiddleware, authHandler.GetMe)
+	authUser.GET("/user/me", auth.Authenticate, authHandler.GetMe)
+	authUser.POST("/password/forgot", authHandler.ForgotPassword)
+	authUser.POST("/password/reset", audit("user.password.reset", "", nil), authHandler.ResetPassword)
+	authUser.POST("/email/verify", audit("user.email.verify", "", nil), authHandler.VerifyEmail)
+	authUser.POST("/email/resend", auth.SessionOnly, authHandler.ResendVerification)
 
 	if err := router.Run(":8080"); err != nil {
 		panic(err)
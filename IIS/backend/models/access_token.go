@@ -0,0 +1,29 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type AccessToken struct {
+	ID         int32            `json:"id"`
+	Name       string           `json:"name"`
+	Scopes     []string         `json:"scopes"`
+	CreatedAt  pgtype.Timestamp `json:"created_at"`
+	ExpiresAt  pgtype.Timestamp `json:"expires_at"`
+	LastUsedAt pgtype.Timestamp `json:"last_used_at"`
+}
+
+type CreateAccessTokenRequest struct {
+	Name   string   `json:"name" binding:"required,max=64"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,oneof=read results:write teams:manage"`
+	// Token never expires when omitted
+	ExpiresInDays *int32 `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+// CreatedAccessToken is the only response containing the token itself
+type CreatedAccessToken struct {
+	AccessToken
+	Token string `json:"token"`
+}
@@ -0,0 +1,38 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type Achievement struct {
+	ID          int32       `json:"id"`
+	Code        string      `json:"code"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Subject     string      `json:"subject"`
+	Metric      string      `json:"metric"`
+	Threshold   int32       `json:"threshold"`
+	Discipline  pgtype.Text `json:"discipline"`
+	Active      bool        `json:"active"`
+}
+
+type AchievementRequest struct {
+	Code        string  `json:"code" binding:"required,max=50"`
+	Name        string  `json:"name" binding:"required,max=50"`
+	Description string  `json:"description" binding:"max=300"`
+	Subject     string  `json:"subject" binding:"required,oneof=Player Team Any"`
+	Metric      string  `json:"metric" binding:"required,oneof=matches_played matches_won win_streak tournaments_won podiums undefeated_tournaments disciplines"`
+	Threshold   int32   `json:"threshold" binding:"required,min=1"`
+	Discipline  *string `json:"discipline"`
+	Active      *bool   `json:"active"`
+}
+
+type EarnedAchievement struct {
+	ID          int32            `json:"id"`
+	Code        string           `json:"code"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	EarnedAt    pgtype.Timestamp `json:"earned_at"`
+}
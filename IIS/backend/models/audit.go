@@ -0,0 +1,45 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type AuditEntry struct {
+	ID             int64            `json:"id"`
+	ActorID        pgtype.Int4      `json:"actor_id"`
+	ActorEmail     pgtype.Text      `json:"actor_email"`
+	ActorName      pgtype.Text      `json:"actor_name"`
+	ActorSurname   pgtype.Text      `json:"actor_surname"`
+	ImpersonatorID pgtype.Int4      `json:"impersonator_id"`
+	Action         string           `json:"action"`
+	EntityType     string           `json:"entity_type"`
+	EntityID       pgtype.Int4      `json:"entity_id"`
+	TournamentID   pgtype.Int4      `json:"tournament_id"`
+	Before         json.RawMessage  `json:"before"`
+	After          json.RawMessage  `json:"after"`
+	Method         string           `json:"method"`
+	Path           string           `json:"path"`
+	Status         int32            `json:"status"`
+	IP             string           `json:"ip,omitempty"`
+	UserAgent      string           `json:"user_agent,omitempty"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+}
+
+// AuditQuery filters the audit log, empty fields match everything
+type AuditQuery struct {
+	Page         int    `form:"page" binding:"required,min=1"`
+	Limit        int    `form:"limit" binding:"required,min=1,max=200"`
+	ActorID      int32  `form:"actor_id"`
+	Action       string `form:"action"`
+	EntityType   string `form:"entity_type"`
+	EntityID     int32  `form:"entity_id"`
+	TournamentID int32  `form:"tournament_id"`
+	From         string `form:"from" binding:"omitempty,datetime=2006-01-02"`
+	To           string `form:"to" binding:"omitempty,datetime=2006-01-02"`
+}
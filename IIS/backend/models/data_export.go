@@ -0,0 +1,15 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type DataExport struct {
+	ID          int32            `json:"id"`
+	State       string           `json:"state"`
+	CreatedAt   pgtype.Timestamp `json:"created_at"`
+	CompletedAt pgtype.Timestamp `json:"completed_at"`
+	ExpiresAt   pgtype.Timestamp `json:"expires_at"`
+}
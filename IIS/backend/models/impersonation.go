@@ -0,0 +1,43 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type ImpersonateRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// ImpersonationToken is sent as "Authorization: Bearer", the admin's own session stays intact
+type ImpersonationToken struct {
+	ID        int32     `json:"id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	User      BaseUser  `json:"user"`
+}
+
+type Impersonation struct {
+	ID         int32            `json:"id"`
+	AdminID    int32            `json:"admin_id"`
+	AdminEmail string           `json:"admin_email"`
+	UserID     int32            `json:"user_id"`
+	UserEmail  string           `json:"user_email"`
+	Reason     string           `json:"reason"`
+	StartedAt  pgtype.Timestamp `json:"started_at"`
+	ExpiresAt  pgtype.Timestamp `json:"expires_at"`
+	EndedAt    pgtype.Timestamp `json:"ended_at"`
+	Actions    int32            `json:"actions"`
+}
+
+type ImpersonationAction struct {
+	Method    string           `json:"method"`
+	Path      string           `json:"path"`
+	Status    int32            `json:"status"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
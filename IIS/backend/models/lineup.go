@@ -0,0 +1,53 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type LineupPlayer struct {
+	UserID  int32       `json:"id"`
+	Name    pgtype.Text `json:"name"`
+	Surname pgtype.Text `json:"surname"`
+	Squad   string      `json:"squad"`
+}
+
+// TournamentLineup lists players the team registered for the tournament
+type TournamentLineup struct {
+	ParticipantID int32            `json:"participant_id"`
+	TeamID        int32            `json:"team_id"`
+	Locked        bool             `json:"locked"`
+	LocksAt       pgtype.Timestamp `json:"locks_at"`
+	Players       []LineupPlayer   `json:"players"`
+}
+
+type UpdateLineupRequest struct {
+	UserIDs []int32 `json:"user_ids" binding:"required,min=1,dive,min=1"`
+}
+
+type LineupSubstitution struct {
+	ID            int32            `json:"id"`
+	ParticipantID int32            `json:"participant_id"`
+	TeamName      string           `json:"team_name"`
+	OutUserID     int32            `json:"out_user_id"`
+	OutName       pgtype.Text      `json:"out_name"`
+	OutSurname    pgtype.Text      `json:"out_surname"`
+	InUserID      int32            `json:"in_user_id"`
+	InName        pgtype.Text      `json:"in_name"`
+	InSurname     pgtype.Text      `json:"in_surname"`
+	Reason        string           `json:"reason"`
+	State         string           `json:"state"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
+	ResolvedAt    pgtype.Timestamp `json:"resolved_at"`
+}
+
+type CreateSubstitutionRequest struct {
+	OutUserID int32  `json:"out_user_id" binding:"required"`
+	InUserID  int32  `json:"in_user_id" binding:"required,nefield=OutUserID"`
+	Reason    string `json:"reason" binding:"required,max=300"`
+}
+
+type ResolveSubstitutionRequest struct {
+	Result string `json:"result" binding:"required,oneof=Approve Reject"`
+}
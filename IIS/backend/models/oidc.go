@@ -0,0 +1,11 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+// OIDCProvider is an external identity provider offered on the login page
+type OIDCProvider struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
@@ -0,0 +1,18 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+// PendingTeamInvite is an invite of an email, which has no account yet
+type PendingTeamInvite struct {
+	ID        int32            `json:"id"`
+	TeamID    int32            `json:"team_id"`
+	Email     string           `json:"email"`
+	Name      pgtype.Text      `json:"name"`
+	Squad     string           `json:"squad"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
@@ -0,0 +1,19 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type Placement struct {
+	TournamentID   int32            `json:"tournament_id"`
+	TournamentName string           `json:"tournament_name"`
+	Discipline     string           `json:"discipline"`
+	ParticipantID  int32            `json:"participant_id"`
+	Name           string           `json:"name"`
+	Place          int32            `json:"place"`
+	PlaceText      string           `json:"place_text"`
+	Prize          int32            `json:"prize"`
+	FinishedAt     pgtype.Timestamp `json:"finished_at"`
+}
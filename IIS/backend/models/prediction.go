@@ -0,0 +1,40 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type Prediction struct {
+	Level         int32            `json:"level"`
+	Slot          int32            `json:"slot"`
+	MatchID       pgtype.Int4      `json:"match_id"` // null => bracket not generated yet
+	ParticipantID int32            `json:"participant_id"`
+	Points        pgtype.Int4      `json:"points"` // null => match not decided yet
+	Locked        bool             `json:"locked"`
+	UpdatedAt     pgtype.Timestamp `json:"updated_at"`
+}
+
+// PredictionPick names the match by its stage level and position within the stage,
+// once the bracket is generated the match ID can be given instead.
+type PredictionPick struct {
+	MatchID       int32 `json:"match_id"`
+	Level         int32 `json:"level" binding:"omitempty,min=1"`
+	Slot          int32 `json:"slot" binding:"omitempty,min=1"`
+	ParticipantID int32 `json:"participant_id" binding:"required"`
+}
+
+type PredictionRequest struct {
+	Predictions []PredictionPick `json:"predictions" binding:"required,min=1,dive"`
+}
+
+type PredictionStanding struct {
+	Rank        int32  `json:"rank"`
+	UserID      int32  `json:"user_id"`
+	Name        string `json:"name"`
+	Surname     string `json:"surname"`
+	Points      int32  `json:"points"`
+	Correct     int32  `json:"correct"`
+	Predictions int32  `json:"predictions"`
+}
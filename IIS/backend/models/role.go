@@ -0,0 +1,27 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+// RoleAssignment is a role granted by the owner of a tournament or team
+type RoleAssignment struct {
+	ID        int32            `json:"id"`
+	UserID    int32            `json:"user_id"`
+	Name      pgtype.Text      `json:"name"`
+	Surname   pgtype.Text      `json:"surname"`
+	Role      string           `json:"role"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type GrantTournamentRoleRequest struct {
+	UserID int32  `json:"user_id" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=CoOrganizer Referee"`
+}
+
+type GrantTeamRoleRequest struct {
+	UserID int32  `json:"user_id" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=Captain CoManager"`
+}
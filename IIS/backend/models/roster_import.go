@@ -0,0 +1,30 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+// Outcomes of an imported row
+const (
+	RosterImportInvite        = "Invite"
+	RosterImportPendingInvite = "PendingInvite"
+	RosterImportError         = "Error"
+)
+
+type RosterImportRow struct {
+	Line    int    `json:"line"`
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Squad   string `json:"squad"`
+	Action  string `json:"action"`
+	Message string `json:"message,omitempty"`
+}
+
+// RosterImportReport describes every row of the file, Applied is false for a preview
+type RosterImportReport struct {
+	Applied        bool              `json:"applied"`
+	Invites        int               `json:"invites"`
+	PendingInvites int               `json:"pending_invites"`
+	Errors         int               `json:"errors"`
+	Rows           []RosterImportRow `json:"rows"`
+}
@@ -0,0 +1,41 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+// TeamTimelineEntry is one membership of the team, current members have no until
+type TeamTimelineEntry struct {
+	UserID  int32            `json:"user_id"`
+	Name    pgtype.Text      `json:"name"`
+	Surname pgtype.Text      `json:"surname"`
+	Squad   string           `json:"squad"`
+	Since   pgtype.Timestamp `json:"since"`
+	Until   pgtype.Timestamp `json:"until"`
+}
+
+// PlayerTimelineEntry is one membership of the player
+type PlayerTimelineEntry struct {
+	TeamID     int32            `json:"team_id"`
+	TeamName   string           `json:"team_name"`
+	Squad      string           `json:"squad"`
+	Since      pgtype.Timestamp `json:"since"`
+	Until      pgtype.Timestamp `json:"until"`
+	ArchivedAt pgtype.Timestamp `json:"team_archived_at"`
+}
+
+// TransferEvent is a player joining or leaving a team, a join tells the team
+// the player left last before it
+type TransferEvent struct {
+	Type         string           `json:"type"`
+	Date         pgtype.Timestamp `json:"date"`
+	UserID       int32            `json:"user_id"`
+	Name         pgtype.Text      `json:"name"`
+	Surname      pgtype.Text      `json:"surname"`
+	TeamID       int32            `json:"team_id"`
+	TeamName     string           `json:"team_name"`
+	FromTeamID   pgtype.Int4      `json:"from_team_id"`
+	FromTeamName pgtype.Text      `json:"from_team_name"`
+}
@@ -0,0 +1,17 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type Session struct {
+	ID         int32            `json:"id"`
+	UserAgent  string           `json:"user_agent"`
+	IP         string           `json:"ip"`
+	CreatedAt  pgtype.Timestamp `json:"created_at"`
+	LastUsedAt pgtype.Timestamp `json:"last_used_at"`
+	ExpiresAt  pgtype.Timestamp `json:"expires_at"`
+	Current    bool             `json:"current"`
+}
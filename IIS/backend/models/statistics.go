@@ -20,3 +20,11 @@ type ActivityStatistic struct {
 	Personal int    `json:"personal"`
 	Teams    int    `json:"teams"`
 }
+
+// ProfileStatistics are the precomputed statistics shown on player and team detail
+type ProfileStatistics struct {
+	Winrate     WinrateStatistic
+	Winnings    int32
+	Disciplines []DisciplineStatistic
+	Activity    map[string]ActivityStatistic
+}
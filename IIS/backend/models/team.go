@@ -9,20 +9,22 @@ import (
 )
 
 type Team struct {
-	ID          int32       `json:"id"`
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	Image       string      `json:"image"`
-	Since       pgtype.Date `json:"since"`
-	ManagerID   int32       `json:"manager_id"`
+	ID          int32            `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Image       string           `json:"image"`
+	Since       pgtype.Date      `json:"since"`
+	ManagerID   int32            `json:"manager_id"`
+	ArchivedAt  pgtype.Timestamp `json:"archived_at"`
 }
 
 type TeamBaseResponse struct {
-	ID          int32       `json:"id"`
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	Image       string      `json:"image"`
-	Since       pgtype.Date `json:"since"`
+	ID          int32            `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Image       string           `json:"image"`
+	Since       pgtype.Date      `json:"since"`
+	ArchivedAt  pgtype.Timestamp `json:"archived_at"`
 }
 
 type TeamAndPlayers struct {
@@ -33,12 +35,13 @@ type TeamAndPlayers struct {
 
 type TeamDetail struct {
 	TeamBaseResponse
-	Manager     Player                `json:"manager"`
-	Winnings    int32                 `json:"winnings"`
-	Players     []Player              `json:"players"`
-	Winrate     WinrateStatistic      `json:"winrate"`
-	Disciplines []DisciplineStatistic `json:"disciplines"`
-	Activity    []ActivityStatistic   `json:"activity"`
+	Manager      Player                `json:"manager"`
+	Winnings     int32                 `json:"winnings"`
+	Players      []Player              `json:"players"`
+	Winrate      WinrateStatistic      `json:"winrate"`
+	Disciplines  []DisciplineStatistic `json:"disciplines"`
+	Activity     []ActivityStatistic   `json:"activity"`
+	Achievements []EarnedAchievement   `json:"achievements"`
 }
 
 type CreateTeamRequest struct {
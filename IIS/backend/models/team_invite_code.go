@@ -0,0 +1,28 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type TeamInviteCode struct {
+	ID        int32            `json:"id"`
+	TeamID    int32            `json:"team_id"`
+	Code      string           `json:"code"`
+	Link      string           `json:"link"`
+	MaxUses   pgtype.Int4      `json:"max_uses"`
+	Uses      int32            `json:"uses"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+type CreateTeamInviteCodeRequest struct {
+	ExpiresInHours int32 `json:"expires_in_hours" binding:"required,min=1,max=720"`
+	// Code can be used any number of times when omitted
+	MaxUses *int32 `json:"max_uses" binding:"omitempty,min=1,max=1000"`
+}
+
+type RedeemTeamInviteCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
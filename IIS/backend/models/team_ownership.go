@@ -0,0 +1,27 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package models
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+type TeamOwnershipTransfer struct {
+	ID          int32            `json:"id"`
+	TeamID      int32            `json:"team_id"`
+	TeamName    string           `json:"team_name"`
+	FromUserID  int32            `json:"from_user_id"`
+	FromName    pgtype.Text      `json:"from_name"`
+	FromSurname pgtype.Text      `json:"from_surname"`
+	ToUserID    int32            `json:"to_user_id"`
+	State       string           `json:"state"`
+	CreatedAt   pgtype.Timestamp `json:"created_at"`
+}
+
+type TeamOwnershipTransferRequest struct {
+	UserID int32 `json:"user_id" binding:"required"`
+}
+
+type ResolveTeamOwnershipTransferRequest struct {
+	Result string `json:"result" binding:"required,oneof=Accept Reject"`
+}
@@ -15,6 +15,7 @@ type TeamPlayer struct {
 	Since  pgtype.Timestamp `json:"since"`
 	Until  pgtype.Timestamp `json:"until"`
 	State  string           `json:"state"`
+	Squad  string           `json:"squad"`
 }
 
 type TeamUserPlayer struct {
@@ -23,6 +24,7 @@ type TeamUserPlayer struct {
 	Name     string `json:"name"`
 	Surname  string `json:"surname"`
 	State    string `json:"state"`
+	Squad    string `json:"squad"`
 }
 
 type TeamPlayerInvite struct {
@@ -46,3 +48,13 @@ type ResolveInviteRequest struct {
 type TeamPlayerStateRequest struct {
 	CurrentState string `json:"state" binding:"required,oneof=Active Inactive Invited"`
 }
+
+type TeamPlayerSquadRequest struct {
+	Squad string `json:"squad" binding:"required,oneof=Starter Substitute"`
+}
+
+// TeamSquadCount is the number of active players of the team by squad
+type TeamSquadCount struct {
+	Starters    int32
+	Substitutes int32
+}
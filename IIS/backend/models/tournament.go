@@ -4,7 +4,11 @@
  */
 package models
 
-import "github.com/jackc/pgx/v5/pgtype"
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
 
 type Tournament struct {
 	ID              int32  `json:"id"`
@@ -24,13 +28,20 @@ type CreateTournamentRequest struct {
 	Prize           int32  `json:"prize" binding:"min=0"`
 	MinLimit        *int32 `json:"min_limit" binding:"omitempty,min=1"`
 	MaxLimit        *int32 `json:"max_limit" binding:"omitempty,min=1"`
+	// Substitutes are counted as other players when omitted
+	MaxSubstitutes *int32  `json:"max_substitutes" binding:"omitempty,min=0"`
+	Payout         []int32 `json:"payout" binding:"omitempty,dive,min=0,max=100"`
+	// Planned start, lineups of teams lock LineupLockHours before it
+	StartsAt        *time.Time `json:"starts_at"`
+	LineupLockHours *int32     `json:"lineup_lock_hours" binding:"omitempty,min=0,max=720"`
 }
 
 type MatchParticipant struct {
 	ID         pgtype.Int4 `json:"id"`
 	ResultText *string     `json:"result_text"`
 	IsWinner   bool        `json:"is_winner"`
-	Name       *string     `json:"name"` // nil => TBD
+	Name       *string     `json:"name"`   // nil => TBD
+	Lineup     []int32     `json:"lineup"` // nil => keep recorded lineup
 }
 
 type BracketMatch struct {
@@ -77,7 +88,12 @@ type TournamentDetailed struct {
 	Prize        int32                          `json:"prize"`
 	MinLimit     int32                          `json:"min_limit"`
 	MaxLimit     int32                          `json:"max_limit"`
+	MaxSubs      pgtype.Int4                    `json:"max_substitutes"`
+	StartsAt     pgtype.Timestamp               `json:"starts_at"`
+	LockHours    int32                          `json:"lineup_lock_hours"`
+	Payout       []int32                        `json:"payout"`
 	Participants []TournamentParticipantMinimal `json:"participants"`
+	Placements   []Placement                    `json:"placements"`
 	Manager      Player                         `json:"manager"`
 }
 
@@ -87,6 +103,15 @@ type TournamentStateRequest struct {
 }
 
 type TournamentMinLimit struct {
-	ID       int32
-	MinLimit pgtype.Int4
+	ID             int32
+	MinLimit       pgtype.Int4
+	MaxSubstitutes pgtype.Int4
+}
+
+// TeamSizeLimits constrain the active players of a registering team. The limits are
+// for starters, substitutes have their own limit, or count as starters without it.
+type TeamSizeLimits struct {
+	MinLimit       pgtype.Int4
+	MaxLimit       pgtype.Int4
+	MaxSubstitutes pgtype.Int4
 }
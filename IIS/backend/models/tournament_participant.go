@@ -43,11 +43,12 @@ type Player struct {
 }
 
 type PlayerDetail struct {
-	ID          int32                 `json:"id"`
-	Name        string                `json:"name"`
-	Surname     string                `json:"surname"`
-	Winnings    int32                 `json:"winnings"`
-	Winrate     WinrateStatistic      `json:"winrate"`
-	Disciplines []DisciplineStatistic `json:"disciplines"`
-	Activity    []ActivityStatistic   `json:"activity"`
+	ID           int32                 `json:"id"`
+	Name         string                `json:"name"`
+	Surname      string                `json:"surname"`
+	Winnings     int32                 `json:"winnings"`
+	Winrate      WinrateStatistic      `json:"winrate"`
+	Disciplines  []DisciplineStatistic `json:"disciplines"`
+	Activity     []ActivityStatistic   `json:"activity"`
+	Achievements []EarnedAchievement   `json:"achievements"`
 }
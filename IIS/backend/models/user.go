@@ -5,6 +5,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -17,13 +19,25 @@ type BaseUser struct {
 
 type User struct {
 	BaseUser
-	Password string `json:"password,omitempty"`
-	Role     string `json:"role"`
+	Password      string `json:"password,omitempty"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	TOTPEnabled   bool   `json:"totp_enabled"`
+	AccountStatus
+}
+
+// AccountStatus is set by admins, except deletion which users may request themselves
+type AccountStatus struct {
+	Status          string             `json:"status"`
+	StatusReason    pgtype.Text        `json:"status_reason"`
+	StatusUntil     pgtype.Timestamptz `json:"status_until"`
+	StatusChangedBy pgtype.Int4        `json:"status_changed_by"`
+	StatusChangedAt pgtype.Timestamptz `json:"status_changed_at"`
 }
 
 type RegisterUserRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,alphanum"`
+	Password string `json:"password" binding:"required"`
 	Name     string `json:"name" binding:"required,alpha"`
 	Surname  string `json:"surname" binding:"required,alpha"`
 }
@@ -38,20 +52,22 @@ type RegisterUserResponse struct {
 
 type LoginUserRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,alphanum"`
+	Password string `json:"password" binding:"required"`
 }
 
 type LoginUserResponse struct {
-	ID      int32  `json:"id"`
-	Email   string `json:"email"`
-	Name    string `json:"name"`
-	Surname string `json:"surname"`
-	Role    string `json:"role"`
+	ID            int32  `json:"id"`
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	Surname       string `json:"surname"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	TOTPEnabled   bool   `json:"totp_enabled"`
 }
 
 type AdminUpdateUserRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"omitempty,alphanum"`
+	Password string `json:"password" binding:"omitempty"`
 }
 
 type UpdateUserRequest struct {
@@ -65,3 +81,60 @@ type Profile struct {
 	TeamInvites        []TeamPlayerInvite          `json:"team_invites"`
 	CreatedTournaments []TournamentProfileDetailed `json:"created_tournaments"`
 }
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// LoginChallengeResponse is returned instead of tokens when the second factor is required
+type LoginChallengeResponse struct {
+	TwoFactorRequired  bool   `json:"two_factor_required"`
+	EnrollmentRequired bool   `json:"enrollment_required"`
+	Challenge          string `json:"challenge"`
+}
+
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+type TwoFactorEnrollRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorLoginResponse carries recovery codes only when the login finished an enrollment
+type TwoFactorLoginResponse struct {
+	LoginUserResponse
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+type UpdateAccountStatusRequest struct {
+	Status string     `json:"status" binding:"required,oneof=Active Suspended Banned Deleted"`
+	Reason string     `json:"reason" binding:"required_unless=Status Active"`
+	Until  *time.Time `json:"until" binding:"required_if=Status Suspended"`
+}
+
+// DeleteAccountRequest confirms the deletion by the password, accounts
+// created by an identity provider have none and send the 2FA code instead
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
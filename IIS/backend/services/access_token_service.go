@@ -0,0 +1,129 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/jwt"
+	"backend/models"
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Prefix makes leaked tokens recognizable, e.g. by secret scanners
+const accessTokenPrefix = "iis_pat_"
+
+// AccessTokenService manages personal access tokens used by scripts and bots.
+type AccessTokenService struct {
+	db *pgxpool.Pool
+}
+
+func NewAccessTokenService(db *pgxpool.Pool) *AccessTokenService {
+	return &AccessTokenService{db}
+}
+
+func (s *AccessTokenService) CreateToken(ctx context.Context, userID int32, req models.CreateAccessTokenRequest) (*models.CreatedAccessToken, error) {
+	token, _, err := jwt.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	token = accessTokenPrefix + token
+
+	var expiresAt pgtype.Timestamp
+	if req.ExpiresInDays != nil {
+		expiresAt = pgtype.Timestamp{Time: time.Now().AddDate(0, 0, int(*req.ExpiresInDays)), Valid: true}
+	}
+
+	out := &models.CreatedAccessToken{Token: token}
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO PersonalAccessToken(user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, name, scopes, created_at, expires_at, last_used_at
+	`, userID, req.Name, jwt.HashToken(token), req.Scopes, expiresAt).Scan(
+		&out.ID,
+		&out.Name,
+		&out.Scopes,
+		&out.CreatedAt,
+		&out.ExpiresAt,
+		&out.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *AccessTokenService) GetUserTokens(ctx context.Context, userID int32) ([]models.AccessToken, error) {
+	tokens := []models.AccessToken{}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, name, scopes, created_at, expires_at, last_used_at
+		FROM PersonalAccessToken
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token models.AccessToken
+		if err := rows.Scan(
+			&token.ID,
+			&token.Name,
+			&token.Scopes,
+			&token.CreatedAt,
+			&token.ExpiresAt,
+			&token.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (s *AccessTokenService) RevokeToken(ctx context.Context, userID, tokenID int32) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE PersonalAccessToken SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.DBNotFound
+	}
+
+	return nil
+}
+
+// ResolveToken returns the owner, its current role and the token scopes, and records the usage.
+func (s *AccessTokenService) ResolveToken(ctx context.Context, token string) (int32, string, []string, error) {
+	var userID int32
+	var role string
+	var scopes []string
+	err := s.db.QueryRow(ctx, `
+		UPDATE PersonalAccessToken t SET last_used_at = NOW()
+		FROM "User" u
+		WHERE u.id = t.user_id
+			AND t.token_hash = $1
+			AND t.revoked_at IS NULL
+			AND (t.expires_at IS NULL OR t.expires_at > NOW())
+		RETURNING t.user_id, u.role, t.scopes
+	`, jwt.HashToken(token)).Scan(&userID, &role, &scopes)
+	if err == pgx.ErrNoRows {
+		return 0, "", nil, errors.InvalidToken
+	}
+
+	return userID, role, scopes, err
+}
@@ -0,0 +1,204 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/jwt"
+	"backend/internal/mail"
+	"backend/internal/password"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const passwordResetTTL = time.Hour
+const emailVerificationTTL = time.Hour * 24
+
+// AccountService handles emailed single-use tokens for password reset and email verification.
+type AccountService struct {
+	db     *pgxpool.Pool
+	mailer mail.Sender
+}
+
+func NewAccountService(db *pgxpool.Pool, mailer mail.Sender) *AccountService {
+	return &AccountService{db, mailer}
+}
+
+// issueToken invalidates previous unused tokens of the same purpose and stores a new one.
+func (s *AccountService) issueToken(ctx context.Context, userID int32, purpose, email string, ttl time.Duration) (string, error) {
+	token, hash, err := jwt.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE UserToken SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO UserToken(user_id, purpose, token_hash, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, purpose, hash, email, time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit(ctx)
+}
+
+// consumeToken marks the token as used and returns its owner and email.
+func consumeToken(ctx context.Context, tx pgx.Tx, token, purpose string) (int32, string, error) {
+	var userID int32
+	var email string
+	err := tx.QueryRow(ctx, `
+		UPDATE UserToken SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id, email
+	`, jwt.HashToken(token), purpose).Scan(&userID, &email)
+	if err == pgx.ErrNoRows {
+		return 0, "", errors.InvalidToken
+	}
+
+	return userID, email, err
+}
+
+// RequestPasswordReset emails a reset link. Unknown addresses are silently ignored,
+// so that the endpoint cannot be used to find out registered emails.
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	var userID int32
+	err := s.db.QueryRow(ctx, `SELECT id FROM "User" WHERE email = $1`, email).Scan(&userID)
+	if err == pgx.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	token, err := s.issueToken(ctx, userID, "PasswordReset", email, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(email, "Password reset", fmt.Sprintf(
+		"Someone requested a password reset for your account.\n\nSet a new password here: %s\n\nThe link expires in one hour. If it was not you, ignore this email.",
+		mail.Link("/reset-password", token),
+	))
+}
+
+// ResetPassword sets the new password and returns the user, whose sessions should be revoked.
+func (s *AccountService) ResetPassword(ctx context.Context, token, plain string) (int32, error) {
+	pwdHash, err := password.Hash(plain)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, _, err := consumeToken(ctx, tx, token, "PasswordReset")
+	if err != nil {
+		return 0, err
+	}
+
+	// Receiving the reset email proves the ownership of the address as well
+	if _, err := tx.Exec(ctx, `
+		UPDATE "User" SET password = $1, email_verified = TRUE WHERE id = $2
+	`, pwdHash, userID); err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit(ctx)
+}
+
+// SendVerification emails a confirmation link for the address, which becomes
+// the user's email only after it is confirmed.
+func (s *AccountService) SendVerification(ctx context.Context, userID int32, email string) error {
+	token, err := s.issueToken(ctx, userID, "EmailVerification", email, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(email, "Confirm your email address", fmt.Sprintf(
+		"Confirm your email address here: %s\n\nThe link expires in 24 hours.",
+		mail.Link("/verify-email", token),
+	))
+}
+
+// ResendVerification sends a new confirmation link for the current address.
+func (s *AccountService) ResendVerification(ctx context.Context, userID int32) error {
+	var email string
+	var verified bool
+	err := s.db.QueryRow(ctx, `
+		SELECT email, email_verified FROM "User" WHERE id = $1
+	`, userID).Scan(&email, &verified)
+	if err != nil {
+		return err
+	}
+	if verified {
+		return errors.NotAcceptable
+	}
+
+	return s.SendVerification(ctx, userID, email)
+}
+
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, email, err := consumeToken(ctx, tx, token, "EmailVerification")
+	if err != nil {
+		return err
+	}
+
+	var taken bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM "User" WHERE email = $1 AND id <> $2)
+	`, email, userID).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return errors.InvalidLogin
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE "User" SET email = $1, email_verified = TRUE WHERE id = $2
+	`, email, userID); err != nil {
+		return err
+	}
+
+	// Invites sent to the address are only handed over once it is proven to be the user's
+	if err := claimPendingInvites(ctx, tx, userID, email); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *AccountService) IsEmailVerified(ctx context.Context, userID int32) (bool, error) {
+	var verified bool
+	err := s.db.QueryRow(ctx, `SELECT email_verified FROM "User" WHERE id = $1`, userID).Scan(&verified)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+
+	return verified, err
+}
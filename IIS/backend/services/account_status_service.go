@@ -0,0 +1,261 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/password"
+	"backend/models"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// States of an account
+const (
+	StatusActive    = "Active"
+	StatusSuspended = "Suspended"
+	StatusBanned    = "Banned"
+	StatusDeleted   = "Deleted"
+)
+
+// deleteAccountLoginAge is how recent the login has to be to delete an account without password
+const deleteAccountLoginAge = time.Minute * 10
+
+type AccountStatusService struct {
+	db *pgxpool.Pool
+}
+
+func NewAccountStatusService(db *pgxpool.Pool) *AccountStatusService {
+	return &AccountStatusService{db}
+}
+
+// accountStatusError returns why the account cannot be used, nil when it can.
+// Suspension ends on its own once the date passes.
+func accountStatusError(status string, reason pgtype.Text, until pgtype.Timestamptz) error {
+	switch status {
+	case StatusSuspended:
+		if until.Valid && until.Time.Before(time.Now()) {
+			return nil
+		}
+		statusErr := errors.AccountStatusError{Err: errors.AccountSuspended, Reason: reason.String}
+		if until.Valid {
+			statusErr.Until = &until.Time
+		}
+		return statusErr
+	case StatusBanned:
+		return errors.AccountStatusError{Err: errors.AccountBanned, Reason: reason.String}
+	case StatusDeleted:
+		return errors.DBNotFound
+	}
+
+	return nil
+}
+
+// CheckAccount is called on every authenticated request, so that access tokens
+// stop working as soon as the account is suspended.
+func (s *AccountStatusService) CheckAccount(ctx context.Context, userID int32) error {
+	var status string
+	var reason pgtype.Text
+	var until pgtype.Timestamptz
+	err := s.db.QueryRow(ctx, `
+		SELECT status, status_reason, status_until FROM "User" WHERE id = $1
+	`, userID).Scan(&status, &reason, &until)
+	if err == pgx.ErrNoRows {
+		return errors.DBNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return accountStatusError(status, reason, until)
+}
+
+// SetStatus changes the state of the account on behalf of the admin. Sessions are
+// ended, so that the change applies immediately, deleted accounts are anonymized.
+func (s *AccountStatusService) SetStatus(ctx context.Context, userID, adminID int32, req models.UpdateAccountStatusRequest) error {
+	if userID == adminID {
+		return errors.NotAcceptable
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var current string
+	err = tx.QueryRow(ctx, `SELECT status FROM "User" WHERE id = $1 FOR UPDATE`, userID).Scan(&current)
+	if err == pgx.ErrNoRows {
+		return errors.DBNotFound
+	} else if err != nil {
+		return err
+	}
+	// Personal data of deleted accounts are gone, there is nothing to restore
+	if current == StatusDeleted {
+		return errors.NotAcceptable
+	}
+
+	if req.Status == StatusDeleted {
+		if err := anonymizeUser(ctx, tx, userID, adminID, req.Reason); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	var until *time.Time
+	if req.Status == StatusSuspended {
+		until = req.Until
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE "User" SET
+			status = $1, status_reason = NULLIF($2, ''), status_until = $3,
+			status_changed_by = $4, status_changed_at = NOW()
+		WHERE id = $5
+	`, req.Status, req.Reason, until, adminID, userID); err != nil {
+		return err
+	}
+
+	if req.Status != StatusActive {
+		if _, err := tx.Exec(ctx, `
+			UPDATE Session SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL
+		`, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteAccount is the self-service deletion confirmed by the current password. Accounts
+// created by an identity provider have none, they confirm by the 2FA code when enabled,
+// otherwise the session has to be started recently, so a stolen cookie is not enough.
+func (s *AccountStatusService) DeleteAccount(ctx context.Context, userID, sessionID int32, req models.DeleteAccountRequest) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var hash, role string
+	var totpEnabled bool
+	err = tx.QueryRow(ctx, `
+		SELECT password, role, totp_enabled FROM "User" WHERE id = $1 AND status <> 'Deleted' FOR UPDATE
+	`, userID).Scan(&hash, &role, &totpEnabled)
+	if err == pgx.ErrNoRows {
+		return errors.DBNotFound
+	} else if err != nil {
+		return err
+	}
+	if hash != "" {
+		if ok, _ := password.Verify(hash, req.Password); !ok {
+			return errors.InvalidPassword
+		}
+	} else if totpEnabled {
+		if err := verifyCode(ctx, tx, userID, req.Code); err != nil {
+			return err
+		}
+	} else {
+		var fresh bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM Session
+				WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND created_at > NOW() - make_interval(secs => $3)
+			)
+		`, sessionID, userID, deleteAccountLoginAge.Seconds()).Scan(&fresh); err != nil {
+			return err
+		}
+		if !fresh {
+			return errors.LoginRequired
+		}
+	}
+	// Admins are removed by other admins, never by themselves
+	if role == "Admin" {
+		return errors.NotAcceptable
+	}
+
+	// Teams and tournaments would be left without anyone to manage them
+	var owner bool
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM Team WHERE manager_id = $1 AND archived_at IS NULL)
+		OR EXISTS (SELECT 1 FROM Tournament WHERE manager_id = $1 AND finished_at IS NULL AND state <> 'Rejected')
+	`, userID).Scan(&owner); err != nil {
+		return err
+	}
+	if owner {
+		return errors.StillOwner
+	}
+
+	if err := anonymizeUser(ctx, tx, userID, userID, "Deleted by the user"); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// anonymizeUser removes personal data and credentials of the user. The row itself
+// is kept, matches, participations and statistics reference it and brackets
+// of past tournaments would break otherwise.
+func anonymizeUser(ctx context.Context, tx pgx.Tx, userID, changedBy int32, reason string) error {
+	if _, err := tx.Exec(ctx, `
+		UPDATE "User" SET
+			email = $1, password = '', name = 'Deleted', surname = 'user',
+			email_verified = FALSE, totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL,
+			status = 'Deleted', status_reason = NULLIF($2, ''), status_until = NULL,
+			status_changed_by = $3, status_changed_at = NOW()
+		WHERE id = $4
+	`, fmt.Sprintf("deleted-%d@deleted.invalid", userID), reason, changedBy, userID); err != nil {
+		return err
+	}
+
+	statements := []string{
+		`UPDATE Session SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		`DELETE FROM UserToken WHERE user_id = $1`,
+		`DELETE FROM RecoveryCode WHERE user_id = $1`,
+		`DELETE FROM UserIdentity WHERE user_id = $1`,
+		`DELETE FROM PersonalAccessToken WHERE user_id = $1`,
+		`DELETE FROM RoleAssignment WHERE user_id = $1`,
+		`DELETE FROM DataExport WHERE user_id = $1`,
+		// Memberships end today, pending invitations and join requests are dropped
+		`DELETE FROM TeamPlayer WHERE user_id = $1 AND state IN ('Invited', 'Requested')`,
+		`UPDATE TeamPlayer SET until = CURRENT_DATE, state = 'Inactive' WHERE user_id = $1 AND state = 'Active'`,
+		// Locked lineups keep the user until the team substitutes them
+		`DELETE FROM TournamentLineup l USING TournamentParticipant tp, Tournament t
+		WHERE l.user_id = $1 AND tp.id = l.participant_id AND t.id = tp.tournament_id AND NOT ` + lineupLocked,
+		`UPDATE LineupSubstitution SET state = 'Rejected', resolved_at = NOW() WHERE in_user_id = $1 AND state = 'Pending'`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, userID); err != nil {
+			return err
+		}
+	}
+
+	return redactAuditSnapshots(ctx, tx, userID)
+}
+
+// redactAuditSnapshots replaces personal data in logged snapshots of the user by the
+// anonymized values. The log is append-only, the trigger lets this update through only
+// for the user announced in the transaction.
+func redactAuditSnapshots(ctx context.Context, tx pgx.Tx, userID int32) error {
+	if _, err := tx.Exec(ctx, `SELECT set_config('audit.redact_user', $1, TRUE)`, strconv.Itoa(int(userID))); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE AuditLog SET
+			before = CASE WHEN before IS NULL THEN NULL ELSE before || $2::jsonb END,
+			after = CASE WHEN after IS NULL THEN NULL ELSE after || $2::jsonb END
+		WHERE entity_type = 'user' AND entity_id = $1
+	`, userID, fmt.Sprintf(`{"email": "deleted-%d@deleted.invalid", "name": "Deleted", "surname": "user"}`, userID)); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `SELECT set_config('audit.redact_user', '', TRUE)`)
+	return err
+}
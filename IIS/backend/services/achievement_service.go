@@ -0,0 +1,427 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	errori "backend/internal/errors"
+	"backend/models"
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AchievementService struct {
+	db *pgxpool.Pool
+}
+
+func NewAchievementService(db *pgxpool.Pool) *AchievementService {
+	return &AchievementService{db}
+}
+
+// profileSubject is either a player or a team, column names the column
+// its achievements and statistics are stored under.
+type profileSubject struct {
+	kind   string
+	column string
+	id     int32
+}
+
+func playerSubject(id int32) profileSubject {
+	return profileSubject{"Player", "user_id", id}
+}
+
+func teamSubject(id int32) profileSubject {
+	return profileSubject{"Team", "team_id", id}
+}
+
+// achievementMatch is a decided match, date falls back to the end of the tournament
+type achievementMatch struct {
+	tournamentID int32
+	discipline   string
+	won          bool
+	date         pgtype.Timestamp
+}
+
+type achievementPlacement struct {
+	tournamentID int32
+	discipline   string
+	place        int32
+	finishedAt   pgtype.Timestamp
+}
+
+func (s *AchievementService) GetAchievements() ([]models.Achievement, error) {
+	ctx := context.Background()
+	achievements := []models.Achievement{}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, code, name, description, subject, metric, threshold, discipline, active
+		FROM Achievement
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.Achievement
+		if err := rows.Scan(
+			&a.ID,
+			&a.Code,
+			&a.Name,
+			&a.Description,
+			&a.Subject,
+			&a.Metric,
+			&a.Threshold,
+			&a.Discipline,
+			&a.Active,
+		); err != nil {
+			return nil, err
+		}
+		achievements = append(achievements, a)
+	}
+
+	return achievements, rows.Err()
+}
+
+func (s *AchievementService) CreateAchievement(req *models.AchievementRequest) (models.Achievement, error) {
+	ctx := context.Background()
+	var a models.Achievement
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO Achievement(code, name, description, subject, metric, threshold, discipline, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, code, name, description, subject, metric, threshold, discipline, active
+	`, req.Code, req.Name, req.Description, req.Subject, req.Metric, req.Threshold, req.Discipline, active).Scan(
+		&a.ID, &a.Code, &a.Name, &a.Description, &a.Subject, &a.Metric, &a.Threshold, &a.Discipline, &a.Active,
+	)
+
+	return a, err
+}
+
+func (s *AchievementService) UpdateAchievement(id int32, req *models.AchievementRequest) (models.Achievement, error) {
+	ctx := context.Background()
+	var a models.Achievement
+
+	err := s.db.QueryRow(ctx, `
+		UPDATE Achievement
+		SET code = $2, name = $3, description = $4, subject = $5, metric = $6, threshold = $7,
+		    discipline = $8, active = COALESCE($9, active)
+		WHERE id = $1
+		RETURNING id, code, name, description, subject, metric, threshold, discipline, active
+	`, id, req.Code, req.Name, req.Description, req.Subject, req.Metric, req.Threshold, req.Discipline, req.Active).Scan(
+		&a.ID, &a.Code, &a.Name, &a.Description, &a.Subject, &a.Metric, &a.Threshold, &a.Discipline, &a.Active,
+	)
+	if err == pgx.ErrNoRows {
+		return a, errori.DBNotFound
+	}
+
+	return a, err
+}
+
+func (s *AchievementService) GetPlayerAchievements(userID int32) ([]models.EarnedAchievement, error) {
+	return s.getEarned(playerSubject(userID))
+}
+
+func (s *AchievementService) GetTeamAchievements(teamID int32) ([]models.EarnedAchievement, error) {
+	return s.getEarned(teamSubject(teamID))
+}
+
+func (s *AchievementService) getEarned(subject profileSubject) ([]models.EarnedAchievement, error) {
+	ctx := context.Background()
+	earned := []models.EarnedAchievement{}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT a.id, a.code, a.name, a.description, e.earned_at
+		FROM EarnedAchievement e
+		JOIN Achievement a ON a.id = e.achievement_id
+		WHERE e.`+subject.column+` = $1
+		ORDER BY e.earned_at DESC
+	`, subject.id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.EarnedAchievement
+		if err := rows.Scan(&e.ID, &e.Code, &e.Name, &e.Description, &e.EarnedAt); err != nil {
+			return nil, err
+		}
+		earned = append(earned, e)
+	}
+
+	return earned, rows.Err()
+}
+
+// EvaluateTournament awards achievements to every team and player that took part
+// in the tournament. It is called whenever match results of the tournament change.
+func (s *AchievementService) EvaluateTournament(tournamentID int32) error {
+	ctx := context.Background()
+
+	subjects, err := tournamentSubjects(ctx, s.db, tournamentID)
+	if err != nil {
+		return err
+	}
+
+	return s.evaluateSubjects(ctx, subjects)
+}
+
+// EvaluateAll re-evaluates every team and player, so that newly added rules
+// are applied to the past results as well.
+func (s *AchievementService) EvaluateAll() error {
+	ctx := context.Background()
+
+	subjects, err := allSubjects(ctx, s.db)
+	if err != nil {
+		return err
+	}
+
+	return s.evaluateSubjects(ctx, subjects)
+}
+
+// tournamentSubjects lists teams and players whose profile depends on the tournament.
+func tournamentSubjects(ctx context.Context, db rowsQuerier, tournamentID int32) ([]profileSubject, error) {
+	rows, err := db.Query(ctx, `
+		SELECT 'Team', tp.team_id FROM TournamentParticipant tp
+		WHERE tp.tournament_id = $1 AND tp.state = 'Accepted' AND tp.team_id IS NOT NULL
+		UNION
+		SELECT 'Player', tp.player_id FROM TournamentParticipant tp
+		WHERE tp.tournament_id = $1 AND tp.state = 'Accepted' AND tp.player_id IS NOT NULL
+		UNION
+		SELECT 'Player', ml.user_id FROM MatchLineup ml
+		JOIN TournamentParticipant tp ON tp.id = ml.participant_id
+		WHERE tp.tournament_id = $1
+	`, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSubjects(rows)
+}
+
+func allSubjects(ctx context.Context, db *pgxpool.Pool) ([]profileSubject, error) {
+	rows, err := db.Query(ctx, `
+		SELECT 'Team', id FROM Team
+		UNION
+		SELECT 'Player', id FROM "User" WHERE role = 'Registered'
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanSubjects(rows)
+}
+
+func scanSubjects(rows pgx.Rows) ([]profileSubject, error) {
+	defer rows.Close()
+
+	var subjects []profileSubject
+	for rows.Next() {
+		var kind string
+		var id int32
+		if err := rows.Scan(&kind, &id); err != nil {
+			return nil, err
+		}
+		if kind == "Team" {
+			subjects = append(subjects, teamSubject(id))
+		} else {
+			subjects = append(subjects, playerSubject(id))
+		}
+	}
+
+	return subjects, rows.Err()
+}
+
+func (s *AchievementService) evaluateSubjects(ctx context.Context, subjects []profileSubject) error {
+	achievements, err := s.GetAchievements()
+	if err != nil {
+		return err
+	}
+
+	for _, subject := range subjects {
+		if err := s.evaluate(ctx, subject, achievements); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *AchievementService) evaluate(ctx context.Context, subject profileSubject, achievements []models.Achievement) error {
+	matches, placements, err := s.subjectHistory(ctx, subject)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range achievements {
+		if !a.Active || (a.Subject != "Any" && a.Subject != subject.kind) {
+			continue
+		}
+		value, reached := achievementMetric(a, matches, placements)
+		if value < int(a.Threshold) {
+			continue
+		}
+
+		// Awards made later, e.g. of a new rule, are dated by the result which earned them
+		if _, err := s.db.Exec(ctx, `
+			INSERT INTO EarnedAchievement(achievement_id, `+subject.column+`, earned_at)
+			VALUES ($1, $2, COALESCE($3::timestamp, NOW()))
+			ON CONFLICT (achievement_id, `+subject.column+`)
+			DO UPDATE SET earned_at = LEAST(EarnedAchievement.earned_at, EXCLUDED.earned_at)
+		`, a.ID, subject.id, reached); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// subjectHistory loads decided matches and final placements of the subject in chronological order.
+func (s *AchievementService) subjectHistory(ctx context.Context, subject profileSubject) ([]achievementMatch, []achievementPlacement, error) {
+	var matchRows pgx.Rows
+	var placementRows pgx.Rows
+	var err error
+
+	if subject.kind == "Team" {
+		matchRows, err = s.db.Query(ctx, `
+			SELECT tp.tournament_id, t.discipline,
+			       CASE WHEN tp.id = m.first_participant_id THEN m.first_participant_is_winner
+			            ELSE m.second_participant_is_winner END,
+			       COALESCE(m."date", t.finished_at)
+			FROM Match m
+			JOIN TournamentParticipant tp ON tp.id = m.first_participant_id OR tp.id = m.second_participant_id
+			JOIN Tournament t ON t.id = tp.tournament_id
+			WHERE tp.team_id = $1 AND (m.first_participant_is_winner OR m.second_participant_is_winner)
+			ORDER BY m."date", m.id
+		`, subject.id)
+	} else {
+		matchRows, err = s.db.Query(ctx, `WITH`+playedMatches+`
+			SELECT p.tournament_id, t.discipline, p.won, COALESCE(p."date", t.finished_at) FROM played p
+			JOIN Tournament t ON t.id = p.tournament_id
+			ORDER BY p."date", p.id
+		`, subject.id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer matchRows.Close()
+
+	var matches []achievementMatch
+	for matchRows.Next() {
+		var m achievementMatch
+		if err := matchRows.Scan(&m.tournamentID, &m.discipline, &m.won, &m.date); err != nil {
+			return nil, nil, err
+		}
+		matches = append(matches, m)
+	}
+	if err := matchRows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	where := "tp.team_id = $1"
+	if subject.kind == "Player" {
+		where = playedFor
+	}
+	placementRows, err = s.db.Query(ctx, `
+		SELECT pl.tournament_id, t.discipline, pl.place, t.finished_at FROM TournamentPlacement pl
+		JOIN TournamentParticipant tp ON tp.id = pl.participant_id
+		JOIN Tournament t ON t.id = pl.tournament_id
+		WHERE `+where+`
+		ORDER BY t.finished_at, pl.tournament_id`, subject.id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer placementRows.Close()
+
+	var placements []achievementPlacement
+	for placementRows.Next() {
+		var p achievementPlacement
+		if err := placementRows.Scan(&p.tournamentID, &p.discipline, &p.place, &p.finishedAt); err != nil {
+			return nil, nil, err
+		}
+		placements = append(placements, p)
+	}
+
+	return matches, placements, placementRows.Err()
+}
+
+// achievementMetric computes the value of the rule's metric, restricted to the rule's
+// discipline when one is set, and when the value reached the threshold of the rule.
+func achievementMetric(a models.Achievement, matches []achievementMatch, placements []achievementPlacement) (int, pgtype.Timestamp) {
+	inDiscipline := func(discipline string) bool {
+		return !a.Discipline.Valid || a.Discipline.String == discipline
+	}
+
+	value := 0
+	var reached pgtype.Timestamp
+	raise := func(v int, at pgtype.Timestamp) {
+		if value < int(a.Threshold) && v >= int(a.Threshold) {
+			reached = at
+		}
+		value = max(value, v)
+	}
+
+	switch a.Metric {
+	case "matches_played", "matches_won":
+		count := 0
+		for _, m := range matches {
+			if inDiscipline(m.discipline) && (m.won || a.Metric == "matches_played") {
+				count++
+				raise(count, m.date)
+			}
+		}
+	case "win_streak":
+		streak := 0
+		for _, m := range matches {
+			if !inDiscipline(m.discipline) {
+				continue
+			}
+			if m.won {
+				streak++
+				raise(streak, m.date)
+			} else {
+				streak = 0
+			}
+		}
+	case "tournaments_won", "podiums":
+		count := 0
+		for _, p := range placements {
+			if inDiscipline(p.discipline) && (p.place == 1 || (a.Metric == "podiums" && p.place <= 3)) {
+				count++
+				raise(count, p.finishedAt)
+			}
+		}
+	case "undefeated_tournaments":
+		lost := map[int32]bool{}
+		for _, m := range matches {
+			if !m.won {
+				lost[m.tournamentID] = true
+			}
+		}
+		count := 0
+		for _, p := range placements {
+			if inDiscipline(p.discipline) && p.place == 1 && !lost[p.tournamentID] {
+				count++
+				raise(count, p.finishedAt)
+			}
+		}
+	case "disciplines":
+		disciplines := map[string]bool{}
+		for _, m := range matches {
+			disciplines[m.discipline] = true
+			raise(len(disciplines), m.date)
+		}
+	}
+
+	return value, reached
+}
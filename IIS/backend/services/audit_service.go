@@ -0,0 +1,197 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/models"
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Queries returning the state of an audited entity as JSON, the entity ID is $1.
+// Collections like the bracket are snapshot as a whole, a change of one match
+// is then seen in the context of the others.
+var auditSnapshots = map[string]string{
+	"tournament": `SELECT to_jsonb(t) FROM Tournament t WHERE id = $1`,
+	"tournament.bracket": `
+		SELECT jsonb_build_object(
+			'matches', (
+				SELECT COALESCE(jsonb_agg(to_jsonb(m) ORDER BY m.id), '[]') FROM Match m
+				JOIN Stage s ON s.id = m.stage_id WHERE s.tournament_id = $1
+			),
+			'placements', (
+				SELECT COALESCE(jsonb_agg(to_jsonb(p) ORDER BY p.place), '[]') FROM TournamentPlacement p WHERE p.tournament_id = $1
+			)
+		)`,
+	"tournament.participants": `
+		SELECT COALESCE(jsonb_agg(to_jsonb(p) ORDER BY p.id), '[]') FROM TournamentParticipant p WHERE p.tournament_id = $1
+	`,
+	"tournament.lineups": `
+		SELECT jsonb_build_object(
+			'lineups', (
+				SELECT COALESCE(jsonb_agg(to_jsonb(l) ORDER BY l.id), '[]') FROM TournamentLineup l
+				JOIN TournamentParticipant p ON p.id = l.participant_id WHERE p.tournament_id = $1
+			),
+			'substitutions', (
+				SELECT COALESCE(jsonb_agg(to_jsonb(ls) ORDER BY ls.id), '[]') FROM LineupSubstitution ls
+				JOIN TournamentParticipant p ON p.id = ls.participant_id WHERE p.tournament_id = $1
+			)
+		)`,
+	"tournament.roles": `
+		SELECT COALESCE(jsonb_agg(to_jsonb(r) ORDER BY r.id), '[]') FROM RoleAssignment r WHERE r.tournament_id = $1
+	`,
+	"team": `SELECT to_jsonb(t) FROM Team t WHERE id = $1`,
+	"team.roster": `
+		SELECT COALESCE(jsonb_agg(to_jsonb(p) ORDER BY p.id), '[]') FROM TeamPlayer p WHERE p.team_id = $1
+	`,
+	"team.pending_invites": `
+		SELECT COALESCE(jsonb_agg(to_jsonb(p) ORDER BY p.id), '[]') FROM PendingTeamInvite p WHERE p.team_id = $1
+	`,
+	"team.roles": `
+		SELECT COALESCE(jsonb_agg(to_jsonb(r) ORDER BY r.id), '[]') FROM RoleAssignment r WHERE r.team_id = $1
+	`,
+	// Credentials never get into the log
+	"user":          `SELECT to_jsonb(u) - 'password' - 'totp_secret' - 'totp_last_step' FROM "User" u WHERE id = $1`,
+	"achievement":   `SELECT to_jsonb(a) FROM Achievement a WHERE id = $1`,
+	"impersonation": `SELECT to_jsonb(i) FROM Impersonation i WHERE id = $1`,
+}
+
+type AuditService struct {
+	db *pgxpool.Pool
+}
+
+func NewAuditService(db *pgxpool.Pool) *AuditService {
+	return &AuditService{db}
+}
+
+// Snapshot returns the entity as JSON, nil for entities without snapshots or not existing
+func (s *AuditService) Snapshot(ctx context.Context, entityType string, entityID int32) ([]byte, error) {
+	query, ok := auditSnapshots[entityType]
+	if !ok {
+		return nil, nil
+	}
+
+	var snapshot []byte
+	err := s.db.QueryRow(ctx, query, entityID).Scan(&snapshot)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+
+	return snapshot, err
+}
+
+// Record stores the entry. When the change deleted a user, the snapshot taken before
+// gets the anonymized personal data, as the log was already redacted for the user.
+func (s *AuditService) Record(ctx context.Context, entry models.AuditEntry) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO AuditLog(actor_id, impersonator_id, action, entity_type, entity_id, tournament_id,
+			before, after, method, path, status, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6,
+			CASE WHEN $4 = 'user' AND $8::jsonb->>'status' = 'Deleted'
+			     THEN $7::jsonb || jsonb_build_object('email', $8::jsonb->'email', 'name', $8::jsonb->'name', 'surname', $8::jsonb->'surname')
+			     ELSE $7::jsonb END,
+			$8, $9, $10, $11, $12, $13)
+	`, entry.ActorID, entry.ImpersonatorID, entry.Action, entry.EntityType, entry.EntityID, entry.TournamentID,
+		[]byte(entry.Before), []byte(entry.After), entry.Method, entry.Path, entry.Status, entry.IP, entry.UserAgent)
+
+	return err
+}
+
+// recordEvent stores a change made outside of the audited routes, like logins through
+// identity providers or background jobs, with the entity as it is now. Like the middleware
+// it only logs failures, the change is already done at that point.
+func (s *AuditService) recordEvent(ctx context.Context, entry models.AuditEntry) {
+	if entry.EntityID.Valid {
+		after, err := s.Snapshot(ctx, entry.EntityType, entry.EntityID.Int32)
+		if err != nil {
+			log.Printf("audit snapshot of %s %d failed: %v", entry.EntityType, entry.EntityID.Int32, err)
+		}
+		entry.After = after
+	}
+
+	if err := s.Record(ctx, entry); err != nil {
+		log.Printf("audit of %s failed: %v", entry.Action, err)
+	}
+}
+
+func (s *AuditService) GetEntries(ctx context.Context, query models.AuditQuery) (models.PaginationAnswer[models.AuditEntry], error) {
+	var ans models.PaginationAnswer[models.AuditEntry]
+
+	filter := `
+		WHERE ($1 = 0 OR a.actor_id = $1)
+		AND ($2 = '' OR a.action = $2)
+		AND ($3 = '' OR a.entity_type = $3)
+		AND ($4 = 0 OR a.entity_id = $4)
+		AND ($5 = 0 OR a.tournament_id = $5)
+		AND (NULLIF($6, '') IS NULL OR a.created_at >= NULLIF($6, '')::date)
+		AND (NULLIF($7, '') IS NULL OR a.created_at < NULLIF($7, '')::date + 1)
+	`
+	args := []any{query.ActorID, query.Action, query.EntityType, query.EntityID, query.TournamentID, query.From, query.To}
+
+	var total int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM AuditLog a`+filter, args...).Scan(&total); err != nil {
+		return ans, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT a.id, a.actor_id, u.email, u.name, u.surname, a.impersonator_id, a.action, a.entity_type, a.entity_id, a.tournament_id,
+			a.before, a.after, a.method, a.path, a.status, a.ip, a.user_agent, a.created_at
+		FROM AuditLog a
+		LEFT JOIN "User" u ON u.id = a.actor_id
+	`+filter+`
+		ORDER BY a.id DESC
+		LIMIT $8 OFFSET $9
+	`, append(args, query.Limit, (query.Page-1)*query.Limit)...)
+	if err != nil {
+		return ans, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.ActorEmail, &e.ActorName, &e.ActorSurname, &e.ImpersonatorID, &e.Action, &e.EntityType, &e.EntityID, &e.TournamentID,
+			&before, &after, &e.Method, &e.Path, &e.Status, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return ans, err
+		}
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return ans, err
+	}
+
+	ans = models.PaginationAnswer[models.AuditEntry]{
+		Data:         entries,
+		TotalRecords: total,
+		TotalPages:   (total + query.Limit - 1) / query.Limit,
+		CurrentPage:  query.Page,
+		Limit:        query.Limit,
+	}
+
+	return ans, nil
+}
+
+// GetTournamentEntries is the view of organizers. They see what changed in the tournament
+// and who changed it by name, but neither the email of the actor nor the request metadata.
+func (s *AuditService) GetTournamentEntries(ctx context.Context, query models.AuditQuery) (models.PaginationAnswer[models.AuditEntry], error) {
+	ans, err := s.GetEntries(ctx, query)
+	if err != nil {
+		return ans, err
+	}
+
+	for i := range ans.Data {
+		entry := &ans.Data[i]
+		entry.ActorEmail = pgtype.Text{}
+		entry.IP, entry.UserAgent = "", ""
+	}
+
+	return ans, nil
+}
@@ -0,0 +1,254 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/models"
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Permission is an action on a tournament or a team, the part before
+// the colon tells which kind of resource it is checked against.
+type Permission string
+
+const (
+	PermAdministration Permission = "admin"
+
+	PermTournamentUpdate       Permission = "tournament:update"
+	PermTournamentDelete       Permission = "tournament:delete"
+	PermTournamentState        Permission = "tournament:state"
+	PermTournamentStart        Permission = "tournament:start"
+	PermTournamentParticipants Permission = "tournament:participants"
+	PermTournamentResults      Permission = "tournament:results"
+	PermTournamentRoles        Permission = "tournament:roles"
+	PermTournamentAudit        Permission = "tournament:audit"
+
+	PermTeamUpdate    Permission = "team:update"
+	PermTeamPlayers   Permission = "team:players"
+	PermTeamRegister  Permission = "team:register"
+	PermTeamRoles     Permission = "team:roles"
+	PermTeamOwnership Permission = "team:ownership"
+)
+
+// Owner is the manager of the tournament or team, other roles are granted by the owner
+const (
+	RoleOwner       = "Owner"
+	RoleCoOrganizer = "CoOrganizer"
+	RoleReferee     = "Referee"
+	RoleCaptain     = "Captain"
+	RoleCoManager   = "CoManager"
+)
+
+// Site admins are allowed everything, so they are not listed
+var permissionRoles = map[Permission][]string{
+	PermTournamentUpdate:       {RoleOwner, RoleCoOrganizer},
+	PermTournamentDelete:       {RoleOwner},
+	PermTournamentState:        {RoleOwner},
+	PermTournamentStart:        {RoleOwner, RoleCoOrganizer},
+	PermTournamentParticipants: {RoleOwner, RoleCoOrganizer},
+	PermTournamentResults:      {RoleOwner, RoleCoOrganizer, RoleReferee},
+	PermTournamentRoles:        {RoleOwner},
+	PermTournamentAudit:        {RoleOwner, RoleCoOrganizer},
+
+	PermTeamUpdate:    {RoleOwner},
+	PermTeamPlayers:   {RoleOwner, RoleCoManager, RoleCaptain},
+	PermTeamRegister:  {RoleOwner, RoleCoManager, RoleCaptain},
+	PermTeamRoles:     {RoleOwner},
+	PermTeamOwnership: {RoleOwner},
+}
+
+func (p Permission) resource() string {
+	resource, _, _ := strings.Cut(string(p), ":")
+	return resource
+}
+
+// AuthorizationService is the central place deciding who may do what,
+// handlers ask it instead of comparing the user with manager_id.
+type AuthorizationService struct {
+	db *pgxpool.Pool
+}
+
+func NewAuthorizationService(db *pgxpool.Pool) *AuthorizationService {
+	return &AuthorizationService{db}
+}
+
+// Can tells whether the user may perform the action on the tournament or team with resourceID.
+func (s *AuthorizationService) Can(ctx context.Context, userID int32, role string, permission Permission, resourceID int32) (bool, error) {
+	if role == "Admin" {
+		return true, nil
+	}
+
+	allowed, ok := permissionRoles[permission]
+	if !ok {
+		return false, nil
+	}
+
+	roles, err := s.rolesOf(ctx, userID, permission.resource(), resourceID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range roles {
+		if slices.Contains(allowed, r) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// rolesOf returns the roles of the user on the resource, a captain keeps
+// the role only while being an active player of the team. Co-managers
+// need not play, e.g. coaches. Disbanded teams have no owner, they are read-only.
+func (s *AuthorizationService) rolesOf(ctx context.Context, userID int32, resource string, resourceID int32) ([]string, error) {
+	var query string
+	switch resource {
+	case "tournament":
+		query = `
+			SELECT 'Owner' FROM Tournament WHERE id = $1 AND manager_id = $2
+			UNION ALL
+			SELECT role FROM RoleAssignment WHERE tournament_id = $1 AND user_id = $2
+		`
+	case "team":
+		query = `
+			SELECT 'Owner' FROM Team WHERE id = $1 AND manager_id = $2 AND archived_at IS NULL
+			UNION ALL
+			SELECT r.role FROM RoleAssignment r
+			WHERE r.team_id = $1 AND r.user_id = $2 AND (r.role <> 'Captain' OR EXISTS (
+				SELECT 1 FROM TeamPlayer p
+				WHERE p.team_id = r.team_id AND p.user_id = r.user_id AND p.state = 'Active'
+			))
+		`
+	default:
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(ctx, query, resourceID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// resourceColumns maps the resource kind to its table and RoleAssignment column
+func resourceColumns(resource string) (string, string) {
+	if resource == "team" {
+		return "Team", "team_id"
+	}
+	return "Tournament", "tournament_id"
+}
+
+func (s *AuthorizationService) GetRoles(ctx context.Context, resource string, resourceID int32) ([]models.RoleAssignment, error) {
+	_, column := resourceColumns(resource)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT r.id, r.user_id, u.name, u.surname, r.role, r.created_at
+		FROM RoleAssignment r
+		JOIN "User" u ON u.id = r.user_id
+		WHERE r.`+column+` = $1
+		ORDER BY r.role, r.created_at
+	`, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.RoleAssignment{}
+	for rows.Next() {
+		var role models.RoleAssignment
+		if err := rows.Scan(&role.ID, &role.UserID, &role.Name, &role.Surname, &role.Role, &role.CreatedAt); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// GrantRole assigns the role, the owner cannot be granted roles and captains
+// have to be active players of the team. Granting the captaincy takes it from
+// the previous captain.
+func (s *AuthorizationService) GrantRole(ctx context.Context, resource string, resourceID, userID int32, role string, grantedBy int32) (int32, error) {
+	table, column := resourceColumns(resource)
+
+	var managerID int32
+	err := s.db.QueryRow(ctx, `SELECT manager_id FROM `+table+` WHERE id = $1`, resourceID).Scan(&managerID)
+	if err == pgx.ErrNoRows {
+		return 0, errors.DBNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if managerID == userID {
+		return 0, errors.NotAcceptable
+	}
+
+	var eligible bool
+	if role == RoleCaptain {
+		err = s.db.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM TeamPlayer WHERE team_id = $1 AND user_id = $2 AND state = 'Active')
+		`, resourceID, userID).Scan(&eligible)
+	} else {
+		err = s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM "User" WHERE id = $1)`, userID).Scan(&eligible)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !eligible {
+		return 0, errors.NotAcceptable
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if role == RoleCaptain {
+		_, err = tx.Exec(ctx, `
+			DELETE FROM RoleAssignment WHERE team_id = $1 AND role = 'Captain' AND user_id <> $2
+		`, resourceID, userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var id int32
+	err = tx.QueryRow(ctx, `
+		INSERT INTO RoleAssignment(user_id, role, `+column+`, granted_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`, userID, role, resourceID, grantedBy).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, errors.AlreadyExists
+	} else if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit(ctx)
+}
+
+func (s *AuthorizationService) RevokeRole(ctx context.Context, resource string, resourceID, assignmentID int32) error {
+	_, column := resourceColumns(resource)
+
+	tag, err := s.db.Exec(ctx, `
+		DELETE FROM RoleAssignment WHERE id = $1 AND `+column+` = $2
+	`, assignmentID, resourceID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.DBNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"archive/zip"
+	"backend/internal/errors"
+	"backend/internal/mail"
+	"backend/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	dataExportTTL          = time.Hour * 24 * 7
+	dataExportBuildTimeout = time.Minute * 10
+)
+
+// dataExportSection is one JSON file of the archive, the query gets the user ID as $1
+type dataExportSection struct {
+	file  string
+	query string
+}
+
+// Everything stored about the user. Credentials, i.e. password and TOTP secret
+// or token hashes, are left out, they are not personal data but keys to the account.
+var dataExportSections = []dataExportSection{
+	{"profile.json", `
+		SELECT id, email, role, name, surname, email_verified, totp_enabled,
+			status, status_reason, status_until, status_changed_at
+		FROM "User" WHERE id = $1
+	`},
+	{"linked_identities.json", `
+		SELECT provider, subject, email, created_at FROM UserIdentity WHERE user_id = $1 ORDER BY created_at
+	`},
+	{"team_memberships.json", `
+		SELECT tp.team_id, t.name AS team, tp.state, tp.since, tp.until
+		FROM TeamPlayer tp JOIN Team t ON t.id = tp.team_id
+		WHERE tp.user_id = $1 AND tp.state NOT IN ('Invited', 'Requested')
+		ORDER BY tp.since
+	`},
+	{"invitations.json", `
+		SELECT tp.team_id, t.name AS team
+		FROM TeamPlayer tp JOIN Team t ON t.id = tp.team_id
+		WHERE tp.user_id = $1 AND tp.state = 'Invited'
+	`},
+	{"join_requests.json", `
+		SELECT tp.team_id, t.name AS team
+		FROM TeamPlayer tp JOIN Team t ON t.id = tp.team_id
+		WHERE tp.user_id = $1 AND tp.state = 'Requested'
+	`},
+	{"managed_teams.json", `
+		SELECT id, name, description, since, archived_at FROM Team WHERE manager_id = $1 ORDER BY id
+	`},
+	{"organized_tournaments.json", `
+		SELECT id, name, discipline, type, state, prize, finished_at FROM Tournament WHERE manager_id = $1 ORDER BY id
+	`},
+	{"roles.json", `
+		SELECT role, tournament_id, team_id, created_at FROM RoleAssignment WHERE user_id = $1 ORDER BY created_at
+	`},
+	{"tournament_participations.json", `
+		SELECT tp.tournament_id, t.name AS tournament, t.discipline, tp.team_id, team.name AS team,
+			tp.state, pl.place, pl.prize
+		FROM TournamentParticipant tp
+		JOIN Tournament t ON t.id = tp.tournament_id
+		LEFT JOIN Team team ON team.id = tp.team_id
+		LEFT JOIN TournamentPlacement pl ON pl.participant_id = tp.id
+		WHERE tp.player_id = $1 OR tp.id IN (SELECT participant_id FROM MatchLineup WHERE user_id = $1)
+			OR tp.id IN (SELECT participant_id FROM TournamentLineup WHERE user_id = $1)
+		ORDER BY tp.tournament_id
+	`},
+	{"match_results.json", `
+		SELECT m.id AS match_id, t.id AS tournament_id, t.name AS tournament, m.name, m."date",
+			CASE WHEN m.first_participant_id = tp.id THEN m.first_participant_result_text
+				ELSE m.second_participant_result_text END AS result,
+			CASE WHEN m.first_participant_id = tp.id THEN m.first_participant_is_winner
+				ELSE m.second_participant_is_winner END AS won
+		FROM Match m
+		JOIN Stage s ON s.id = m.stage_id
+		JOIN Tournament t ON t.id = s.tournament_id
+		JOIN TournamentParticipant tp ON tp.id IN (m.first_participant_id, m.second_participant_id)
+		WHERE tp.player_id = $1 OR EXISTS (
+			SELECT 1 FROM MatchLineup ml WHERE ml.match_id = m.id AND ml.participant_id = tp.id AND ml.user_id = $1
+		)
+		ORDER BY m."date", m.id
+	`},
+	{"predictions.json", `
+		SELECT tournament_id, level, slot, participant_id, points, created_at, updated_at FROM Prediction WHERE user_id = $1 ORDER BY created_at
+	`},
+	{"achievements.json", `
+		SELECT a.code, a.name, e.earned_at
+		FROM EarnedAchievement e JOIN Achievement a ON a.id = e.achievement_id
+		WHERE e.user_id = $1 ORDER BY e.earned_at
+	`},
+	{"sessions.json", `
+		SELECT user_agent, ip, created_at, last_used_at, expires_at, revoked_at FROM Session WHERE user_id = $1 ORDER BY created_at
+	`},
+	{"impersonations.json", `
+		SELECT reason, started_at, ended_at FROM Impersonation WHERE user_id = $1 ORDER BY started_at
+	`},
+	{"access_tokens.json", `
+		SELECT name, scopes, created_at, expires_at, last_used_at, revoked_at FROM PersonalAccessToken WHERE user_id = $1 ORDER BY created_at
+	`},
+	{"audit_log.json", `
+		SELECT action, entity_type, entity_id, method, path, ip, user_agent, created_at FROM AuditLog WHERE actor_id = $1 ORDER BY created_at
+	`},
+}
+
+// DataExportService builds the archive of personal data in the background,
+// as collecting it including images may take a while.
+type DataExportService struct {
+	db        *pgxpool.Pool
+	s3Service *S3Service
+	mailer    mail.Sender
+	audit     *AuditService
+}
+
+func NewDataExportService(db *pgxpool.Pool, s3Service *S3Service, mailer mail.Sender) *DataExportService {
+	return &DataExportService{db, s3Service, mailer, NewAuditService(db)}
+}
+
+// RequestExport starts a new export, only one may be in progress at a time.
+func (s *DataExportService) RequestExport(ctx context.Context, userID int32) (*models.DataExport, error) {
+	// Expired archives are removed on the way
+	if _, err := s.db.Exec(ctx, `DELETE FROM DataExport WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+
+	var pending bool
+	err := s.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM DataExport WHERE user_id = $1 AND state = 'Pending')
+	`, userID).Scan(&pending)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, errors.AlreadyExists
+	}
+
+	export := &models.DataExport{}
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO DataExport(user_id) VALUES ($1)
+		RETURNING id, state, created_at, completed_at, expires_at
+	`, userID).Scan(&export.ID, &export.State, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.build(export.ID, userID)
+
+	return export, nil
+}
+
+// ResumePending restarts exports interrupted by shutdown of the server
+func (s *DataExportService) ResumePending(ctx context.Context) error {
+	rows, err := s.db.Query(ctx, `SELECT id, user_id FROM DataExport WHERE state = 'Pending'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var exportID, userID int32
+	_, err = pgx.ForEachRow(rows, []any{&exportID, &userID}, func() error {
+		go s.build(exportID, userID)
+		return nil
+	})
+
+	return err
+}
+
+func (s *DataExportService) GetExports(ctx context.Context, userID int32) ([]models.DataExport, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, state, created_at, completed_at, expires_at FROM DataExport
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []models.DataExport{}
+	for rows.Next() {
+		var export models.DataExport
+		if err := rows.Scan(&export.ID, &export.State, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt); err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+
+	return exports, rows.Err()
+}
+
+// GetArchive returns the finished archive of the user's export
+func (s *DataExportService) GetArchive(ctx context.Context, userID, exportID int32) ([]byte, error) {
+	var archive []byte
+	err := s.db.QueryRow(ctx, `
+		SELECT archive FROM DataExport
+		WHERE id = $1 AND user_id = $2 AND state = 'Ready' AND expires_at > NOW()
+	`, exportID, userID).Scan(&archive)
+	if err == pgx.ErrNoRows {
+		return nil, errors.DBNotFound
+	}
+
+	return archive, err
+}
+
+// build runs outside of any request, its outcome is audited here with no method and path
+func (s *DataExportService) build(exportID, userID int32) {
+	ctx, cancel := context.WithTimeout(context.Background(), dataExportBuildTimeout)
+	defer cancel()
+
+	entry := models.AuditEntry{
+		ActorID:    pgtype.Int4{Int32: userID, Valid: true},
+		EntityType: "user.account",
+		EntityID:   pgtype.Int4{Int32: userID, Valid: true},
+	}
+
+	archive, err := s.writeArchive(ctx, userID)
+	if err != nil {
+		log.Printf("data export %d failed: %v", exportID, err)
+		if _, err := s.db.Exec(ctx, `
+			UPDATE DataExport SET state = 'Failed', completed_at = NOW() WHERE id = $1
+		`, exportID); err != nil {
+			log.Printf("marking data export %d as failed failed: %v", exportID, err)
+		}
+		entry.Action = "user.export.failed"
+		s.audit.recordEvent(ctx, entry)
+		return
+	}
+
+	var email string
+	err = s.db.QueryRow(ctx, `
+		UPDATE DataExport SET state = 'Ready', archive = $1, completed_at = NOW(), expires_at = NOW() + make_interval(secs => $2)
+		FROM "User" u
+		WHERE DataExport.id = $3 AND u.id = DataExport.user_id
+		RETURNING u.email
+	`, archive, dataExportTTL.Seconds(), exportID).Scan(&email)
+	if err != nil {
+		log.Printf("storing data export %d failed: %v", exportID, err)
+		return
+	}
+	entry.Action = "user.export.ready"
+	s.audit.recordEvent(ctx, entry)
+
+	if err := s.mailer.Send(email, "Your data export is ready", fmt.Sprintf(
+		"The export of your personal data is ready.\n\nDownload it here: %s\n\nThe archive is available for 7 days.",
+		mail.Page("/profile"),
+	)); err != nil {
+		log.Printf("data export %d notification failed: %v", exportID, err)
+	}
+}
+
+func (s *DataExportService) writeArchive(ctx context.Context, userID int32) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+
+	for _, section := range dataExportSections {
+		rows, err := s.db.Query(ctx, section.query, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", section.file, err)
+		}
+		records, err := pgx.CollectRows(rows, pgx.RowToMap)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", section.file, err)
+		}
+
+		var content any = records
+		if section.file == "profile.json" && len(records) == 1 {
+			content = records[0]
+		}
+		if err := writeArchiveJSON(archive, section.file, content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.writeImages(ctx, archive, userID); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeImages adds avatars uploaded to teams the user manages
+func (s *DataExportService) writeImages(ctx context.Context, archive *zip.Writer, userID int32) error {
+	if s.s3Service == nil {
+		return nil
+	}
+
+	rows, err := s.db.Query(ctx, `SELECT id FROM Team WHERE manager_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	teamIDs, err := pgx.CollectRows(rows, pgx.RowTo[int32])
+	if err != nil {
+		return err
+	}
+
+	for _, teamID := range teamIDs {
+		body, key, err := s.s3Service.GetObject(fmt.Sprintf("team%d", teamID))
+		if err != nil {
+			// Most teams have no avatar
+			continue
+		}
+
+		file, err := archive.Create("images/" + path.Base(key))
+		if err == nil {
+			_, err = io.Copy(file, body)
+		}
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeArchiveJSON(archive *zip.Writer, name string, content any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(content)
+}
@@ -0,0 +1,148 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/jwt"
+	"backend/models"
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ImpersonationService lets admins see the application as another user does.
+// Impersonations are never deleted, they are the audit trail of support work.
+type ImpersonationService struct {
+	db *pgxpool.Pool
+}
+
+func NewImpersonationService(db *pgxpool.Pool) *ImpersonationService {
+	return &ImpersonationService{db}
+}
+
+// Start records the impersonation and issues its token. Other admins cannot be
+// impersonated, the token would grant administration to the impersonator again.
+func (s *ImpersonationService) Start(ctx context.Context, adminID, userID int32, reason string) (*models.ImpersonationToken, error) {
+	if adminID == userID {
+		return nil, errors.NotAcceptable
+	}
+
+	var user models.User
+	err := s.db.QueryRow(ctx, `
+		SELECT id, email, name, surname, role, status, status_reason, status_until FROM "User" WHERE id = $1
+	`, userID).Scan(&user.ID, &user.Email, &user.Name, &user.Surname, &user.Role, &user.Status, &user.StatusReason, &user.StatusUntil)
+	if err == pgx.ErrNoRows {
+		return nil, errors.DBNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if user.Role == "Admin" {
+		return nil, errors.NotAcceptable
+	}
+	if err := accountStatusError(user.Status, user.StatusReason, user.StatusUntil); err != nil {
+		return nil, err
+	}
+
+	out := &models.ImpersonationToken{ExpiresAt: time.Now().Add(jwt.ImpersonationTokenTTL), User: user.BaseUser}
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO Impersonation(admin_id, user_id, reason, expires_at) VALUES ($1, $2, $3, $4) RETURNING id
+	`, adminID, userID, reason, out.ExpiresAt).Scan(&out.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Token = jwt.GenerateImpersonationToken(user.ID, user.Role, adminID, out.ID, out.ExpiresAt)
+
+	return out, nil
+}
+
+// IsImpersonationActive lets the admin end the impersonation before the token expires
+func (s *ImpersonationService) IsImpersonationActive(ctx context.Context, impersonationID int32) (bool, error) {
+	var active bool
+	err := s.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM Impersonation WHERE id = $1 AND ended_at IS NULL AND expires_at > NOW()
+		)
+	`, impersonationID).Scan(&active)
+
+	return active, err
+}
+
+func (s *ImpersonationService) RecordAction(ctx context.Context, impersonationID int32, method, path string, status int) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO ImpersonationAction(impersonation_id, method, path, status) VALUES ($1, $2, $3, $4)
+	`, impersonationID, method, path, status)
+
+	return err
+}
+
+// End finishes the impersonation started by the admin
+func (s *ImpersonationService) End(ctx context.Context, adminID, impersonationID int32) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE Impersonation SET ended_at = NOW()
+		WHERE id = $1 AND admin_id = $2 AND ended_at IS NULL
+	`, impersonationID, adminID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.DBNotFound
+	}
+
+	return nil
+}
+
+func (s *ImpersonationService) GetImpersonations(ctx context.Context) ([]models.Impersonation, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT i.id, i.admin_id, a.email, i.user_id, u.email, i.reason, i.started_at, i.expires_at, i.ended_at,
+			(SELECT COUNT(*) FROM ImpersonationAction WHERE impersonation_id = i.id)
+		FROM Impersonation i
+		JOIN "User" a ON a.id = i.admin_id
+		JOIN "User" u ON u.id = i.user_id
+		ORDER BY i.started_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	impersonations := []models.Impersonation{}
+	for rows.Next() {
+		var i models.Impersonation
+		if err := rows.Scan(&i.ID, &i.AdminID, &i.AdminEmail, &i.UserID, &i.UserEmail, &i.Reason,
+			&i.StartedAt, &i.ExpiresAt, &i.EndedAt, &i.Actions); err != nil {
+			return nil, err
+		}
+		impersonations = append(impersonations, i)
+	}
+
+	return impersonations, rows.Err()
+}
+
+func (s *ImpersonationService) GetActions(ctx context.Context, impersonationID int32) ([]models.ImpersonationAction, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT method, path, status, created_at FROM ImpersonationAction
+		WHERE impersonation_id = $1
+		ORDER BY created_at
+	`, impersonationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []models.ImpersonationAction{}
+	for rows.Next() {
+		var action models.ImpersonationAction
+		if err := rows.Scan(&action.Method, &action.Path, &action.Status, &action.CreatedAt); err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, rows.Err()
+}
@@ -0,0 +1,334 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/models"
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// lineupLocked tells whether lineups of the tournament t are locked, i.e. the tournament
+// is finished, its bracket is generated or it starts within lineup_lock_hours.
+const lineupLocked = `(t.finished_at IS NOT NULL OR EXISTS (SELECT 1 FROM Stage s WHERE s.tournament_id = t.id)
+	OR (t.starts_at IS NOT NULL AND NOW() >= t.starts_at - make_interval(hours => t.lineup_lock_hours)))`
+
+// LineupService manages players teams register for tournaments. Conflicts
+// between teams sharing players are decided by lineups, not by whole rosters.
+type LineupService struct {
+	db *pgxpool.Pool
+}
+
+func NewLineupService(db *pgxpool.Pool) *LineupService {
+	return &LineupService{db}
+}
+
+// snapshotTournamentLineup registers the active roster of the team, unless it has a lineup already
+func snapshotTournamentLineup(ctx context.Context, tx pgx.Tx, participantID int32) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO TournamentLineup(participant_id, user_id)
+		SELECT tp.id, teamp.user_id FROM TournamentParticipant tp
+		JOIN TeamPlayer teamp ON teamp.team_id = tp.team_id
+		WHERE tp.id = $1 AND teamp.state = 'Active' AND teamp.since IS NOT NULL AND teamp.until IS NULL
+		AND NOT EXISTS (SELECT 1 FROM TournamentLineup l WHERE l.participant_id = tp.id)
+		ON CONFLICT DO NOTHING
+	`, participantID)
+	return err
+}
+
+// GetParticipantTeam returns the team of the tournament's participant
+func (s *LineupService) GetParticipantTeam(ctx context.Context, tournamentID, participantID int32) (int32, error) {
+	var teamID int32
+	err := s.db.QueryRow(ctx, `
+		SELECT team_id FROM TournamentParticipant
+		WHERE id = $1 AND tournament_id = $2 AND team_id IS NOT NULL
+	`, participantID, tournamentID).Scan(&teamID)
+	if err == pgx.ErrNoRows {
+		return 0, errors.DBNotFound
+	}
+	return teamID, err
+}
+
+func (s *LineupService) GetLineup(ctx context.Context, tournamentID, participantID int32) (*models.TournamentLineup, error) {
+	lineup := &models.TournamentLineup{Players: []models.LineupPlayer{}}
+	err := s.db.QueryRow(ctx, `
+		SELECT tp.id, tp.team_id, `+lineupLocked+`, t.starts_at - make_interval(hours => t.lineup_lock_hours)
+		FROM TournamentParticipant tp
+		JOIN Tournament t ON t.id = tp.tournament_id
+		WHERE tp.id = $1 AND tp.tournament_id = $2 AND tp.team_id IS NOT NULL
+	`, participantID, tournamentID).Scan(&lineup.ParticipantID, &lineup.TeamID, &lineup.Locked, &lineup.LocksAt)
+	if err == pgx.ErrNoRows {
+		return nil, errors.DBNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT u.id, u.name, u.surname, COALESCE(teamp.squad, 'Starter')
+		FROM TournamentLineup l
+		JOIN "User" u ON u.id = l.user_id
+		LEFT JOIN TeamPlayer teamp ON teamp.team_id = $2 AND teamp.user_id = l.user_id AND teamp.state = 'Active'
+		WHERE l.participant_id = $1
+		ORDER BY 4 DESC, u.surname, u.name
+	`, participantID, lineup.TeamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var player models.LineupPlayer
+		if err := rows.Scan(&player.UserID, &player.Name, &player.Surname, &player.Squad); err != nil {
+			return nil, err
+		}
+		lineup.Players = append(lineup.Players, player)
+	}
+
+	return lineup, rows.Err()
+}
+
+// SquadOf counts starters and substitutes among the users, who are active players of the team
+func (s *LineupService) SquadOf(ctx context.Context, teamID int32, userIDs []int32) (models.TeamSquadCount, error) {
+	var count models.TeamSquadCount
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT user_id) FILTER (WHERE squad = 'Starter'), COUNT(DISTINCT user_id) FILTER (WHERE squad = 'Substitute')
+		FROM TeamPlayer
+		WHERE team_id = $1 AND user_id = ANY($2) AND state = 'Active' AND until IS NULL
+	`, teamID, userIDs).Scan(&count.Starters, &count.Substitutes)
+	return count, err
+}
+
+// SetLineup replaces the lineup until it locks. The players have to be active players
+// of the team, and cannot be registered for the tournament by an accepted team already.
+func (s *LineupService) SetLineup(ctx context.Context, participantID int32, userIDs []int32) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var teamID, tournamentID int32
+	var locked bool
+	err = tx.QueryRow(ctx, `
+		SELECT tp.team_id, tp.tournament_id, `+lineupLocked+`
+		FROM TournamentParticipant tp
+		JOIN Tournament t ON t.id = tp.tournament_id
+		WHERE tp.id = $1 AND tp.team_id IS NOT NULL
+		FOR UPDATE OF tp
+	`, participantID).Scan(&teamID, &tournamentID, &locked)
+	if err == pgx.ErrNoRows {
+		return errors.DBNotFound
+	} else if err != nil {
+		return err
+	}
+	if locked {
+		return errors.LineupLocked
+	}
+
+	var members int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT user_id) FROM TeamPlayer
+		WHERE team_id = $1 AND user_id = ANY($2) AND state = 'Active' AND until IS NULL
+	`, teamID, userIDs).Scan(&members)
+	if err != nil {
+		return err
+	}
+	if members != len(uniqueIDs(userIDs)) {
+		return errors.NotAcceptable
+	}
+
+	var conflict bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM TournamentLineup l
+			JOIN TournamentParticipant tp ON tp.id = l.participant_id
+			WHERE tp.tournament_id = $1 AND tp.id <> $2 AND tp.state = 'Accepted' AND l.user_id = ANY($3)
+		)
+	`, tournamentID, participantID, userIDs).Scan(&conflict)
+	if err != nil {
+		return err
+	}
+	if conflict {
+		return errors.AlreadyExists
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM TournamentLineup WHERE participant_id = $1`, participantID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO TournamentLineup(participant_id, user_id)
+		SELECT $1, user_id FROM unnest($2::INT[]) AS user_id
+		ON CONFLICT DO NOTHING
+	`, participantID, userIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RequestSubstitution asks the organizers to swap a player of the locked lineup
+// for an active player of the team outside it.
+func (s *LineupService) RequestSubstitution(ctx context.Context, participantID, requestedBy int32, req models.CreateSubstitutionRequest) (*models.LineupSubstitution, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked, finished, valid bool
+	err = tx.QueryRow(ctx, `
+		SELECT `+lineupLocked+`, t.finished_at IS NOT NULL,
+			EXISTS (SELECT 1 FROM TournamentLineup l WHERE l.participant_id = tp.id AND l.user_id = $2)
+			AND NOT EXISTS (SELECT 1 FROM TournamentLineup l WHERE l.participant_id = tp.id AND l.user_id = $3)
+			AND EXISTS (SELECT 1 FROM TeamPlayer teamp
+				WHERE teamp.team_id = tp.team_id AND teamp.user_id = $3 AND teamp.state = 'Active' AND teamp.until IS NULL)
+		FROM TournamentParticipant tp
+		JOIN Tournament t ON t.id = tp.tournament_id
+		WHERE tp.id = $1 AND tp.team_id IS NOT NULL
+	`, participantID, req.OutUserID, req.InUserID).Scan(&locked, &finished, &valid)
+	if err == pgx.ErrNoRows {
+		return nil, errors.DBNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !locked || finished {
+		return nil, errors.NotAcceptable
+	}
+	if !valid {
+		return nil, errors.DBNotFound
+	}
+
+	var pending bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM LineupSubstitution WHERE participant_id = $1 AND out_user_id = $2 AND state = 'Pending'
+		)
+	`, participantID, req.OutUserID).Scan(&pending)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		return nil, errors.AlreadyExists
+	}
+
+	substitution := &models.LineupSubstitution{
+		ParticipantID: participantID,
+		OutUserID:     req.OutUserID,
+		InUserID:      req.InUserID,
+		Reason:        req.Reason,
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO LineupSubstitution(participant_id, out_user_id, in_user_id, reason, requested_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, state, created_at
+	`, participantID, req.OutUserID, req.InUserID, req.Reason, requestedBy).Scan(&substitution.ID, &substitution.State, &substitution.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return substitution, tx.Commit(ctx)
+}
+
+func (s *LineupService) GetSubstitutions(ctx context.Context, tournamentID int32) ([]models.LineupSubstitution, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT s.id, s.participant_id, team.name, s.out_user_id, uo.name, uo.surname, s.in_user_id, ui.name, ui.surname,
+			s.reason, s.state, s.created_at, s.resolved_at
+		FROM LineupSubstitution s
+		JOIN TournamentParticipant tp ON tp.id = s.participant_id
+		JOIN Team team ON team.id = tp.team_id
+		JOIN "User" uo ON uo.id = s.out_user_id
+		JOIN "User" ui ON ui.id = s.in_user_id
+		WHERE tp.tournament_id = $1
+		ORDER BY s.state = 'Pending' DESC, s.created_at DESC
+	`, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	substitutions := []models.LineupSubstitution{}
+	for rows.Next() {
+		var sub models.LineupSubstitution
+		if err := rows.Scan(&sub.ID, &sub.ParticipantID, &sub.TeamName, &sub.OutUserID, &sub.OutName, &sub.OutSurname,
+			&sub.InUserID, &sub.InName, &sub.InSurname, &sub.Reason, &sub.State, &sub.CreatedAt, &sub.ResolvedAt); err != nil {
+			return nil, err
+		}
+		substitutions = append(substitutions, sub)
+	}
+
+	return substitutions, rows.Err()
+}
+
+// ResolveSubstitution approves or rejects the request. The swap applies to the lineup
+// and to lineups of matches not played yet, provided the players still qualify.
+func (s *LineupService) ResolveSubstitution(ctx context.Context, tournamentID, substitutionID, resolvedBy int32, approve bool) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var participantID, outUserID, inUserID int32
+	err = tx.QueryRow(ctx, `
+		SELECT s.participant_id, s.out_user_id, s.in_user_id FROM LineupSubstitution s
+		JOIN TournamentParticipant tp ON tp.id = s.participant_id
+		WHERE s.id = $1 AND tp.tournament_id = $2 AND s.state = 'Pending'
+		FOR UPDATE OF s
+	`, substitutionID, tournamentID).Scan(&participantID, &outUserID, &inUserID)
+	if err == pgx.ErrNoRows {
+		return errors.DBNotFound
+	} else if err != nil {
+		return err
+	}
+
+	state := "Rejected"
+	if approve {
+		state = "Approved"
+
+		var valid bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM TournamentLineup l WHERE l.participant_id = tp.id AND l.user_id = $2)
+				AND NOT EXISTS (SELECT 1 FROM TournamentLineup l
+					JOIN TournamentParticipant other ON other.id = l.participant_id
+					WHERE other.tournament_id = tp.tournament_id AND (other.id = tp.id OR other.state = 'Accepted') AND l.user_id = $3)
+				AND EXISTS (SELECT 1 FROM TeamPlayer teamp
+					WHERE teamp.team_id = tp.team_id AND teamp.user_id = $3 AND teamp.state = 'Active' AND teamp.until IS NULL)
+			FROM TournamentParticipant tp WHERE tp.id = $1
+		`, participantID, outUserID, inUserID).Scan(&valid)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return errors.NotAcceptable
+		}
+
+		statements := []string{
+			`DELETE FROM TournamentLineup WHERE participant_id = $1 AND user_id = $2`,
+			`INSERT INTO TournamentLineup(participant_id, user_id) VALUES ($1, $3)`,
+			`UPDATE MatchLineup ml SET user_id = $3
+			FROM Match m
+			WHERE m.id = ml.match_id AND ml.participant_id = $1 AND ml.user_id = $2
+			AND NOT m.first_participant_is_winner AND NOT m.second_participant_is_winner`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(ctx, statement, participantID, outUserID, inUserID); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE LineupSubstitution SET state = $2, resolved_by = $3, resolved_at = NOW() WHERE id = $1
+	`, substitutionID, state, resolvedBy)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,275 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Failures older than the window are forgotten
+const loginAttemptWindow = time.Hour * 24
+
+// Parameters of the backoff, every failure above the free attempts doubles
+// the delay until it reaches the lockout
+type loginBackoff struct {
+	free    int
+	base    time.Duration
+	lockout time.Duration
+}
+
+// Accounts are protected more strictly, addresses may be shared e.g. by a university network
+var (
+	accountBackoff = loginBackoff{free: 3, base: time.Second, lockout: time.Minute * 15}
+	addressBackoff = loginBackoff{free: 20, base: time.Second, lockout: time.Minute * 15}
+)
+
+func (b loginBackoff) delay(failures int) time.Duration {
+	if failures <= b.free {
+		return 0
+	}
+
+	delay := b.base
+	for i := b.free + 1; i < failures && delay < b.lockout; i++ {
+		delay *= 2
+	}
+
+	return min(delay, b.lockout)
+}
+
+// wait returns how long to wait after the failures, the last one made at last
+func (b loginBackoff) wait(failures int, last time.Time) time.Duration {
+	return b.delay(failures) - time.Since(last)
+}
+
+// LoginAttemptStore counts failed attempts per key, e.g. account or IP address.
+// Take checks the backoff and counts the attempt in one step, so concurrent
+// attempts cannot all pass the check before any of them is counted.
+type LoginAttemptStore interface {
+	// Take counts the attempt as failed, unless the key is backing off, then it
+	// returns how long to wait and the attempt is not counted.
+	Take(ctx context.Context, key string, backoff loginBackoff) (time.Duration, error)
+	// Forgive uncounts one attempt which turned out not to be a failure
+	Forgive(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+// LoginThrottleService slows down password guessing with exponential backoff
+// per account and per IP address, ending in temporary lockout.
+type LoginThrottleService struct {
+	store LoginAttemptStore
+}
+
+func NewLoginThrottleService(store LoginAttemptStore) *LoginThrottleService {
+	return &LoginThrottleService{store}
+}
+
+// NewLoginAttemptStoreFromEnv keeps attempts in Postgres when LOGIN_ATTEMPT_STORE
+// is "postgres", which is needed when several instances run behind a load balancer.
+func NewLoginAttemptStoreFromEnv(db *pgxpool.Pool) LoginAttemptStore {
+	if os.Getenv("LOGIN_ATTEMPT_STORE") == "postgres" {
+		return NewPostgresLoginAttemptStore(db)
+	}
+	return NewMemoryLoginAttemptStore()
+}
+
+func accountKey(account string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(account))
+}
+
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
+
+// TwoFactorAccount names the account when guessing the second factor,
+// the password is already verified at that point.
+func TwoFactorAccount(userID int32) string {
+	return fmt.Sprintf("2fa:%d", userID)
+}
+
+// Attempt counts the attempt as failed before the credentials are verified, or returns
+// how long the caller has to wait when the account or the address is backing off.
+// Unknown accounts are tracked the same way, so the response does not reveal them.
+func (s *LoginThrottleService) Attempt(ctx context.Context, account, ip string) (time.Duration, error) {
+	var wait time.Duration
+	var counted []string
+	for _, key := range []struct {
+		name    string
+		backoff loginBackoff
+	}{{accountKey(account), accountBackoff}, {addressKey(ip), addressBackoff}} {
+		remaining, err := s.store.Take(ctx, key.name, key.backoff)
+		if err != nil {
+			return 0, err
+		}
+		if remaining > 0 {
+			wait = max(wait, remaining)
+		} else {
+			counted = append(counted, key.name)
+		}
+	}
+
+	// A refused attempt is not counted against the other key either
+	if wait > 0 {
+		for _, key := range counted {
+			if err := s.store.Forgive(ctx, key); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return wait, nil
+}
+
+// Success forgets failures of the account, the address only forgives the attempt,
+// otherwise one valid account would let an attacker spray others.
+func (s *LoginThrottleService) Success(ctx context.Context, account, ip string) error {
+	if err := s.store.Reset(ctx, accountKey(account)); err != nil {
+		return err
+	}
+	return s.store.Forgive(ctx, addressKey(ip))
+}
+
+// Forgive uncounts the attempt which failed for another reason than wrong credentials
+func (s *LoginThrottleService) Forgive(ctx context.Context, account, ip string) error {
+	if err := s.store.Forgive(ctx, accountKey(account)); err != nil {
+		return err
+	}
+	return s.store.Forgive(ctx, addressKey(ip))
+}
+
+// Unlock lifts the lockout of the account including its second factor, used by admins
+func (s *LoginThrottleService) Unlock(ctx context.Context, email string, userID int32) error {
+	if err := s.store.Reset(ctx, accountKey(email)); err != nil {
+		return err
+	}
+	return s.store.Reset(ctx, accountKey(TwoFactorAccount(userID)))
+}
+
+type loginAttempts struct {
+	failures int
+	last     time.Time
+}
+
+// MemoryLoginAttemptStore is enough for a single instance, attempts are lost on restart
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]loginAttempts
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: map[string]loginAttempts{}}
+}
+
+func (s *MemoryLoginAttemptStore) Take(ctx context.Context, key string, backoff loginBackoff) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired entries are dropped on the way, so that the map does not grow forever
+	now := time.Now()
+	for k, a := range s.attempts {
+		if now.Sub(a.last) > loginAttemptWindow {
+			delete(s.attempts, k)
+		}
+	}
+
+	a := s.attempts[key]
+	if wait := backoff.wait(a.failures, a.last); wait > 0 {
+		return wait, nil
+	}
+	s.attempts[key] = loginAttempts{failures: a.failures + 1, last: now}
+
+	return 0, nil
+}
+
+func (s *MemoryLoginAttemptStore) Forgive(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.attempts[key]; ok {
+		if a.failures <= 1 {
+			delete(s.attempts, key)
+		} else {
+			s.attempts[key] = loginAttempts{failures: a.failures - 1, last: a.last}
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
+
+// PostgresLoginAttemptStore shares attempts between instances
+type PostgresLoginAttemptStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresLoginAttemptStore(db *pgxpool.Pool) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{db}
+}
+
+// Take locks the row of the key, so that instances count concurrent attempts one by one
+func (s *PostgresLoginAttemptStore) Take(ctx context.Context, key string, backoff loginBackoff) (time.Duration, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO LoginAttempt(key, failures, last_failure_at) VALUES ($1, 0, NOW())
+		ON CONFLICT (key) DO NOTHING
+	`, key); err != nil {
+		return 0, err
+	}
+
+	var failures int
+	var last time.Time
+	if err := tx.QueryRow(ctx, `
+		SELECT failures, last_failure_at FROM LoginAttempt WHERE key = $1 FOR UPDATE
+	`, key).Scan(&failures, &last); err != nil {
+		return 0, err
+	}
+	if time.Since(last) > loginAttemptWindow {
+		failures = 0
+	}
+
+	if wait := backoff.wait(failures, last); wait > 0 {
+		return wait, nil
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE LoginAttempt SET failures = $2, last_failure_at = NOW() WHERE key = $1
+	`, key, failures+1); err != nil {
+		return 0, err
+	}
+
+	return 0, tx.Commit(ctx)
+}
+
+func (s *PostgresLoginAttemptStore) Forgive(ctx context.Context, key string) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE LoginAttempt SET failures = GREATEST(failures - 1, 0) WHERE key = $1
+	`, key)
+
+	return err
+}
+
+func (s *PostgresLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM LoginAttempt WHERE key = $1`, key)
+	return err
+}
@@ -30,7 +30,7 @@ func (s *MatchService) CountPlayerMatches(userID int32, searchText string) (int,
 			WHERE
       (tp.player_id IS NOT NULL AND tp.player_id = $1)
       OR
-      (tp.team_id IS NOT NULL AND $1 IN (SELECT user_id FROM TeamPlayer p WHERE p.team_id = tp.team_id))
+      (tp.team_id IS NOT NULL AND EXISTS (SELECT * FROM MatchLineup ml WHERE ml.participant_id = tp.id AND ml.user_id = $1))
 		)
 		SELECT COUNT(*)
 		FROM Match m
@@ -41,7 +41,9 @@ func (s *MatchService) CountPlayerMatches(userID int32, searchText string) (int,
 		LEFT JOIN Team t1 ON tp1.team_id = t1.id
 		LEFT JOIN Team t2 ON tp2.team_id = t2.id
 		JOIN participated p ON p.id = m.first_participant_id OR p.id = m.second_participant_id
-		WHERE ($2 = ''
+		WHERE (tp1.player_id = $1 OR tp2.player_id = $1
+			OR EXISTS (SELECT * FROM MatchLineup ml WHERE ml.match_id = m.id AND ml.participant_id = p.id AND ml.user_id = $1))
+		AND ($2 = ''
 		OR similarity(COALESCE(t1.name, u1.name || ' ' ||u1.surname), $2) > 0.05
 		OR similarity(COALESCE(t2.name, u2.name || ' ' ||u2.surname), $2) > 0.05)
 		`, userID, searchText)
@@ -60,7 +62,7 @@ func (s *MatchService) GetMatchesProfilePlayer(userID, limit, offset int32, sear
 			WHERE
       (tp.player_id IS NOT NULL AND tp.player_id = $1)
       OR
-      (tp.team_id IS NOT NULL AND $1 IN (SELECT user_id FROM TeamPlayer p WHERE p.team_id = tp.team_id))
+      (tp.team_id IS NOT NULL AND EXISTS (SELECT * FROM MatchLineup ml WHERE ml.participant_id = tp.id AND ml.user_id = $1))
 		)
 		SELECT m.id, m."date", p.ttype, p.tid, p.tname,
 		COALESCE(t1.name, u1.name || ' ' ||u1.surname) as fname, COALESCE(t1.id, u1.id) as fid, first_participant_result_text, first_participant_is_winner,
@@ -73,7 +75,9 @@ func (s *MatchService) GetMatchesProfilePlayer(userID, limit, offset int32, sear
 		LEFT JOIN Team t1 ON tp1.team_id = t1.id
 		LEFT JOIN Team t2 ON tp2.team_id = t2.id
 		JOIN participated p ON p.id = m.first_participant_id OR p.id = m.second_participant_id
-		WHERE ($2 = ''
+		WHERE (tp1.player_id = $1 OR tp2.player_id = $1
+			OR EXISTS (SELECT * FROM MatchLineup ml WHERE ml.match_id = m.id AND ml.participant_id = p.id AND ml.user_id = $1))
+		AND ($2 = ''
 		OR similarity(COALESCE(t1.name, u1.name || ' ' ||u1.surname), $2) > 0.05
 		OR similarity(COALESCE(t2.name, u2.name || ' ' ||u2.surname), $2) > 0.05)
 		ORDER BY m."date" DESC
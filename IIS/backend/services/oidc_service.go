@@ -0,0 +1,169 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/jwt"
+	"backend/internal/oidc"
+	"backend/models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const oidcStateTTL = time.Minute * 10
+
+// OIDCService signs users in through external OpenID Connect providers,
+// as an alternative to the password login of RegistrationService.
+type OIDCService struct {
+	db                  *pgxpool.Pool
+	registrationService *RegistrationService
+	audit               *AuditService
+}
+
+func NewOIDCService(db *pgxpool.Pool, registrationService *RegistrationService) *OIDCService {
+	return &OIDCService{db, registrationService, NewAuditService(db)}
+}
+
+// BeginLogin stores the pending login and returns the provider's login page and
+// the state, which is also kept in a cookie to bind the login to the browser.
+func (s *OIDCService) BeginLogin(ctx context.Context, provider *oidc.Provider) (string, string, error) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.AuthURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Abandoned logins are cleaned up on the way
+	if _, err := s.db.Exec(ctx, `DELETE FROM OIDCState WHERE expires_at < NOW()`); err != nil {
+		return "", "", err
+	}
+	if _, err := s.db.Exec(ctx, `
+		INSERT INTO OIDCState(state_hash, provider, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, jwt.HashToken(state), provider.Name, nonce, verifier, time.Now().Add(oidcStateTTL)); err != nil {
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+// CompleteLogin redeems the code and returns the linked, or newly created, user.
+// The callback redirects in any case, so new accounts and links are audited here.
+func (s *OIDCService) CompleteLogin(ctx context.Context, provider *oidc.Provider, state, code, userAgent, ip string) (*models.LoginUserResponse, error) {
+	var nonce, verifier string
+	err := s.db.QueryRow(ctx, `
+		DELETE FROM OIDCState
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING nonce, code_verifier
+	`, jwt.HashToken(state), provider.Name).Scan(&nonce, &verifier)
+	if err == pgx.ErrNoRows {
+		return nil, errors.InvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	identity, err := provider.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, action, err := s.resolveUser(ctx, provider.Name, identity)
+	if err != nil {
+		return nil, err
+	}
+	if action != "" {
+		s.audit.recordEvent(ctx, models.AuditEntry{
+			ActorID:    pgtype.Int4{Int32: userID, Valid: true},
+			Action:     action,
+			EntityType: "user",
+			EntityID:   pgtype.Int4{Int32: userID, Valid: true},
+			Method:     http.MethodGet,
+			Path:       "/auth/oidc/" + provider.Name + "/callback",
+			Status:     http.StatusFound,
+			IP:         ip,
+			UserAgent:  userAgent,
+		})
+	}
+
+	return s.registrationService.GetUserByID(ctx, userID)
+}
+
+// resolveUser finds the user of the identity. Unknown identities are linked to the
+// account with the same email, or a new account without password is created.
+// Both require the email to be verified by the provider and, when linking, also by us,
+// otherwise anyone could take over an account registered with someone else's address.
+// The action of the change is returned, empty for identities linked before.
+func (s *OIDCService) resolveUser(ctx context.Context, provider string, identity *oidc.Identity) (int32, string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int32
+	err = tx.QueryRow(ctx, `
+		SELECT user_id FROM UserIdentity WHERE provider = $1 AND subject = $2
+	`, provider, identity.Subject).Scan(&userID)
+	if err == nil {
+		return userID, "", nil
+	} else if err != pgx.ErrNoRows {
+		return 0, "", err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return 0, "", errors.UnverifiedEmail
+	}
+
+	action := "user.identity.link"
+	var verified bool
+	err = tx.QueryRow(ctx, `
+		SELECT id, email_verified FROM "User" WHERE LOWER(email) = LOWER($1)
+	`, identity.Email).Scan(&userID, &verified)
+	if err == pgx.ErrNoRows {
+		// Empty password hash never matches, the password can be set by the reset flow
+		action = "user.register.oidc"
+		err = tx.QueryRow(ctx, `
+			INSERT INTO "User"(email, password, name, surname, email_verified)
+			VALUES ($1, '', NULLIF($2, ''), NULLIF($3, ''), TRUE)
+			RETURNING id
+		`, identity.Email, identity.GivenName, identity.FamilyName).Scan(&userID)
+		if err != nil {
+			return 0, "", err
+		}
+		if err := claimPendingInvites(ctx, tx, userID, identity.Email); err != nil {
+			return 0, "", err
+		}
+	} else if err != nil {
+		return 0, "", err
+	} else if !verified {
+		return 0, "", errors.UnverifiedEmail
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO UserIdentity(user_id, provider, subject, email) VALUES ($1, $2, $3, $4)
+	`, userID, provider, identity.Subject, identity.Email); err != nil {
+		return 0, "", err
+	}
+
+	return userID, action, tx.Commit(ctx)
+}
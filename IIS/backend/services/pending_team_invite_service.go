@@ -0,0 +1,100 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/mail"
+	"backend/models"
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PendingTeamInviteService manages invites of emails without an account,
+// they become ordinary invites once an account verifies the email.
+type PendingTeamInviteService struct {
+	db     *pgxpool.Pool
+	mailer mail.Sender
+}
+
+func NewPendingTeamInviteService(db *pgxpool.Pool, mailer mail.Sender) *PendingTeamInviteService {
+	return &PendingTeamInviteService{db, mailer}
+}
+
+// SendInvites emails a signup link to every address, all of them are tried
+// even when some fail and the first failure is returned.
+func (s *PendingTeamInviteService) SendInvites(teamName string, emails []string) error {
+	var first error
+	for _, email := range emails {
+		err := s.mailer.Send(email, "You are invited to a team", fmt.Sprintf(
+			"You were invited to join the team %s.\n\nSign up with this email address to accept the invite: %s\n\nThe invite expires in 30 days.",
+			teamName, mail.Page("/signup?email="+url.QueryEscape(email)),
+		))
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+func (s *PendingTeamInviteService) GetInvites(ctx context.Context, teamID int32) ([]models.PendingTeamInvite, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, team_id, email, name, squad, created_at, expires_at FROM PendingTeamInvite
+		WHERE team_id = $1 AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []models.PendingTeamInvite{}
+	for rows.Next() {
+		var invite models.PendingTeamInvite
+		if err := rows.Scan(&invite.ID, &invite.TeamID, &invite.Email, &invite.Name, &invite.Squad, &invite.CreatedAt, &invite.ExpiresAt); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+func (s *PendingTeamInviteService) CancelInvite(ctx context.Context, teamID, inviteID int32) error {
+	tag, err := s.db.Exec(ctx, `
+		DELETE FROM PendingTeamInvite WHERE id = $1 AND team_id = $2
+	`, inviteID, teamID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.DBNotFound
+	}
+
+	return nil
+}
+
+// claimPendingInvites turns invites of the email into invites of the user who verified it.
+// Expired invites and invites of disbanded teams are dropped.
+func claimPendingInvites(ctx context.Context, tx pgx.Tx, userID int32, email string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO TeamPlayer(user_id, team_id, squad)
+		SELECT $1::INT, p.team_id, p.squad FROM PendingTeamInvite p
+		JOIN Team t ON t.id = p.team_id
+		WHERE LOWER(p.email) = LOWER($2) AND p.expires_at > NOW() AND t.archived_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM TeamPlayer tp WHERE tp.team_id = p.team_id AND tp.user_id = $1 AND tp.until IS NULL)
+	`, userID, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM PendingTeamInvite WHERE LOWER(email) = LOWER($1)`, email)
+	return err
+}
@@ -0,0 +1,297 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/models"
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PredictionService struct {
+	db *pgxpool.Pool
+}
+
+func NewPredictionService(db *pgxpool.Pool) *PredictionService {
+	return &PredictionService{db}
+}
+
+// matchLocked is true once the match has begun or its winner is known,
+// predictions of such match cannot be changed anymore.
+const matchLocked = `(m."date" <= NOW() OR m.first_participant_is_winner OR m.second_participant_is_winner)`
+
+// bracketSlots numbers the matches of every stage in the order they were generated,
+// the number is the slot a prediction refers to.
+const bracketSlots = `
+	WITH slots AS (
+		SELECT m.*, s.tournament_id, s.level, ROW_NUMBER() OVER (PARTITION BY m.stage_id ORDER BY m.id)::INT AS slot
+		FROM Match m
+		JOIN Stage s ON s.id = m.stage_id
+	)`
+
+func (s *PredictionService) GetUserPredictions(tournamentID, userID int32) ([]models.Prediction, error) {
+	ctx := context.Background()
+	predictions := []models.Prediction{}
+
+	rows, err := s.db.Query(ctx, bracketSlots+`
+		SELECT p.level, p.slot, m.id, p.participant_id, p.points, COALESCE(`+matchLocked+`, FALSE), p.updated_at
+		FROM Prediction p
+		LEFT JOIN slots m ON m.tournament_id = p.tournament_id AND m.level = p.level AND m.slot = p.slot
+		WHERE p.tournament_id = $1 AND p.user_id = $2
+		ORDER BY p.level, p.slot
+	`, tournamentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Prediction
+		if err := rows.Scan(&p.Level, &p.Slot, &p.MatchID, &p.ParticipantID, &p.Points, &p.Locked, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		predictions = append(predictions, p)
+	}
+
+	return predictions, rows.Err()
+}
+
+// SavePredictions stores picks of the user, the whole bracket can be predicted at once
+// or round by round, even before the bracket is generated, picks it makes impossible are
+// dropped then. Once it is, picks of locked matches and of participants who can no longer
+// reach the match are refused.
+func (s *PredictionService) SavePredictions(tournamentID, userID int32, req *models.PredictionRequest) error {
+	ctx := context.Background()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var finished, started bool
+	err = tx.QueryRow(ctx, `
+		SELECT t.finished_at IS NOT NULL, EXISTS (SELECT 1 FROM Stage s WHERE s.tournament_id = t.id)
+		FROM Tournament t
+		WHERE t.id = $1 AND t.state = 'Accepted'
+	`, tournamentID).Scan(&finished, &started)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("Tournament %d does not exist", tournamentID)
+	} else if err != nil {
+		return err
+	}
+	if finished {
+		return fmt.Errorf("The tournament is already finished")
+	}
+
+	for _, pick := range req.Predictions {
+		if pick.MatchID != 0 {
+			err := tx.QueryRow(ctx, bracketSlots+`
+				SELECT m.level, m.slot FROM slots m WHERE m.id = $1 AND m.tournament_id = $2
+			`, pick.MatchID, tournamentID).Scan(&pick.Level, &pick.Slot)
+			if err == pgx.ErrNoRows {
+				return fmt.Errorf("Match %d does not belong to the tournament", pick.MatchID)
+			} else if err != nil {
+				return err
+			}
+		} else if pick.Level == 0 || pick.Slot == 0 {
+			return fmt.Errorf("Every pick has to name the match by its ID or by level and slot")
+		}
+
+		var accepted bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM TournamentParticipant
+				WHERE id = $1 AND tournament_id = $2 AND state = 'Accepted'
+			)
+		`, pick.ParticipantID, tournamentID).Scan(&accepted); err != nil {
+			return err
+		}
+		if !accepted {
+			return fmt.Errorf("Participant %d does not play in the tournament", pick.ParticipantID)
+		}
+
+		if started {
+			if err := checkPick(ctx, tx, tournamentID, pick); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO Prediction(user_id, tournament_id, level, slot, participant_id)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, tournament_id, level, slot)
+			DO UPDATE SET participant_id = EXCLUDED.participant_id, updated_at = NOW()
+		`, userID, tournamentID, pick.Level, pick.Slot, pick.ParticipantID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// checkPick validates the pick against the generated bracket. The participant has to play
+// in a match that leads to the picked one and must not have lost any match yet.
+func checkPick(ctx context.Context, tx pgx.Tx, tournamentID int32, pick models.PredictionPick) error {
+	var matchID int32
+	var locked bool
+	var fid, sid pgtype.Int4
+	err := tx.QueryRow(ctx, bracketSlots+`
+		SELECT m.id, COALESCE(`+matchLocked+`, FALSE), m.first_participant_id, m.second_participant_id
+		FROM slots m
+		WHERE m.tournament_id = $1 AND m.level = $2 AND m.slot = $3
+	`, tournamentID, pick.Level, pick.Slot).Scan(&matchID, &locked, &fid, &sid)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("Stage %d of the bracket has no match %d", pick.Level, pick.Slot)
+	} else if err != nil {
+		return err
+	}
+
+	if locked {
+		return fmt.Errorf("Predictions for match %d are locked", matchID)
+	}
+
+	if fid.Valid && sid.Valid && pick.ParticipantID != fid.Int32 && pick.ParticipantID != sid.Int32 {
+		return fmt.Errorf("Participant %d does not play in match %d", pick.ParticipantID, matchID)
+	}
+
+	var reachable bool
+	if err := tx.QueryRow(ctx, `
+		WITH RECURSIVE feeders AS (
+			SELECT id, first_participant_id, second_participant_id FROM Match WHERE id = $1
+			UNION ALL
+			SELECT m.id, m.first_participant_id, m.second_participant_id
+			FROM Match m
+			JOIN feeders f ON m.next_match_id = f.id
+		)
+		SELECT EXISTS (SELECT 1 FROM feeders WHERE $2 IN (first_participant_id, second_participant_id))
+		AND NOT EXISTS (
+			SELECT 1 FROM Match m
+			WHERE (m.first_participant_id = $2 AND m.second_participant_is_winner)
+			   OR (m.second_participant_id = $2 AND m.first_participant_is_winner)
+		)
+	`, matchID, pick.ParticipantID).Scan(&reachable); err != nil {
+		return err
+	}
+	if !reachable {
+		return fmt.Errorf("Participant %d can no longer reach match %d", pick.ParticipantID, matchID)
+	}
+
+	return nil
+}
+
+// dropUnreachablePredictions removes picks made before the bracket was generated which
+// it made impossible, i.e. of a slot the bracket does not have or of a participant
+// placed in another part of it. They would stay unscored otherwise.
+func dropUnreachablePredictions(ctx context.Context, tx pgx.Tx, tournamentID int) error {
+	_, err := tx.Exec(ctx, bracketSlots+`
+		DELETE FROM Prediction p
+		WHERE p.tournament_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM slots m
+			WHERE m.tournament_id = p.tournament_id AND m.level = p.level AND m.slot = p.slot
+			AND p.participant_id IN (
+				WITH RECURSIVE feeders AS (
+					SELECT f.id, f.first_participant_id, f.second_participant_id FROM Match f WHERE f.id = m.id
+					UNION ALL
+					SELECT f.id, f.first_participant_id, f.second_participant_id
+					FROM Match f
+					JOIN feeders ON f.next_match_id = feeders.id
+				)
+				SELECT first_participant_id FROM feeders
+				UNION
+				SELECT second_participant_id FROM feeders
+			)
+		)
+	`, tournamentID)
+
+	return err
+}
+
+func (s *PredictionService) GetLeaderboard(tournamentID int32, page, limit int) (models.PaginationAnswer[models.PredictionStanding], error) {
+	var ans models.PaginationAnswer[models.PredictionStanding]
+	ctx := context.Background()
+	offset := (page - 1) * limit
+
+	var total int
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT p.user_id) FROM Prediction p WHERE p.tournament_id = $1
+	`, tournamentID).Scan(&total)
+	if err != nil {
+		return ans, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT RANK() OVER (ORDER BY COALESCE(SUM(p.points), 0) DESC),
+		       u.id, u.name, u.surname,
+		       COALESCE(SUM(p.points), 0),
+		       COUNT(*) FILTER (WHERE p.points > 0),
+		       COUNT(*)
+		FROM Prediction p
+		JOIN "User" u ON u.id = p.user_id
+		WHERE p.tournament_id = $1
+		GROUP BY u.id, u.name, u.surname
+		ORDER BY 5 DESC, 6 DESC, u.id
+		LIMIT $2 OFFSET $3
+	`, tournamentID, limit, offset)
+	if err != nil {
+		return ans, err
+	}
+	defer rows.Close()
+
+	standings := []models.PredictionStanding{}
+	for rows.Next() {
+		var st models.PredictionStanding
+		if err := rows.Scan(
+			&st.Rank,
+			&st.UserID,
+			&st.Name,
+			&st.Surname,
+			&st.Points,
+			&st.Correct,
+			&st.Predictions,
+		); err != nil {
+			return ans, err
+		}
+		standings = append(standings, st)
+	}
+	if err := rows.Err(); err != nil {
+		return ans, err
+	}
+
+	totalPages := (total + limit - 1) / limit
+	ans = models.PaginationAnswer[models.PredictionStanding]{
+		Data:         standings,
+		TotalRecords: total,
+		TotalPages:   totalPages,
+		CurrentPage:  page,
+		Limit:        limit,
+	}
+	return ans, nil
+}
+
+// scorePredictions rescores all predictions of the tournament after its bracket changed.
+// A correct pick is worth 2^(level-1) points, so later rounds weigh more.
+func scorePredictions(ctx context.Context, tx pgx.Tx, tournamentID int) error {
+	_, err := tx.Exec(ctx, bracketSlots+`
+		UPDATE Prediction p
+		SET points = CASE
+			WHEN m.first_participant_is_winner OR m.second_participant_is_winner THEN
+				CASE WHEN p.participant_id = CASE WHEN m.first_participant_is_winner
+				                                  THEN m.first_participant_id
+				                                  ELSE m.second_participant_id END
+				     THEN 1 << (m.level - 1)
+				     ELSE 0 END
+			ELSE NULL END
+		FROM slots m
+		WHERE m.tournament_id = p.tournament_id AND m.level = p.level AND m.slot = p.slot
+		  AND p.tournament_id = $1
+	`, tournamentID)
+
+	return err
+}
@@ -6,15 +6,24 @@ package services
 
 import (
 	"backend/internal/errors"
+	"backend/internal/password"
 	"backend/models"
 	"context"
+	"log"
 	"net/http"
+	"sync"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// dummyHash is compared when the email is unknown, so that the response takes the same
+// time as for an existing account. It is made on the first login, once the configuration
+// of the algorithm is loaded, as a hash of another algorithm would take different time.
+var dummyHash = sync.OnceValues(func() (string, error) {
+	return password.Hash("dummy password")
+})
+
 type RegistrationService struct {
 	db          *pgxpool.Pool
 	userService *UserService
@@ -33,12 +42,12 @@ func (s *RegistrationService) RegisterUser(ctx context.Context, req models.Regis
 	}
 	defer func() { _ = tx.Rollback(ctx) }()
 
-	pwdHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	pwdHash, err := password.Hash(req.Password)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Password = string(pwdHash)
+	req.Password = pwdHash
 	response, err := s.userService.CreateUser(ctx, tx, req)
 	if err != nil {
 		return nil, &errors.APIError{
@@ -67,18 +76,41 @@ func (s *RegistrationService) LoginUser(ctx context.Context, req models.LoginUse
 
 	response, err := s.userService.GetUserByEmail(ctx, req.Email)
 	if err != nil {
-		return nil, errors.DBNotFound
+		return nil, err
+	}
+	if response == nil {
+		hash, err := dummyHash()
+		if err != nil {
+			return nil, err
+		}
+		password.Verify(hash, req.Password)
+		return nil, errors.InvalidPassword
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(response.Password), []byte(req.Password)); err != nil {
+	ok, outdated := password.Verify(response.Password, req.Password)
+	if !ok {
 		return nil, errors.InvalidPassword
 	}
+	// Checked after the password, so that the state is not revealed to strangers
+	if err := accountStatusError(response.Status, response.StatusReason, response.StatusUntil); err != nil {
+		return nil, err
+	}
+
+	// The plain password is known only now, so hashes made with older
+	// algorithm or parameters are upgraded on login
+	if outdated {
+		if err := s.rehashPassword(ctx, response.ID, req.Password); err != nil {
+			log.Printf("password rehash for user %d failed: %v", response.ID, err)
+		}
+	}
 
 	out.ID = response.ID
 	out.Email = response.Email
 	out.Name = response.Name.String
 	out.Surname = response.Surname.String
 	out.Role = response.Role
+	out.EmailVerified = response.EmailVerified
+	out.TOTPEnabled = response.TOTPEnabled
 
 	return out, nil
 }
@@ -87,15 +119,20 @@ func (s *RegistrationService) ReloginUser(ctx context.Context, user_id int32) (*
 	out := &models.LoginUserResponse{}
 
 	response, err := s.userService.GetUserById(ctx, user_id)
-	if err != nil {
+	if err != nil || response == nil {
 		return nil, errors.DBNotFound
 	}
+	if err := accountStatusError(response.Status, response.StatusReason, response.StatusUntil); err != nil {
+		return nil, err
+	}
 
 	out.ID = response.ID
 	out.Email = response.Email
 	out.Name = response.Name.String
 	out.Surname = response.Surname.String
 	out.Role = response.Role
+	out.EmailVerified = response.EmailVerified
+	out.TOTPEnabled = response.TOTPEnabled
 
 	return out, nil
 }
@@ -104,15 +141,30 @@ func (s *RegistrationService) GetUserByID(ctx context.Context, user_id int32) (*
 	out := &models.LoginUserResponse{}
 
 	response, err := s.userService.GetUserById(ctx, user_id)
-	if err != nil {
+	if err != nil || response == nil {
 		return nil, errors.DBNotFound
 	}
+	if err := accountStatusError(response.Status, response.StatusReason, response.StatusUntil); err != nil {
+		return nil, err
+	}
 
 	out.ID = response.ID
 	out.Email = response.Email
 	out.Name = response.Name.String
 	out.Surname = response.Surname.String
 	out.Role = response.Role
+	out.EmailVerified = response.EmailVerified
+	out.TOTPEnabled = response.TOTPEnabled
 
 	return out, nil
 }
+
+func (s *RegistrationService) rehashPassword(ctx context.Context, userID int32, plain string) error {
+	pwdHash, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, `UPDATE "User" SET password = $1 WHERE id = $2`, pwdHash, userID)
+	return err
+}
@@ -0,0 +1,161 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/roster"
+	"backend/models"
+	"context"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RosterImportService invites a whole squad from a file. Registered users get
+// ordinary invites, other emails get pending invites, invalid rows are reported.
+type RosterImportService struct {
+	db *pgxpool.Pool
+}
+
+func NewRosterImportService(db *pgxpool.Pool) *RosterImportService {
+	return &RosterImportService{db}
+}
+
+// Import processes the rows in one transaction, which is committed only when applying.
+// A preview therefore reports exactly what applying the same file would do.
+func (s *RosterImportService) Import(ctx context.Context, teamID, invitedBy int32, rows []roster.Row, apply bool) (*models.RosterImportReport, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var managerID int32
+	err = tx.QueryRow(ctx, `
+		SELECT manager_id FROM Team WHERE id = $1 AND archived_at IS NULL FOR UPDATE
+	`, teamID).Scan(&managerID)
+	if err == pgx.ErrNoRows {
+		return nil, errors.DBNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM PendingTeamInvite WHERE team_id = $1 AND expires_at <= NOW()
+	`, teamID); err != nil {
+		return nil, err
+	}
+
+	report := &models.RosterImportReport{Applied: apply, Rows: []models.RosterImportRow{}}
+	seen := map[string]int{}
+	for _, row := range rows {
+		result := models.RosterImportRow{Line: row.Line, Email: row.Email, Name: row.Name}
+		result.Squad, result.Message = validateRosterRow(row)
+
+		if result.Message == "" {
+			key := strings.ToLower(row.Email)
+			if line, ok := seen[key]; ok {
+				result.Message = "The email is already on line " + strconv.Itoa(line)
+			} else {
+				seen[key] = row.Line
+				result.Action, result.Message, err = importRosterRow(ctx, tx, teamID, managerID, invitedBy, row, result.Squad)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		switch {
+		case result.Message != "":
+			result.Action = models.RosterImportError
+			report.Errors++
+		case result.Action == models.RosterImportInvite:
+			report.Invites++
+		case result.Action == models.RosterImportPendingInvite:
+			report.PendingInvites++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	if apply {
+		if err := tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// validateRosterRow returns the squad of the role, or why the row is invalid
+func validateRosterRow(row roster.Row) (string, string) {
+	address, err := mail.ParseAddress(row.Email)
+	if row.Email == "" || err != nil || address.Address != row.Email {
+		return "", "Invalid email"
+	}
+	if row.Name == "" {
+		return "", "Name is required"
+	}
+	if utf8.RuneCountInString(row.Name) > 100 {
+		return "", "Name is too long"
+	}
+
+	switch strings.ToLower(row.Role) {
+	case "", "starter", "player":
+		return "Starter", ""
+	case "substitute", "sub":
+		return "Substitute", ""
+	default:
+		return "", "Role has to be Starter or Substitute"
+	}
+}
+
+// importRosterRow invites the registered user or creates a pending invite for the email,
+// a message instead of the action tells why the row cannot be imported.
+func importRosterRow(ctx context.Context, tx pgx.Tx, teamID, managerID, invitedBy int32, row roster.Row, squad string) (string, string, error) {
+	var userID int32
+	var role string
+	var member bool
+	err := tx.QueryRow(ctx, `
+		SELECT u.id, u.role, EXISTS (
+			SELECT 1 FROM TeamPlayer tp WHERE tp.team_id = $2 AND tp.user_id = u.id AND tp.until IS NULL
+		)
+		FROM "User" u WHERE LOWER(u.email) = LOWER($1)
+	`, row.Email, teamID).Scan(&userID, &role, &member)
+	if err == pgx.ErrNoRows {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO PendingTeamInvite(team_id, email, name, squad, invited_by) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT DO NOTHING
+		`, teamID, row.Email, row.Name, squad, invitedBy)
+		if err != nil {
+			return "", "", err
+		}
+		if tag.RowsAffected() == 0 {
+			return "", "The email is already invited", nil
+		}
+		return models.RosterImportPendingInvite, "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case role == "Admin":
+		return "", "Administrators cannot join teams", nil
+	case userID == managerID:
+		return "", "The manager cannot be invited to the team", nil
+	case member:
+		return "", "User is already in the team or has a pending invite or request", nil
+	}
+
+	if _, err := addInvitedPlayer(ctx, tx, userID, teamID, squad); err != nil {
+		return "", "", err
+	}
+
+	return models.RosterImportInvite, "", nil
+}
@@ -0,0 +1,146 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/models"
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// transferEvents lists joins and departures of all memberships. A join refers to the team
+// the player left last before joining, which makes it a transfer between the teams.
+const transferEvents = `
+	WITH events AS (
+		SELECT 'Join' AS type, tp.since AS date, tp.user_id, tp.team_id, prev.team_id AS from_team_id
+		FROM TeamPlayer tp
+		LEFT JOIN LATERAL (
+			SELECT p.team_id FROM TeamPlayer p
+			WHERE p.user_id = tp.user_id AND p.team_id <> tp.team_id AND p.until IS NOT NULL AND p.until <= tp.since
+			ORDER BY p.until DESC
+			LIMIT 1
+		) prev ON TRUE
+		WHERE tp.since IS NOT NULL
+		UNION ALL
+		SELECT 'Leave', tp.until, tp.user_id, tp.team_id, NULL
+		FROM TeamPlayer tp
+		WHERE tp.since IS NOT NULL AND tp.until IS NOT NULL
+	)`
+
+// RosterTimelineService exposes the history of memberships kept in TeamPlayer
+type RosterTimelineService struct {
+	db *pgxpool.Pool
+}
+
+func NewRosterTimelineService(db *pgxpool.Pool) *RosterTimelineService {
+	return &RosterTimelineService{db}
+}
+
+// GetTeamTimeline returns every member the team ever had, a player who left
+// and came back has an entry for each period.
+func (s *RosterTimelineService) GetTeamTimeline(ctx context.Context, teamID int32) ([]models.TeamTimelineEntry, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT u.id, u.name, u.surname, tp.squad, tp.since, tp.until
+		FROM TeamPlayer tp
+		JOIN "User" u ON u.id = tp.user_id
+		WHERE tp.team_id = $1 AND tp.since IS NOT NULL
+		ORDER BY tp.since DESC, tp.id DESC
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timeline := []models.TeamTimelineEntry{}
+	for rows.Next() {
+		var entry models.TeamTimelineEntry
+		if err := rows.Scan(&entry.UserID, &entry.Name, &entry.Surname, &entry.Squad, &entry.Since, &entry.Until); err != nil {
+			return nil, err
+		}
+		timeline = append(timeline, entry)
+	}
+
+	return timeline, rows.Err()
+}
+
+// GetPlayerTimeline returns every team the user played for, disbanded teams included
+func (s *RosterTimelineService) GetPlayerTimeline(ctx context.Context, userID int32) ([]models.PlayerTimelineEntry, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT t.id, t.name, tp.squad, tp.since, tp.until, t.archived_at
+		FROM TeamPlayer tp
+		JOIN Team t ON t.id = tp.team_id
+		WHERE tp.user_id = $1 AND tp.since IS NOT NULL
+		ORDER BY tp.since DESC, tp.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timeline := []models.PlayerTimelineEntry{}
+	for rows.Next() {
+		var entry models.PlayerTimelineEntry
+		if err := rows.Scan(&entry.TeamID, &entry.TeamName, &entry.Squad, &entry.Since, &entry.Until, &entry.ArchivedAt); err != nil {
+			return nil, err
+		}
+		timeline = append(timeline, entry)
+	}
+
+	return timeline, rows.Err()
+}
+
+// GetTransfers returns the platform-wide feed of joins and departures, newest first.
+// With teamID only events of the team are listed, joins from the team included.
+func (s *RosterTimelineService) GetTransfers(ctx context.Context, page, limit int, teamID int32) (models.PaginationAnswer[models.TransferEvent], error) {
+	var ans models.PaginationAnswer[models.TransferEvent]
+	offset := (page - 1) * limit
+
+	var total int
+	err := s.db.QueryRow(ctx, transferEvents+`
+		SELECT COUNT(*) FROM events e
+		WHERE ($1 = 0 OR e.team_id = $1 OR e.from_team_id = $1)
+	`, teamID).Scan(&total)
+	if err != nil {
+		return ans, err
+	}
+
+	rows, err := s.db.Query(ctx, transferEvents+`
+		SELECT e.type, e.date, u.id, u.name, u.surname, e.team_id, t.name, e.from_team_id, f.name
+		FROM events e
+		JOIN "User" u ON u.id = e.user_id
+		JOIN Team t ON t.id = e.team_id
+		LEFT JOIN Team f ON f.id = e.from_team_id
+		WHERE ($1 = 0 OR e.team_id = $1 OR e.from_team_id = $1)
+		ORDER BY e.date DESC, e.type DESC, u.id
+		LIMIT $2 OFFSET $3
+	`, teamID, limit, offset)
+	if err != nil {
+		return ans, err
+	}
+	defer rows.Close()
+
+	events := []models.TransferEvent{}
+	for rows.Next() {
+		var event models.TransferEvent
+		if err := rows.Scan(&event.Type, &event.Date, &event.UserID, &event.Name, &event.Surname,
+			&event.TeamID, &event.TeamName, &event.FromTeamID, &event.FromTeamName); err != nil {
+			return ans, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return ans, err
+	}
+
+	ans = models.PaginationAnswer[models.TransferEvent]{
+		Data:         events,
+		TotalRecords: total,
+		TotalPages:   (total + limit - 1) / limit,
+		CurrentPage:  page,
+		Limit:        limit,
+	}
+	return ans, nil
+}
@@ -78,6 +78,24 @@ func (s *S3Service) GetPresignURL(key string) (string, error) {
 	return get.URL, err
 }
 
+// GetObject opens the image, the returned key contains its extension
+func (s *S3Service) GetObject(key string) (io.ReadCloser, string, error) {
+	image, err := s.ImageExists(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	out, err := s.s3client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("AWS_BUCKET_NAME")),
+		Key:    aws.String(image),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return out.Body, image, nil
+}
+
 func (s *S3Service) PutObject(key, contentType string, contentLength int64, body io.Reader) error {
 	_, err := s.s3client.PutObject(context.Background(), &s3.PutObjectInput{
 		Bucket:        aws.String(os.Getenv("AWS_BUCKET_NAME")),
@@ -0,0 +1,215 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/jwt"
+	"backend/models"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SessionService struct {
+	db    *pgxpool.Pool
+	audit *AuditService
+}
+
+func NewSessionService(db *pgxpool.Pool) *SessionService {
+	return &SessionService{db, NewAuditService(db)}
+}
+
+// CreateSession starts a new session of the user and returns its first refresh token.
+func (s *SessionService) CreateSession(ctx context.Context, userID int32, userAgent, ip string) (int32, string, error) {
+	token, hash, err := jwt.GenerateOpaqueToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID int32
+	err = tx.QueryRow(ctx, `
+		INSERT INTO Session(user_id, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, userID, userAgent, ip, time.Now().Add(jwt.RefreshTokenTTL)).Scan(&sessionID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO RefreshToken(session_id, token_hash) VALUES ($1, $2)
+	`, sessionID, hash); err != nil {
+		return 0, "", err
+	}
+
+	return sessionID, token, tx.Commit(ctx)
+}
+
+// RotateSession exchanges the refresh token for a new one. Presenting an already
+// rotated token means it was stolen, so the whole session is revoked.
+func (s *SessionService) RotateSession(ctx context.Context, refresh, userAgent, ip string) (int32, int32, string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var tokenID, sessionID, userID int32
+	var rotatedAt, revokedAt pgtype.Timestamp
+	var expiresAt time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT rt.id, rt.rotated_at, s.id, s.user_id, s.expires_at, s.revoked_at
+		FROM RefreshToken rt
+		JOIN Session s ON s.id = rt.session_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE
+	`, jwt.HashToken(refresh)).Scan(&tokenID, &rotatedAt, &sessionID, &userID, &expiresAt, &revokedAt)
+	if err == pgx.ErrNoRows {
+		return 0, 0, "", errors.SessionExpired
+	} else if err != nil {
+		return 0, 0, "", err
+	}
+
+	if revokedAt.Valid || time.Now().After(expiresAt) {
+		return 0, 0, "", errors.SessionExpired
+	}
+
+	if rotatedAt.Valid {
+		if _, err := tx.Exec(ctx, `UPDATE Session SET revoked_at = NOW() WHERE id = $1`, sessionID); err != nil {
+			return 0, 0, "", err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return 0, 0, "", err
+		}
+		// The request fails, so the middleware would not record the revocation
+		s.audit.recordEvent(ctx, models.AuditEntry{
+			ActorID:    pgtype.Int4{Int32: userID, Valid: true},
+			Action:     "user.session.reuse",
+			EntityType: "user.account",
+			EntityID:   pgtype.Int4{Int32: userID, Valid: true},
+			Method:     http.MethodPost,
+			Path:       "/auth/refresh",
+			Status:     http.StatusUnauthorized,
+			IP:         ip,
+			UserAgent:  userAgent,
+		})
+		return 0, 0, "", errors.TokenReused
+	}
+
+	token, hash, err := jwt.GenerateOpaqueToken()
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE RefreshToken SET rotated_at = NOW() WHERE id = $1`, tokenID); err != nil {
+		return 0, 0, "", err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO RefreshToken(session_id, token_hash) VALUES ($1, $2)
+	`, sessionID, hash); err != nil {
+		return 0, 0, "", err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE Session
+		SET last_used_at = NOW(), expires_at = $2, user_agent = $3, ip = $4
+		WHERE id = $1
+	`, sessionID, time.Now().Add(jwt.RefreshTokenTTL), userAgent, ip); err != nil {
+		return 0, 0, "", err
+	}
+
+	return userID, sessionID, token, tx.Commit(ctx)
+}
+
+func (s *SessionService) GetUserSessions(ctx context.Context, userID, currentID int32) ([]models.Session, error) {
+	sessions := []models.Session{}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, user_agent, ip, created_at, last_used_at, expires_at
+		FROM Session
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(
+			&session.ID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentID
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (s *SessionService) RevokeSession(ctx context.Context, userID, sessionID int32) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE Session SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.DBNotFound
+	}
+
+	return nil
+}
+
+// RevokeByRefreshToken ends the session the refresh token belongs to, used on logout.
+func (s *SessionService) RevokeByRefreshToken(ctx context.Context, refresh string) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE Session SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND id = (
+			SELECT session_id FROM RefreshToken WHERE token_hash = $1
+		)
+	`, jwt.HashToken(refresh))
+
+	return err
+}
+
+func (s *SessionService) RevokeUserSessions(ctx context.Context, userID int32) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE Session SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+
+	return err
+}
+
+// IsSessionActive lets the access token be refused right after its session is revoked.
+func (s *SessionService) IsSessionActive(ctx context.Context, sessionID int32) (bool, error) {
+	var active bool
+	err := s.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM Session WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		)
+	`, sessionID).Scan(&active)
+
+	return active, err
+}
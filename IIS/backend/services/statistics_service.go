@@ -0,0 +1,328 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/models"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StatisticsService keeps ParticipantStatistic tables in sync with match results.
+// Statistics of a subject are recomputed only when its matches, lineups, placements
+// or accepted participations change, so profile reads never scan the match history.
+// Roster changes alone do not affect them, players are credited by match lineups.
+type StatisticsService struct {
+	db *pgxpool.Pool
+}
+
+func NewStatisticsService(db *pgxpool.Pool) *StatisticsService {
+	return &StatisticsService{db}
+}
+
+// teamPlayedMatches lists decided matches of the team $1, shaped as playedMatches.
+const teamPlayedMatches = `
+	played AS (
+		SELECT m.id, m."date", tp.tournament_id, TRUE AS is_team,
+		       CASE WHEN tp.id = m.first_participant_id THEN m.first_participant_is_winner
+		            ELSE m.second_participant_is_winner END AS won
+		FROM Match m
+		JOIN TournamentParticipant tp ON tp.id = m.first_participant_id OR tp.id = m.second_participant_id
+		WHERE (m.first_participant_is_winner OR m.second_participant_is_winner)
+		AND tp.team_id = $1
+	)`
+
+// statisticsQueries returns the played matches CTE and the participation condition of the subject.
+func statisticsQueries(subject profileSubject) (string, string) {
+	if subject.kind == "Team" {
+		return teamPlayedMatches, ` tp.team_id = $1`
+	}
+	return playedMatches, playedFor
+}
+
+type statisticsSnapshot struct {
+	wins        int
+	loses       int
+	winnings    int32
+	disciplines map[string]int
+	activity    map[string][2]int
+}
+
+func (s *StatisticsService) GetPlayerStatistics(userID int32) (models.ProfileStatistics, error) {
+	return s.getStatistics(playerSubject(userID))
+}
+
+func (s *StatisticsService) GetTeamStatistics(teamID int32) (models.ProfileStatistics, error) {
+	return s.getStatistics(teamSubject(teamID))
+}
+
+// getStatistics reads the precomputed statistics, computing them first
+// for subjects which were never refreshed.
+func (s *StatisticsService) getStatistics(subject profileSubject) (models.ProfileStatistics, error) {
+	ctx := context.Background()
+	var stats models.ProfileStatistics
+
+	var statID int32
+	err := s.db.QueryRow(ctx, `
+		SELECT id, wins, loses, winnings FROM ParticipantStatistic
+		WHERE `+subject.column+` = $1
+	`, subject.id).Scan(&statID, &stats.Winrate.Wins, &stats.Winrate.Loses, &stats.Winnings)
+	if err == pgx.ErrNoRows {
+		if err := s.refresh(ctx, subject); err != nil {
+			return stats, err
+		}
+		return s.getStatistics(subject)
+	} else if err != nil {
+		return stats, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT discipline, tournaments FROM ParticipantDisciplineStatistic
+		WHERE statistic_id = $1
+		ORDER BY discipline
+	`, statID)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	stats.Disciplines = []models.DisciplineStatistic{}
+	for rows.Next() {
+		var discipline models.DisciplineStatistic
+		if err := rows.Scan(&discipline.Name, &discipline.Tournaments); err != nil {
+			return stats, err
+		}
+		stats.Disciplines = append(stats.Disciplines, discipline)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT month, personal, teams FROM ParticipantActivityStatistic
+		WHERE statistic_id = $1 AND month >= date_trunc('month', CURRENT_DATE) - INTERVAL '3 month'
+	`, statID)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	stats.Activity = map[string]models.ActivityStatistic{}
+	for rows.Next() {
+		var month pgtype.Date
+		var monthstat models.ActivityStatistic
+		if err := rows.Scan(&month, &monthstat.Personal, &monthstat.Teams); err != nil {
+			return stats, err
+		}
+		monthstat.Month = month.Time.Month().String()
+		stats.Activity[monthstat.Month] = monthstat
+	}
+
+	return stats, rows.Err()
+}
+
+// RefreshTournament recomputes statistics of everyone who took part in the tournament.
+func (s *StatisticsService) RefreshTournament(tournamentID int32) error {
+	ctx := context.Background()
+
+	subjects, err := tournamentSubjects(ctx, s.db, tournamentID)
+	if err != nil {
+		return err
+	}
+
+	for _, subject := range subjects {
+		if err := s.refresh(ctx, subject); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RefreshParticipant recomputes statistics of the team or player behind the
+// tournament participant, used when its participation is accepted or rejected.
+func (s *StatisticsService) RefreshParticipant(participantID int32) error {
+	ctx := context.Background()
+
+	var teamID, playerID pgtype.Int4
+	err := s.db.QueryRow(ctx, `
+		SELECT team_id, player_id FROM TournamentParticipant WHERE id = $1
+	`, participantID).Scan(&teamID, &playerID)
+	if err != nil {
+		return err
+	}
+
+	if teamID.Valid {
+		return s.refresh(ctx, teamSubject(teamID.Int32))
+	}
+	return s.refresh(ctx, playerSubject(playerID.Int32))
+}
+
+func (s *StatisticsService) refresh(ctx context.Context, subject profileSubject) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := refreshStatistics(ctx, tx, subject); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// refreshStatistics recomputes statistics of the subject within the transaction, services
+// that change results or accepted participations call it before committing their change.
+func refreshStatistics(ctx context.Context, tx pgx.Tx, subject profileSubject) error {
+	played, condition := statisticsQueries(subject)
+
+	var statID int32
+	err := tx.QueryRow(ctx, `WITH`+played+`
+		INSERT INTO ParticipantStatistic(`+subject.column+`, wins, loses, winnings, refreshed_at)
+		SELECT $1::int,
+		       (SELECT COUNT(*) FROM played WHERE won),
+		       (SELECT COUNT(*) FROM played WHERE NOT won),
+		       COALESCE((SELECT SUM(pl.prize) FROM TournamentPlacement pl
+		                 JOIN TournamentParticipant tp ON tp.id = pl.participant_id
+		                 WHERE`+condition+`), 0),
+		       NOW()
+		ON CONFLICT (`+subject.column+`) DO UPDATE
+		SET wins = EXCLUDED.wins, loses = EXCLUDED.loses, winnings = EXCLUDED.winnings, refreshed_at = NOW()
+		RETURNING id
+	`, subject.id).Scan(&statID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM ParticipantDisciplineStatistic WHERE statistic_id = $1`, statID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO ParticipantDisciplineStatistic(statistic_id, discipline, tournaments)
+		SELECT $2::int, t.discipline, COUNT(DISTINCT t.id) FROM Tournament t
+		JOIN TournamentParticipant tp ON tp.tournament_id = t.id
+		WHERE`+condition+`
+		AND tp.state = 'Accepted'
+		GROUP BY t.discipline
+	`, subject.id, statID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM ParticipantActivityStatistic WHERE statistic_id = $1`, statID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `WITH`+played+`
+		INSERT INTO ParticipantActivityStatistic(statistic_id, month, personal, teams)
+		SELECT $2::int, date_trunc('month', "date")::date,
+		       COUNT(*) FILTER (WHERE NOT is_team), COUNT(*) FILTER (WHERE is_team)
+		FROM played
+		WHERE "date" IS NOT NULL
+		GROUP BY 2
+	`, subject.id, statID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Rebuild recomputes statistics of every player and team and reports subjects
+// whose stored statistics differed from the recomputed ones.
+func (s *StatisticsService) Rebuild() ([]string, error) {
+	ctx := context.Background()
+	drifted := []string{}
+
+	subjects, err := allSubjects(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, subject := range subjects {
+		before, stored, err := s.snapshot(ctx, subject)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.refresh(ctx, subject); err != nil {
+			return nil, err
+		}
+		after, _, err := s.snapshot(ctx, subject)
+		if err != nil {
+			return nil, err
+		}
+
+		if stored && !before.equal(after) {
+			drifted = append(drifted, fmt.Sprintf("%s %d", subject.kind, subject.id))
+			log.Printf("statistics of %s %d drifted: %+v -> %+v", subject.kind, subject.id, before, after)
+		}
+	}
+
+	return drifted, nil
+}
+
+// snapshot loads the whole stored statistics of the subject, false if none are stored.
+func (s *StatisticsService) snapshot(ctx context.Context, subject profileSubject) (statisticsSnapshot, bool, error) {
+	snap := statisticsSnapshot{disciplines: map[string]int{}, activity: map[string][2]int{}}
+
+	var statID int32
+	err := s.db.QueryRow(ctx, `
+		SELECT id, wins, loses, winnings FROM ParticipantStatistic
+		WHERE `+subject.column+` = $1
+	`, subject.id).Scan(&statID, &snap.wins, &snap.loses, &snap.winnings)
+	if err == pgx.ErrNoRows {
+		return snap, false, nil
+	} else if err != nil {
+		return snap, false, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT discipline, tournaments FROM ParticipantDisciplineStatistic WHERE statistic_id = $1
+	`, statID)
+	if err != nil {
+		return snap, true, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var discipline string
+		var tournaments int
+		if err := rows.Scan(&discipline, &tournaments); err != nil {
+			return snap, true, err
+		}
+		snap.disciplines[discipline] = tournaments
+	}
+	if err := rows.Err(); err != nil {
+		return snap, true, err
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT to_char(month, 'YYYY-MM'), personal, teams FROM ParticipantActivityStatistic WHERE statistic_id = $1
+	`, statID)
+	if err != nil {
+		return snap, true, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var month string
+		var counts [2]int
+		if err := rows.Scan(&month, &counts[0], &counts[1]); err != nil {
+			return snap, true, err
+		}
+		snap.activity[month] = counts
+	}
+
+	return snap, true, rows.Err()
+}
+
+func (a statisticsSnapshot) equal(b statisticsSnapshot) bool {
+	return a.wins == b.wins && a.loses == b.loses && a.winnings == b.winnings &&
+		maps.Equal(a.disciplines, b.disciplines) && maps.Equal(a.activity, b.activity)
+}
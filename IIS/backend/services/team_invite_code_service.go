@@ -0,0 +1,177 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/mail"
+	"backend/models"
+	"context"
+	"crypto/rand"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TeamInviteCodeService manages codes which managers share e.g. in a chat,
+// anyone registered holding a valid code joins the team without an invite.
+type TeamInviteCodeService struct {
+	db *pgxpool.Pool
+}
+
+func NewTeamInviteCodeService(db *pgxpool.Pool) *TeamInviteCodeService {
+	return &TeamInviteCodeService{db}
+}
+
+func (s *TeamInviteCodeService) CreateCode(ctx context.Context, teamID, userID int32, req models.CreateTeamInviteCodeRequest) (*models.TeamInviteCode, error) {
+	code, err := generateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+
+	out := &models.TeamInviteCode{}
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO TeamInviteCode(team_id, code, created_by, max_uses, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, team_id, code, max_uses, uses, expires_at, created_at
+	`, teamID, code, userID, req.MaxUses, expiresAt).Scan(
+		&out.ID,
+		&out.TeamID,
+		&out.Code,
+		&out.MaxUses,
+		&out.Uses,
+		&out.ExpiresAt,
+		&out.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	out.Link = inviteCodeLink(out.Code)
+
+	return out, nil
+}
+
+// GetCodes returns codes of the team which can still be used
+func (s *TeamInviteCodeService) GetCodes(ctx context.Context, teamID int32) ([]models.TeamInviteCode, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, team_id, code, max_uses, uses, expires_at, created_at FROM TeamInviteCode
+		WHERE team_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		AND (max_uses IS NULL OR uses < max_uses)
+		ORDER BY created_at DESC
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []models.TeamInviteCode{}
+	for rows.Next() {
+		var code models.TeamInviteCode
+		if err := rows.Scan(&code.ID, &code.TeamID, &code.Code, &code.MaxUses, &code.Uses, &code.ExpiresAt, &code.CreatedAt); err != nil {
+			return nil, err
+		}
+		code.Link = inviteCodeLink(code.Code)
+		codes = append(codes, code)
+	}
+
+	return codes, rows.Err()
+}
+
+func (s *TeamInviteCodeService) RevokeCode(ctx context.Context, teamID, codeID int32) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE TeamInviteCode SET revoked_at = NOW()
+		WHERE id = $1 AND team_id = $2 AND revoked_at IS NULL
+	`, codeID, teamID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.DBNotFound
+	}
+
+	return nil
+}
+
+// Redeem makes the user an active player of the code's team. A pending invite
+// or join request of the user is accepted instead of adding another player.
+func (s *TeamInviteCodeService) Redeem(ctx context.Context, userID int32, code string) (int32, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the code keeps concurrent redemptions within max_uses
+	var codeID, teamID, managerID int32
+	err = tx.QueryRow(ctx, `
+		SELECT c.id, c.team_id, t.manager_id FROM TeamInviteCode c
+		JOIN Team t ON t.id = c.team_id
+		WHERE c.code = $1 AND c.revoked_at IS NULL AND c.expires_at > NOW()
+		AND (c.max_uses IS NULL OR c.uses < c.max_uses)
+		FOR UPDATE OF c
+	`, code).Scan(&codeID, &teamID, &managerID)
+	if err == pgx.ErrNoRows {
+		return 0, errors.DBNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if managerID == userID {
+		return teamID, errors.NotAcceptable
+	}
+
+	var state string
+	err = tx.QueryRow(ctx, `
+		SELECT state FROM TeamPlayer
+		WHERE user_id = $1 AND team_id = $2 AND until IS NULL
+		ORDER BY state = 'Active' DESC
+		LIMIT 1
+	`, userID, teamID).Scan(&state)
+	switch {
+	case err == pgx.ErrNoRows:
+		_, err = tx.Exec(ctx, `
+			INSERT INTO TeamPlayer(user_id, team_id, since, state) VALUES ($1, $2, CURRENT_DATE, 'Active')
+		`, userID, teamID)
+	case err != nil:
+	case state == "Active":
+		return teamID, errors.AlreadyExists
+	default:
+		_, err = tx.Exec(ctx, `
+			UPDATE TeamPlayer SET since = CURRENT_DATE, state = 'Active'
+			WHERE user_id = $1 AND team_id = $2 AND state IN ('Invited', 'Requested')
+		`, userID, teamID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE TeamInviteCode SET uses = uses + 1 WHERE id = $1`, codeID); err != nil {
+		return 0, err
+	}
+
+	return teamID, tx.Commit(ctx)
+}
+
+func inviteCodeLink(code string) string {
+	return mail.Page("/teams/join?code=" + url.QueryEscape(code))
+}
+
+// generateInviteCode returns code like "q7mk3xw9p2ta", short enough to be typed
+func generateInviteCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz023456789"
+
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i := range buf {
+		buf[i] = alphabet[buf[i]&31]
+	}
+
+	return string(buf), nil
+}
@@ -0,0 +1,236 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/models"
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TeamOwnershipService covers the end of a manager's time with the team,
+// either handing the team to a player or disbanding it.
+type TeamOwnershipService struct {
+	db *pgxpool.Pool
+}
+
+func NewTeamOwnershipService(db *pgxpool.Pool) *TeamOwnershipService {
+	return &TeamOwnershipService{db}
+}
+
+// Transfer offers the team to its active player, replacing an earlier offer
+func (s *TeamOwnershipService) Transfer(ctx context.Context, teamID, userID int32) (*models.TeamOwnershipTransfer, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var managerID int32
+	var player bool
+	err = tx.QueryRow(ctx, `
+		SELECT t.manager_id, EXISTS (
+			SELECT 1 FROM TeamPlayer WHERE team_id = t.id AND user_id = $2 AND state = 'Active'
+		)
+		FROM Team t WHERE t.id = $1 AND t.archived_at IS NULL
+	`, teamID, userID).Scan(&managerID, &player)
+	if err == pgx.ErrNoRows {
+		return nil, errors.DBNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if managerID == userID || !player {
+		return nil, errors.NotAcceptable
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE TeamOwnershipTransfer SET state = 'Cancelled', resolved_at = NOW()
+		WHERE team_id = $1 AND state = 'Pending'
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := &models.TeamOwnershipTransfer{}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO TeamOwnershipTransfer(team_id, from_user_id, to_user_id) VALUES ($1, $2, $3)
+		RETURNING id, team_id, from_user_id, to_user_id, state, created_at
+	`, teamID, managerID, userID).Scan(
+		&transfer.ID,
+		&transfer.TeamID,
+		&transfer.FromUserID,
+		&transfer.ToUserID,
+		&transfer.State,
+		&transfer.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, tx.Commit(ctx)
+}
+
+func (s *TeamOwnershipService) CancelTransfer(ctx context.Context, teamID int32) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE TeamOwnershipTransfer SET state = 'Cancelled', resolved_at = NOW()
+		WHERE team_id = $1 AND state = 'Pending'
+	`, teamID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.DBNotFound
+	}
+
+	return nil
+}
+
+// GetPendingTransfers returns teams offered to the user
+func (s *TeamOwnershipService) GetPendingTransfers(ctx context.Context, userID int32) ([]models.TeamOwnershipTransfer, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT o.id, o.team_id, t.name, o.from_user_id, u.name, u.surname, o.to_user_id, o.state, o.created_at
+		FROM TeamOwnershipTransfer o
+		JOIN Team t ON t.id = o.team_id
+		JOIN "User" u ON u.id = o.from_user_id
+		WHERE o.to_user_id = $1 AND o.state = 'Pending'
+		ORDER BY o.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []models.TeamOwnershipTransfer{}
+	for rows.Next() {
+		var transfer models.TeamOwnershipTransfer
+		if err := rows.Scan(&transfer.ID, &transfer.TeamID, &transfer.TeamName, &transfer.FromUserID, &transfer.FromName,
+			&transfer.FromSurname, &transfer.ToUserID, &transfer.State, &transfer.CreatedAt); err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, rows.Err()
+}
+
+// ResolveTransfer answers the offer made to the user. On acceptance the user becomes
+// the manager, provided nothing changed since the offer, and loses team roles as owner.
+func (s *TeamOwnershipService) ResolveTransfer(ctx context.Context, teamID, userID int32, accept bool) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var transferID, fromUserID int32
+	err = tx.QueryRow(ctx, `
+		SELECT id, from_user_id FROM TeamOwnershipTransfer
+		WHERE team_id = $1 AND to_user_id = $2 AND state = 'Pending'
+		FOR UPDATE
+	`, teamID, userID).Scan(&transferID, &fromUserID)
+	if err == pgx.ErrNoRows {
+		return errors.DBNotFound
+	} else if err != nil {
+		return err
+	}
+
+	state := "Rejected"
+	if accept {
+		state = "Accepted"
+
+		tag, err := tx.Exec(ctx, `
+			UPDATE Team SET manager_id = $2
+			WHERE id = $1 AND manager_id = $3 AND archived_at IS NULL AND EXISTS (
+				SELECT 1 FROM TeamPlayer WHERE team_id = $1 AND user_id = $2 AND state = 'Active'
+			)
+		`, teamID, userID, fromUserID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errors.NotAcceptable
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM RoleAssignment WHERE team_id = $1 AND user_id = $2`, teamID, userID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE TeamOwnershipTransfer SET state = $2, resolved_at = NOW() WHERE id = $1
+	`, transferID, state)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Disband archives the team. Players leave it today and it is withdrawn from tournaments
+// which have not started, results of played tournaments keep referring to it.
+// A team playing a running tournament cannot be disbanded.
+func (s *TeamOwnershipService) Disband(ctx context.Context, teamID int32) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var archived bool
+	err = tx.QueryRow(ctx, `
+		SELECT archived_at IS NOT NULL FROM Team WHERE id = $1 FOR UPDATE
+	`, teamID).Scan(&archived)
+	if err == pgx.ErrNoRows {
+		return errors.DBNotFound
+	} else if err != nil {
+		return err
+	}
+	if archived {
+		return errors.AlreadyExists
+	}
+
+	var playing bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM TournamentParticipant tp
+			JOIN Tournament t ON t.id = tp.tournament_id
+			WHERE tp.team_id = $1 AND tp.state = 'Accepted' AND t.finished_at IS NULL
+			AND EXISTS (SELECT 1 FROM Stage s WHERE s.tournament_id = t.id)
+		)
+	`, teamID).Scan(&playing)
+	if err != nil {
+		return err
+	}
+	if playing {
+		return errors.NotAcceptable
+	}
+
+	statements := []string{
+		`DELETE FROM TournamentParticipant tp
+		WHERE tp.team_id = $1 AND NOT EXISTS (SELECT 1 FROM Stage s WHERE s.tournament_id = tp.tournament_id)`,
+		`DELETE FROM TeamPlayer WHERE team_id = $1 AND state IN ('Invited', 'Requested')`,
+		`DELETE FROM PendingTeamInvite WHERE team_id = $1`,
+		`UPDATE TeamPlayer SET until = CURRENT_DATE, state = 'Inactive' WHERE team_id = $1 AND state = 'Active'`,
+		`UPDATE TeamInviteCode SET revoked_at = NOW() WHERE team_id = $1 AND revoked_at IS NULL`,
+		`UPDATE TeamOwnershipTransfer SET state = 'Cancelled', resolved_at = NOW() WHERE team_id = $1 AND state = 'Pending'`,
+		`DELETE FROM RoleAssignment WHERE team_id = $1`,
+		`UPDATE Team SET archived_at = NOW() WHERE id = $1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, teamID); err != nil {
+			return err
+		}
+	}
+
+	// Withdrawn participations no longer count to the disciplines of the team
+	if err := refreshStatistics(ctx, tx, teamSubject(teamID)); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
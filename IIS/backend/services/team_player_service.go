@@ -28,7 +28,7 @@ func (s *TeamPlayerService) AcceptInvite(userID, teamID int32) error {
 	_, err := s.db.Exec(ctx, `
 		UPDATE TeamPlayer
 		SET since = CURRENT_DATE, state = 'Active'
-		WHERE user_id = $1 AND team_id = $2 AND since IS NULL
+		WHERE user_id = $1 AND team_id = $2 AND state = 'Invited'
 	`, userID, teamID)
 	return err
 }
@@ -37,7 +37,7 @@ func (s *TeamPlayerService) RejectInvite(userID, teamID int32) error {
 	ctx := context.Background()
 	_, err := s.db.Exec(ctx, `
 		DELETE FROM TeamPlayer
-		WHERE user_id = $1 AND team_id = $2 AND since IS NULL
+		WHERE user_id = $1 AND team_id = $2 AND state = 'Invited'
 	`, userID, teamID)
 	return err
 }
@@ -50,19 +50,20 @@ func (s *TeamPlayerService) Delete(playerID int32) error {
 	}
 	defer tx.Rollback(ctx)
 
-	var count int
+	// Players remaining after the removal, starters alone count when the tournament limits substitutes
+	var count, starters int
 	err = tx.QueryRow(ctx, `
 	WITH init AS (
-		SELECT team_id AS id FROM TeamPlayer WHERE id = $1
+		SELECT team_id AS id, squad FROM TeamPlayer WHERE id = $1
 	)
-	SELECT COUNT(*) FROM TeamPlayer tp
+	SELECT COUNT(*) - 1, COUNT(*) FILTER (WHERE tp.squad = 'Starter') - (CASE WHEN MIN(i.squad) = 'Starter' THEN 1 ELSE 0 END)
+	FROM TeamPlayer tp
 	JOIN init i ON i.id = tp.team_id
 	WHERE tp.since IS NOT NULL AND tp.until IS NULL
-	`, playerID).Scan(&count)
+	`, playerID).Scan(&count, &starters)
 	if err != nil {
 		return err
 	}
-	count = count - 1
 
 	_, err = tx.Exec(ctx, `
 		UPDATE TeamPlayer
@@ -73,8 +74,36 @@ func (s *TeamPlayerService) Delete(playerID int32) error {
 		return err
 	}
 
+	// Players of locked lineups stay until the organizer approves their substitution
+	var locked bool
+	err = tx.QueryRow(ctx, `
+	SELECT EXISTS (
+		SELECT 1 FROM TeamPlayer teamp
+		JOIN TournamentParticipant tp ON tp.team_id = teamp.team_id
+		JOIN TournamentLineup l ON l.participant_id = tp.id AND l.user_id = teamp.user_id
+		JOIN Tournament t ON t.id = tp.tournament_id
+		WHERE teamp.id = $1 AND t.finished_at IS NULL AND `+lineupLocked+`
+	)
+	`, playerID).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return errori.LineupLocked
+	}
+
+	_, err = tx.Exec(ctx, `
+	DELETE FROM TournamentLineup l
+	USING TeamPlayer teamp, TournamentParticipant tp, Tournament t
+	WHERE teamp.id = $1 AND tp.team_id = teamp.team_id AND l.participant_id = tp.id AND l.user_id = teamp.user_id
+	AND t.id = tp.tournament_id AND NOT `+lineupLocked+`
+	`, playerID)
+	if err != nil {
+		return err
+	}
+
 	tourrows, err := tx.Query(ctx, `
-	SELECT tour.id, tour.min_team_limit FROM TeamPlayer tp
+	SELECT tour.id, tour.min_team_limit, tour.max_substitutes FROM TeamPlayer tp
 	JOIN Team t ON t.id=tp.team_id
 	JOIN TournamentParticipant tourp ON tourp.team_id = t.id
 	JOIN Tournament tour ON tourp.tournament_id = tour.id
@@ -88,14 +117,18 @@ func (s *TeamPlayerService) Delete(playerID int32) error {
 	var limits []models.TournamentMinLimit
 	for tourrows.Next() {
 		var limit models.TournamentMinLimit
-		if err := tourrows.Scan(&limit.ID, &limit.MinLimit); err != nil {
+		if err := tourrows.Scan(&limit.ID, &limit.MinLimit, &limit.MaxSubstitutes); err != nil {
 			return err
 		}
 		limits = append(limits, limit)
 	}
 
 	for _, l := range limits {
-		if l.MinLimit.Valid && count < int(l.MinLimit.Int32) {
+		remaining := count
+		if l.MaxSubstitutes.Valid {
+			remaining = starters
+		}
+		if l.MinLimit.Valid && remaining < int(l.MinLimit.Int32) {
 			var not_started bool
 			if err := tx.QueryRow(ctx, `SELECT NOT EXISTS(
 				SELECT * FROM Stage WHERE tournament_id = $1
@@ -104,12 +137,17 @@ func (s *TeamPlayerService) Delete(playerID int32) error {
 				return err
 			}
 			if not_started {
-				_, err := tx.Exec(ctx, `
+				var teamID int32
+				err := tx.QueryRow(ctx, `
 				DELETE FROM TournamentParticipant tp
 				WHERE tp.tournament_id = $1 AND tp.state = 'Accepted'
-				AND tp.team_id = (SELECT teamp.team_id FROM TeamPlayer teamp WHERE teamp.id = $2)`,
-					l.ID, playerID)
-				if err != nil {
+				AND tp.team_id = (SELECT teamp.team_id FROM TeamPlayer teamp WHERE teamp.id = $2)
+				RETURNING tp.team_id`,
+					l.ID, playerID).Scan(&teamID)
+				if err == nil {
+					err = refreshStatistics(ctx, tx, teamSubject(teamID))
+				}
+				if err != nil && err != pgx.ErrNoRows {
 					return err
 				}
 			} else {
@@ -151,7 +189,7 @@ func (s *TeamPlayerService) PlayersFromTeam(teamID int32) ([]models.TeamUserPlay
 	var players []models.TeamUserPlayer
 
 	playerRows, err := s.db.Query(ctx, `
-		SELECT tp.id, u.id, u.name, u.surname, tp.state
+		SELECT tp.id, u.id, u.name, u.surname, tp.state, tp.squad
 		FROM TeamPlayer tp
 		JOIN "User" u ON u.id = tp.user_id
 		WHERE tp.team_id = $1
@@ -170,6 +208,7 @@ func (s *TeamPlayerService) PlayersFromTeam(teamID int32) ([]models.TeamUserPlay
 			&player.Name,
 			&player.Surname,
 			&player.State,
+			&player.Squad,
 		)
 		if err != nil {
 			return players, err
@@ -184,7 +223,7 @@ func (s *TeamPlayerService) GetPlayer(playerID int32) (models.TeamPlayer, error)
 	ctx := context.Background()
 	var player models.TeamPlayer
 	row := s.db.QueryRow(ctx, `
-		SELECT team_id, since, until, state FROM TeamPlayer
+		SELECT team_id, since, until, state, squad FROM TeamPlayer
 		WHERE id = $1
 	`, playerID)
 
@@ -193,6 +232,7 @@ func (s *TeamPlayerService) GetPlayer(playerID int32) (models.TeamPlayer, error)
 		&player.Since,
 		&player.Until,
 		&player.State,
+		&player.Squad,
 	)
 	if err != nil {
 		return player, err
@@ -231,13 +271,25 @@ func (s *TeamPlayerService) GetActivePlayer(userID, teamID int32) (time.Time, mo
 }
 
 func (s *TeamPlayerService) AddInvitedPlayer(userID, teamID int32) (models.TeamPlayer, error) {
-	ctx := context.Background()
+	return addInvitedPlayer(context.Background(), s.db, userID, teamID, "Starter")
+}
+
+// rowQuerier and rowsQuerier are satisfied by the pool as well as by transactions
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type rowsQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func addInvitedPlayer(ctx context.Context, q rowQuerier, userID, teamID int32, squad string) (models.TeamPlayer, error) {
 	var player models.TeamPlayer
 
-	row := s.db.QueryRow(ctx, `
-		INSERT INTO TeamPlayer(user_id, team_id) VALUES($1, $2)
-		RETURNING *
-	`, userID, teamID)
+	row := q.QueryRow(ctx, `
+		INSERT INTO TeamPlayer(user_id, team_id, squad) VALUES($1, $2, $3)
+		RETURNING id, user_id, team_id, since, until, state, squad
+	`, userID, teamID, squad)
 
 	err := row.Scan(
 		&player.ID,
@@ -246,6 +298,7 @@ func (s *TeamPlayerService) AddInvitedPlayer(userID, teamID int32) (models.TeamP
 		&player.Since,
 		&player.Until,
 		&player.State,
+		&player.Squad,
 	)
 	if err != nil {
 		return player, err
@@ -298,3 +351,145 @@ func (s *TeamPlayerService) GetTeamPlayerState(userID, teamID int32) (string, er
 	err := row.Scan(&state)
 	return state, err
 }
+
+// RequestJoin asks the manager to let the user into the team, the request
+// is a player in the Requested state until the manager resolves it.
+func (s *TeamPlayerService) RequestJoin(userID, teamID int32) (models.TeamPlayer, error) {
+	ctx := context.Background()
+	var player models.TeamPlayer
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return player, err
+	}
+	defer tx.Rollback(ctx)
+
+	var pending bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM TeamPlayer WHERE user_id = $1 AND team_id = $2 AND until IS NULL
+		)
+	`, userID, teamID).Scan(&pending)
+	if err != nil {
+		return player, err
+	}
+	if pending {
+		return player, errori.AlreadyExists
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO TeamPlayer(user_id, team_id, state) VALUES($1, $2, 'Requested')
+		RETURNING id, user_id, team_id, since, until, state, squad
+	`, userID, teamID).Scan(
+		&player.ID,
+		&player.UserID,
+		&player.TeamID,
+		&player.Since,
+		&player.Until,
+		&player.State,
+		&player.Squad,
+	)
+	if err != nil {
+		return player, err
+	}
+
+	return player, tx.Commit(ctx)
+}
+
+func (s *TeamPlayerService) CancelJoinRequest(userID, teamID int32) error {
+	ctx := context.Background()
+	tag, err := s.db.Exec(ctx, `
+		DELETE FROM TeamPlayer
+		WHERE user_id = $1 AND team_id = $2 AND state = 'Requested'
+	`, userID, teamID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errori.DBNotFound
+	}
+	return nil
+}
+
+func (s *TeamPlayerService) GetJoinRequests(teamID int32) ([]models.TeamUserPlayer, error) {
+	ctx := context.Background()
+	requests := []models.TeamUserPlayer{}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT tp.id, u.id, u.name, u.surname, tp.state, tp.squad
+		FROM TeamPlayer tp
+		JOIN "User" u ON u.id = tp.user_id
+		WHERE tp.team_id = $1 AND tp.state = 'Requested'
+		ORDER BY tp.id
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var request models.TeamUserPlayer
+		if err := rows.Scan(&request.PlayerID, &request.UserID, &request.Name, &request.Surname, &request.State, &request.Squad); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+
+	return requests, rows.Err()
+}
+
+// FindJoinRequest returns the player ID of the user's pending request to join the team
+func (s *TeamPlayerService) FindJoinRequest(userID, teamID int32) (int32, error) {
+	ctx := context.Background()
+	var playerID int32
+	err := s.db.QueryRow(ctx, `
+		SELECT id FROM TeamPlayer
+		WHERE user_id = $1 AND team_id = $2 AND state = 'Requested'
+	`, userID, teamID).Scan(&playerID)
+	if err == pgx.ErrNoRows {
+		return 0, errori.DBNotFound
+	}
+	return playerID, err
+}
+
+// ResolveJoinRequest makes the requesting user an active player, or removes the request
+func (s *TeamPlayerService) ResolveJoinRequest(teamID, playerID int32, accept bool) error {
+	ctx := context.Background()
+
+	query := `
+		DELETE FROM TeamPlayer
+		WHERE id = $1 AND team_id = $2 AND state = 'Requested'
+	`
+	if accept {
+		query = `
+			UPDATE TeamPlayer
+			SET since = CURRENT_DATE, state = 'Active'
+			WHERE id = $1 AND team_id = $2 AND state = 'Requested'
+		`
+	}
+
+	tag, err := s.db.Exec(ctx, query, playerID, teamID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errori.DBNotFound
+	}
+	return nil
+}
+
+// SetSquad moves the player between starters and substitutes of the team
+func (s *TeamPlayerService) SetSquad(teamID, playerID int32, squad string) error {
+	ctx := context.Background()
+	tag, err := s.db.Exec(ctx, `
+		UPDATE TeamPlayer SET squad = $3
+		WHERE id = $1 AND team_id = $2 AND until IS NULL
+	`, playerID, teamID, squad)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errori.DBNotFound
+	}
+	return nil
+}
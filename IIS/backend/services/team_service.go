@@ -12,7 +12,6 @@ import (
 	"fmt"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -54,12 +53,16 @@ func (s *TeamService) countTeams(searchText, where_and string) (int, error) {
 	return total, err
 }
 
-func (s *TeamService) GetTeams(page, limit, userID int, searchText string) (models.PaginationAnswer[models.Team], error) {
+// GetTeams lists teams, disbanded teams only when archived is set
+func (s *TeamService) GetTeams(page, limit, userID int, searchText string, archived bool) (models.PaginationAnswer[models.Team], error) {
 	var ans models.PaginationAnswer[models.Team]
 	ctx := context.Background()
 	offset := (page - 1) * limit
 
 	where_and := ""
+	if !archived {
+		where_and += " AND t.archived_at IS NULL "
+	}
 	if userID != -1 {
 		where_and += fmt.Sprintf(" AND EXISTS (SELECT tp.id FROM TeamPlayer tp WHERE tp.user_id = %d AND tp.team_id = t.id AND tp.state = 'Active')", userID)
 	}
@@ -72,7 +75,7 @@ func (s *TeamService) GetTeams(page, limit, userID int, searchText string) (mode
 
 	// 2. get data
 	rows, err := s.db.Query(ctx, `
-		SELECT t.id, t.name, t.description, t.manager_id
+		SELECT t.id, t.name, t.description, t.manager_id, t.archived_at
 		FROM Team t
 		WHERE ($3 = '' OR similarity(t.name, $3) > 0.05)`+where_and+
 		`ORDER BY CASE WHEN $3 = '' THEN id ELSE similarity(t.name, $3) END DESC
@@ -90,6 +93,7 @@ func (s *TeamService) GetTeams(page, limit, userID int, searchText string) (mode
 			&team.Name,
 			&team.Description,
 			&team.ManagerID,
+			&team.ArchivedAt,
 		); err != nil {
 			return ans, err
 		}
@@ -115,10 +119,10 @@ func (s *TeamService) GetTeamById(id int) (models.TeamBaseResponse, int32, error
 	var managerID int32
 
 	err := s.db.QueryRow(ctx, `
-		SELECT id, name, description, since, manager_id
+		SELECT id, name, description, since, manager_id, archived_at
 		FROM Team
 		WHERE id = $1;
-	`, id).Scan(&team.ID, &team.Name, &team.Description, &team.Since, &managerID)
+	`, id).Scan(&team.ID, &team.Name, &team.Description, &team.Since, &managerID, &team.ArchivedAt)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return team, -1, errori.DBNotFound
@@ -182,7 +186,7 @@ func (s *TeamService) GetTeamsProfile(id int32, searchText string) ([]models.Tea
 
 	teamsRows, err := s.db.Query(ctx, `
 		SELECT id, name, since FROM Team
-		WHERE manager_id = $1 AND ($2 = '' OR similarity(name, $2) > 0.05)
+		WHERE manager_id = $1 AND archived_at IS NULL AND ($2 = '' OR similarity(name, $2) > 0.05)
 	`, id, searchText)
 
 	if err != nil {
@@ -204,7 +208,7 @@ func (s *TeamService) GetTeamsProfile(id int32, searchText string) ([]models.Tea
 		}
 
 		playersRows, err := s.db.Query(ctx, `
-			SELECT tp.id, u.id, u.name, u.surname, tp.state
+			SELECT tp.id, u.id, u.name, u.surname, tp.state, tp.squad
 			FROM TeamPlayer tp
 			JOIN "User" u ON u.id = tp.user_id
 			WHERE tp.team_id = $1
@@ -225,6 +229,7 @@ func (s *TeamService) GetTeamsProfile(id int32, searchText string) ([]models.Tea
 				&p.Name,
 				&p.Surname,
 				&p.State,
+				&p.Squad,
 			); err != nil {
 				break
 			}
@@ -264,39 +269,31 @@ func (s *TeamService) GetTeamsProfile(id int32, searchText string) ([]models.Tea
 	return teams, nil
 }
 
-func (s *TeamService) GetTeamWinnings(teamID int32) (int32, error) {
+func (s *TeamService) GetTeamResults(teamID int32) ([]models.Placement, error) {
 	ctx := context.Background()
-	var winnings pgtype.Int4
-	err := s.db.QueryRow(ctx, `
-		SELECT SUM(prize) FROM Tournament t
-		WHERE EXISTS (
-			SELECT * FROM Stage s
-			JOIN Match m ON m.stage_id = s.id
-			JOIN TournamentParticipant tp1 ON m.first_participant_id = tp1.id
-			JOIN TournamentParticipant tp2 ON m.second_participant_id = tp2.id
-			WHERE s.tournament_id = t.id AND s.level = (SELECT MAX(level) FROM Stage s2 WHERE s2.tournament_id = t.id)
-			AND (
-				(tp1.team_id = $1 AND m.first_participant_is_winner)
-				OR
-				(tp2.team_id = $1 AND m.second_participant_is_winner)
-		))
-	`, teamID).Scan(&winnings)
+
+	rows, err := s.db.Query(ctx, placementsQuery+`
+		WHERE tp.team_id = $1
+		ORDER BY t.finished_at DESC
+	`, teamID)
 	if err != nil {
-		return 0, err
-	}
-	if winnings.Valid {
-		return winnings.Int32, err
+		return nil, err
 	}
-	return 0, err
+	defer rows.Close()
+
+	return scanPlacements(rows)
 }
 
-func (s *TeamService) CreateTeamWithInvites(name, description string, managerID int32, invites []string) (models.Team, error) {
+// CreateTeamWithInvites creates the team and invites the emails. Emails without an account
+// get pending invites instead, they are returned to be notified with a signup link.
+func (s *TeamService) CreateTeamWithInvites(name, description string, managerID int32, invites []string) (models.Team, []string, error) {
 	ctx := context.Background()
 	var team models.Team
+	pending := []string{}
 
 	transaction, err := s.db.Begin(ctx)
 	if err != nil {
-		return team, err
+		return team, pending, err
 	}
 	defer transaction.Rollback(ctx)
 
@@ -307,19 +304,31 @@ func (s *TeamService) CreateTeamWithInvites(name, description string, managerID
 
 	err = row.Scan(&team.ID, &team.Name, &team.Since, &team.ManagerID)
 	if err != nil {
-		return team, err
+		return team, pending, err
 	}
 
 	for i := range invites {
 		email := invites[i]
 		row = transaction.QueryRow(ctx, `
-		SELECT id FROM "User" WHERE email = $1
+		SELECT id FROM "User" WHERE LOWER(email) = LOWER($1)
 		`, email)
 
 		var userID int32
 		err = row.Scan(&userID)
-		if err != nil {
-			return team, err
+		if err == pgx.ErrNoRows {
+			tag, err := transaction.Exec(ctx, `
+			INSERT INTO PendingTeamInvite(team_id, email, invited_by) VALUES($1, $2, $3)
+			ON CONFLICT DO NOTHING
+			`, team.ID, email, managerID)
+			if err != nil {
+				return team, pending, err
+			}
+			if tag.RowsAffected() > 0 {
+				pending = append(pending, email)
+			}
+			continue
+		} else if err != nil {
+			return team, pending, err
 		}
 
 		_, err = transaction.Exec(ctx, `
@@ -327,14 +336,14 @@ func (s *TeamService) CreateTeamWithInvites(name, description string, managerID
 		RETURNING *
 		`, userID, team.ID)
 		if err != nil {
-			return team, err
+			return team, pending, err
 		}
 	}
 
 	if err = transaction.Commit(ctx); err != nil {
-		return team, err
+		return team, pending, err
 	}
-	return team, nil
+	return team, pending, nil
 }
 
 func (s *TeamService) UpdateTeam(teamID int32, name string, desc string) error {
@@ -349,100 +358,13 @@ func (s *TeamService) UpdateTeam(teamID int32, name string, desc string) error {
 	return err
 }
 
-func (s *TeamService) GetTeamWinrate(id int32) (models.WinrateStatistic, error) {
-	ctx := context.Background()
-	var winrate models.WinrateStatistic
-
-	err := s.db.QueryRow(ctx, `
-	SELECT
-		COUNT(*) FILTER (WHERE
-			(tp1.team_id = $1 AND m.first_participant_is_winner = TRUE) OR
-			(tp2.team_id = $1 AND m.second_participant_is_winner = TRUE)
-		) AS wins,
-		COUNT(*) FILTER (WHERE
-			(tp1.team_id = $1 AND m.first_participant_is_winner = FALSE) OR
-			(tp2.team_id = $1 AND m.second_participant_is_winner = FALSE)
-		) AS loses
-	FROM Match m
-	JOIN TournamentParticipant tp1 ON tp1.id = m.first_participant_id
-	JOIN TournamentParticipant tp2 ON tp2.id = m.second_participant_id
-	WHERE (m.first_participant_is_winner OR m.second_participant_is_winner)
-	`, id).Scan(&winrate.Wins, &winrate.Loses)
-
-	return winrate, err
-}
-
-func (s *TeamService) GetTeamDisciplines(id int32) ([]models.DisciplineStatistic, error) {
-	ctx := context.Background()
-	var disciplines []models.DisciplineStatistic
-
-	rows, err := s.db.Query(ctx, `
-	SELECT t.discipline, COUNT(*) FROM Tournament t
-	JOIN TournamentParticipant tp ON tp.tournament_id = t.id
-	WHERE tp.state = 'Accepted' AND tp.team_id = $1
-	GROUP BY t.discipline
-	`, id)
-
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var discipline models.DisciplineStatistic
-		if err = rows.Scan(
-			&discipline.Name,
-			&discipline.Tournaments,
-		); err != nil {
-			return nil, err
-		}
-		disciplines = append(disciplines, discipline)
-	}
-
-	return disciplines, nil
-}
-
-func (s *TeamService) GetTeamActivity(id int32) (map[string]models.ActivityStatistic, error) {
-	ctx := context.Background()
-	matchmap := map[string]models.ActivityStatistic{}
-
-	rows, err := s.db.Query(ctx, `
-		SELECT COUNT(*) AS match_count, date_trunc('month', m."date") as match_month FROM Match m
-		JOIN TournamentParticipant tp1 ON m.first_participant_id = tp1.id
-		JOIN TournamentParticipant tp2 ON m.second_participant_id = tp2.id
-		WHERE (tp1.team_id = $1 OR tp2.team_id = $1) AND m."date" >= date_trunc('month', CURRENT_DATE) - INTERVAL '3 month'
-		AND (m.first_participant_is_winner OR m.second_participant_is_winner)
-		GROUP BY match_month
-	`, id)
-
-	if err != nil {
-		return matchmap, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var m_count int
-		var m_time pgtype.Date
-		if err := rows.Scan(&m_count, &m_time); err != nil {
-			return matchmap, err
-		}
-		monthstat := models.ActivityStatistic{Month: m_time.Time.Month().String(), Personal: 0, Teams: m_count}
-		matchmap[m_time.Time.Month().String()] = monthstat
-	}
-
-	return matchmap, err
-}
-
-func (s *TeamService) CountActiveTeamPlayers(teamID int32) int32 {
+func (s *TeamService) CountActiveSquad(teamID int32) (models.TeamSquadCount, error) {
 	ctx := context.Background()
-	var count int32
+	var count models.TeamSquadCount
 	err := s.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM Team t
-		JOIN TeamPlayer tp ON t.id = tp.team_id
-		WHERE t.id = $1 AND tp.since IS NOT NULL AND tp.until IS NULL
-	`, teamID).Scan(&count)
-	if err != nil {
-		return -1
-	}
-	return count
+		SELECT COUNT(*) FILTER (WHERE tp.squad = 'Starter'), COUNT(*) FILTER (WHERE tp.squad = 'Substitute')
+		FROM TeamPlayer tp
+		WHERE tp.team_id = $1 AND tp.since IS NOT NULL AND tp.until IS NULL
+	`, teamID).Scan(&count.Starters, &count.Substitutes)
+	return count, err
 }
@@ -143,166 +143,38 @@ func (s *TournamentParticipantService) GetPlayerById(id int) (models.PlayerDetai
 	return player, nil
 }
 
-func (s *TournamentParticipantService) GetPlayerWinrate(id int32) (models.WinrateStatistic, error) {
+// playedMatches lists decided matches the user $1 took part in, either in person
+// or as a member of the team lineup recorded for the match.
+const playedMatches = `
+	played AS (
+		SELECT m.id, m."date", tp.tournament_id, tp.team_id IS NOT NULL AS is_team,
+		       CASE WHEN tp.id = m.first_participant_id THEN m.first_participant_is_winner
+		            ELSE m.second_participant_is_winner END AS won
+		FROM Match m
+		JOIN TournamentParticipant tp ON tp.id = m.first_participant_id OR tp.id = m.second_participant_id
+		WHERE (m.first_participant_is_winner OR m.second_participant_is_winner)
+		AND (tp.player_id = $1 OR EXISTS (SELECT * FROM MatchLineup ml
+			WHERE ml.match_id = m.id AND ml.participant_id = tp.id AND ml.user_id = $1))
+	)`
+
+// playedFor matches placements and participations of user $1, counting team
+// participations only when the user was in one of the team's match lineups.
+const playedFor = `
+	(tp.player_id = $1 OR EXISTS (SELECT * FROM MatchLineup ml WHERE ml.participant_id = tp.id AND ml.user_id = $1))`
+
+func (s *TournamentParticipantService) GetPlayerResults(userID int32) ([]models.Placement, error) {
 	ctx := context.Background()
-	var winrate models.WinrateStatistic
 
-	query := `
-	WITH played_teams AS (
-		SELECT tp.team_id, tp.since, tp.until FROM TeamPlayer tp
-		WHERE tp.user_id = $1
-	)
-	SELECT COUNT(*) FROM Match m
-	JOIN TournamentParticipant tp1 ON m.first_participant_id = tp1.id
-	JOIN TournamentParticipant tp2 ON m.second_participant_id = tp2.id
-	LEFT JOIN "User" u ON u.id = $1 AND (
-		(u.id = tp1.player_id AND m.first_participant_is_winner = $2)
-		OR (u.id = tp2.player_id AND m.second_participant_is_winner = $2))
-	LEFT JOIN Team t
-		ON (t.id = tp1.team_id AND t.id IN (SELECT team_id FROM played_teams) AND m.first_participant_is_winner = $2)
-		OR (t.id = tp2.team_id AND t.id IN (SELECT team_id FROM played_teams) AND m.second_participant_is_winner = $2)
-	WHERE (u.id IS NOT NULL or t.id IS NOT NULL)
-	AND (m.first_participant_is_winner OR m.second_participant_is_winner)
-	`
-
-	err := s.db.QueryRow(ctx, query, id, true).Scan(&winrate.Wins)
-	if err != nil {
-		return winrate, err
-	}
-
-	err = s.db.QueryRow(ctx, query, id, false).Scan(&winrate.Loses)
+	rows, err := s.db.Query(ctx, placementsQuery+`
+		WHERE`+playedFor+`
+		ORDER BY t.finished_at DESC
+	`, userID)
 	if err != nil {
-		return winrate, err
-	}
-
-	return winrate, nil
-}
-
-func (s *TournamentParticipantService) GetPlayerDisciplines(id int32) ([]models.DisciplineStatistic, error) {
-	ctx := context.Background()
-	var disciplines []models.DisciplineStatistic
-
-	rows, err := s.db.Query(ctx, `
-	WITH played_teams AS (
-		SELECT tp.team_id, tp.since, tp.until FROM TeamPlayer tp
-		WHERE tp.user_id = $1
-	)
-	SELECT t.discipline, COUNT(*) FROM Tournament t
-	JOIN TournamentParticipant tp ON tp.tournament_id = t.id
-	WHERE (tp.player_id = $1 OR EXISTS (SELECT team_id FROM played_teams p WHERE p.team_id = tp.team_id))
-	AND tp.state = 'Accepted'
-	GROUP BY t.discipline
-	`, id)
-
-	if err == pgx.ErrNoRows {
-		rows.Close()
-		return []models.DisciplineStatistic{}, nil
-	} else if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		var discipline models.DisciplineStatistic
-		if err = rows.Scan(
-			&discipline.Name,
-			&discipline.Tournaments,
-		); err != nil {
-			return nil, err
-		}
-		disciplines = append(disciplines, discipline)
-	}
-
-	return disciplines, nil
-}
-
-func (s *TournamentParticipantService) GetPlayerActivity(id int32) (map[string]models.ActivityStatistic, error) {
-	ctx := context.Background()
-	matchmap := map[string]models.ActivityStatistic{}
-
-	prows, err := s.db.Query(ctx, `
-		SELECT COUNT(*) AS match_count, date_trunc('month', m."date") as match_month FROM Match m
-		JOIN TournamentParticipant tp ON m.first_participant_id = tp.id OR m.second_participant_id = tp.id
-		WHERE tp.player_id = $1 AND m."date" >= date_trunc('month', CURRENT_DATE) - INTERVAL '3 month'
-		AND (m.first_participant_is_winner OR m.second_participant_is_winner)
-		GROUP BY match_month
-	`, id)
-
-	if err != nil {
-		return matchmap, err
-	}
-
-	for prows.Next() {
-		var m_count int
-		var m_time pgtype.Date
-		if err := prows.Scan(&m_count, &m_time); err != nil {
-			return matchmap, err
-		}
-		monthstat := models.ActivityStatistic{Month: m_time.Time.Month().String(), Personal: m_count, Teams: 0}
-		matchmap[m_time.Time.Month().String()] = monthstat
-	}
-	defer prows.Close()
-
-	trows, err := s.db.Query(ctx, `
-		WITH played_teams AS (
-			SELECT tp.team_id, tp.since, tp.until FROM TeamPlayer tp
-			WHERE tp.user_id = $1
-		)
-		SELECT COUNT(*) AS match_count, date_trunc('month', m."date") as match_month FROM Match m
-		JOIN TournamentParticipant tp ON m.first_participant_id = tp.id OR m.second_participant_id = tp.id
-		WHERE EXISTS (SELECT pt.team_id FROM played_teams pt WHERE pt.team_id = tp.team_id)
-		AND m."date" >= date_trunc('month', CURRENT_DATE) - INTERVAL '3 month'
-		AND (m.first_participant_is_winner OR m.second_participant_is_winner)
-		GROUP BY match_month
-	`, id)
-
-	if err != nil {
-		return matchmap, err
-	}
-	defer trows.Close()
-
-	for trows.Next() {
-		var m_count int
-		var m_time pgtype.Date
-		if err := trows.Scan(&m_count, &m_time); err != nil {
-			return matchmap, err
-		}
-		if monthstat, ok := matchmap[m_time.Time.Month().String()]; ok {
-			monthstat.Teams = m_count
-			matchmap[m_time.Time.Month().String()] = monthstat
-		} else {
-			monthstat := models.ActivityStatistic{Month: m_time.Time.Month().String(), Personal: 0, Teams: m_count}
-			matchmap[m_time.Time.Month().String()] = monthstat
-		}
-	}
-
-	return matchmap, nil
-}
-
-func (s *TournamentParticipantService) GetPlayerWinnings(userID int32) (int32, error) {
-	ctx := context.Background()
-	var winnings pgtype.Int4
-	err := s.db.QueryRow(ctx, `
-		SELECT SUM(prize) FROM Tournament t
-		WHERE EXISTS (
-			SELECT * FROM Stage s
-			JOIN Match m ON m.stage_id = s.id
-			JOIN TournamentParticipant tp1 ON m.first_participant_id = tp1.id
-			JOIN TournamentParticipant tp2 ON m.second_participant_id = tp2.id
-			WHERE s.tournament_id = t.id AND s.level = (SELECT MAX(level) FROM Stage s2 WHERE s2.tournament_id = t.id)
-			AND (
-				(tp1.player_id = $1 AND m.first_participant_is_winner)
-				OR 
-				(tp2.player_id = $1 AND m.second_participant_is_winner)
-		))
-	`, userID).Scan(&winnings)
-	if err != nil {
-		return 0, err
-	}
-	if winnings.Valid {
-		return winnings.Int32, err
-	}
-	return 0, err
+	return scanPlacements(rows)
 }
 
 func (s *TournamentParticipantService) PlayerPaticipatesTournament(tournamentID, playerdID int32) (int32, error) {
@@ -324,10 +196,10 @@ func (s *TournamentParticipantService) TeamOrPlayerPaticipatesTournament(tournam
 	err := s.db.QueryRow(ctx, `
 	SELECT tp.id FROM TournamentParticipant tp
 	WHERE tp.tournament_id = $1 AND (tp.team_id = $2
-  	OR EXISTS (SELECT * FROM TeamPlayer teamp
-			WHERE teamp.team_id = tp.team_id AND teamp.until IS NULL AND tp.state = 'Accepted'
-      AND teamp.user_id IN ( SELECT itemp.user_id FROM TeamPlayer itemp
-        WHERE itemp.team_id = $2 AND itemp.until IS NULL
+  	OR EXISTS (SELECT * FROM TournamentLineup l
+			WHERE l.participant_id = tp.id AND tp.state = 'Accepted'
+      AND l.user_id IN ( SELECT itemp.user_id FROM TeamPlayer itemp
+        WHERE itemp.team_id = $2 AND itemp.state = 'Active' AND itemp.until IS NULL
       )
 		)
 	)
@@ -369,7 +241,13 @@ func (s *TournamentParticipantService) CreateTournamentParticipant(
 		return participant, fmt.Errorf("unknown tournament type: %s", tournamentType)
 	}
 
-	if err := s.db.QueryRow(ctx, `
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return participant, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.QueryRow(ctx, `
 		INSERT INTO TournamentParticipant(team_id, player_id, tournament_id)
 		VALUES ($1, $2, $3)
 		RETURNING id
@@ -377,6 +255,17 @@ func (s *TournamentParticipantService) CreateTournamentParticipant(
 		return participant, err
 	}
 
+	// The team registers its current roster, the lineup can be changed until it locks
+	if req.TeamID.Valid {
+		if err := snapshotTournamentLineup(ctx, tx, participant.ID); err != nil {
+			return participant, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return participant, err
+	}
+
 	participant.PlayerID = req.PlayerID
 	participant.TeamID = req.TeamID
 	participant.State = "Pending"
@@ -406,15 +295,19 @@ func (s *TournamentParticipantService) ResolveTournamentParticipant(id int32, ne
 	}
 
 	if newState == "Accepted" && teamID.Valid {
+		if err = snapshotTournamentLineup(ctx, tx, id); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+
 		_, err = tx.Exec(ctx, `
 			UPDATE TournamentParticipant tp
 			SET state = 'Rejected'
-			WHERE tp.id <> $3 AND tp.tournament_id = $1 AND EXISTS (SELECT * FROM TeamPlayer teamp
-				WHERE teamp.team_id = tp.team_id AND teamp.until IS NULL
-      	AND teamp.user_id IN (SELECT itemp.user_id FROM TeamPlayer itemp
-        	WHERE itemp.team_id = $2 AND itemp.until IS NULL)
+			WHERE tp.id <> $2 AND tp.tournament_id = $1 AND EXISTS (SELECT * FROM TournamentLineup l
+				WHERE l.participant_id = tp.id
+      	AND l.user_id IN (SELECT il.user_id FROM TournamentLineup il WHERE il.participant_id = $2)
 			)
-		`, tourID, teamID.Int32, id)
+		`, tourID, id)
 		if err != nil {
 			tx.Rollback(ctx)
 			return err
@@ -108,7 +108,7 @@ func (s *TournamentService) GetTournamentById(tID int32) (*models.TournamentDeta
 
 	row := s.db.QueryRow(ctx, `
 		SELECT t.id, t.name, t.discipline, t.expected_members, t.type, t.prize, t.min_team_limit, t.max_team_limit,
-		       u.id, u.name, u.surname, t.state
+		       t.max_substitutes, t.starts_at, t.lineup_lock_hours, t.payout, u.id, u.name, u.surname, t.state
 		FROM Tournament t
 		JOIN "User" u ON u.id = t.manager_id
 		WHERE t.id = $1
@@ -128,6 +128,10 @@ func (s *TournamentService) GetTournamentById(tID int32) (*models.TournamentDeta
 		&prize,
 		&min_limit,
 		&max_limit,
+		&dto.MaxSubs,
+		&dto.StartsAt,
+		&dto.LockHours,
+		&dto.Payout,
 		&dto.Manager.ID,
 		&dto.Manager.Name,
 		&dto.Manager.Surname,
@@ -189,6 +193,11 @@ func (s *TournamentService) GetTournamentById(tID int32) (*models.TournamentDeta
 		return nil, errori.DBNotFound
 	}
 
+	dto.Placements, err = s.GetTournamentPlacements(tID)
+	if err != nil {
+		return nil, err
+	}
+
 	return &dto, nil
 }
 
@@ -197,10 +206,12 @@ func (s *TournamentService) CreateTournament(req *models.CreateTournamentRequest
 
 	var tournament models.Tournament
 	err := s.db.QueryRow(ctx, `
-		INSERT INTO Tournament (name, discipline, expected_members, manager_id, type, prize, min_team_limit, max_team_limit)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO Tournament (name, discipline, expected_members, manager_id, type, prize, min_team_limit, max_team_limit, max_substitutes, payout,
+			starts_at, lineup_lock_hours)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, COALESCE($12, 24))
 		RETURNING id, state
-	`, req.Name, req.Discipline, req.ExpectedMembers, managerID, req.Type, req.Prize, req.MinLimit, req.MaxLimit).Scan(&tournament.ID, &tournament.State)
+	`, req.Name, req.Discipline, req.ExpectedMembers, managerID, req.Type, req.Prize, req.MinLimit, req.MaxLimit, req.MaxSubstitutes, req.Payout,
+		req.StartsAt, req.LineupLockHours).Scan(&tournament.ID, &tournament.State)
 	if err != nil {
 		return nil, err
 	}
@@ -214,27 +225,53 @@ func (s *TournamentService) CreateTournament(req *models.CreateTournamentRequest
 	return &tournament, nil
 }
 
+// UpdateTournament keeps the start when none is given. Once lineups are locked the start
+// cannot be moved, it would unlock them or lock them retroactively.
 func (s *TournamentService) UpdateTournament(id int32, req *models.CreateTournamentRequest) (*models.Tournament, error) {
 	ctx := context.Background()
 
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var moved bool
+	err = tx.QueryRow(ctx, `
+		SELECT `+lineupLocked+` AND $2::timestamp IS NOT NULL AND t.starts_at IS DISTINCT FROM $2::timestamp
+		FROM Tournament t WHERE t.id = $1 FOR UPDATE
+	`, id, req.StartsAt).Scan(&moved)
+	if err == pgx.ErrNoRows {
+		return nil, errori.DBNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if moved {
+		return nil, errori.LineupLocked
+	}
+
 	var updatedTournament models.Tournament
-	err := s.db.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
 		UPDATE Tournament
 		SET name = $1,
 		    discipline = $2,
 		    expected_members = $3,
-		    type = $4
-		WHERE id = $5
+		    type = $4,
+		    payout = $5,
+		    starts_at = COALESCE($7, starts_at),
+		    lineup_lock_hours = COALESCE($8, lineup_lock_hours)
+		WHERE id = $6
 		RETURNING id, manager_id, state
-	`, req.Name, req.Discipline, req.ExpectedMembers, req.Type, id).Scan(
+	`, req.Name, req.Discipline, req.ExpectedMembers, req.Type, req.Payout, id, req.StartsAt, req.LineupLockHours).Scan(
 		&updatedTournament.ID,
 		&updatedTournament.ManagerID,
 		&updatedTournament.State,
 	)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, errori.DBNotFound
-		}
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
 		return nil, err
 	}
 
@@ -246,15 +283,15 @@ func (s *TournamentService) UpdateTournament(id int32, req *models.CreateTournam
 	return &updatedTournament, nil
 }
 
-func (s *TournamentService) CanDelete(mId, tId int32) (bool, error) {
+// IsDeletable tells whether the tournament can be deleted, which is allowed only for rejected ones.
+func (s *TournamentService) IsDeletable(tId int32) (bool, error) {
 	ctx := context.Background()
-	var managerID pgtype.Int4
 	var state string
 	err := s.db.QueryRow(ctx, `
-		SELECT manager_id, state
+		SELECT state
 		FROM Tournament
 		WHERE id = $1
-	`, tId).Scan(&managerID, &state)
+	`, tId).Scan(&state)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return false, errors.ErrNotFound
@@ -262,22 +299,26 @@ func (s *TournamentService) CanDelete(mId, tId int32) (bool, error) {
 		return false, err
 	}
 
-	if !managerID.Valid {
-		return false, nil
-	}
-
-	if managerID.Int32 != mId {
-		return false, nil
-	}
-
 	return state == "Rejected", nil
 }
 
 func (s *TournamentService) DeleteTournament(id int32) error {
 	ctx := context.Background()
 
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Everyone who took part loses the tournament from their statistics
+	subjects, err := tournamentSubjects(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
 	var deletedID int32
-	err := s.db.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
 		DELETE FROM Tournament
 		WHERE id = $1
 		RETURNING id
@@ -289,7 +330,13 @@ func (s *TournamentService) DeleteTournament(id int32) error {
 		return err
 	}
 
-	return nil
+	for _, subject := range subjects {
+		if err := refreshStatistics(ctx, tx, subject); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
 }
 
 func (s *TournamentService) GetTournamentBracket(id string) (*models.TournamentBracket, error) {
@@ -423,12 +470,140 @@ func (s *TournamentService) GetTournamentBracket(id string) (*models.TournamentB
 		return nil, err
 	}
 
+	lineups, err := s.getMatchLineups(ctx, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range matches {
+		for j := range matches[i].Participants {
+			participant := matches[i].Participants[j]
+			if participant.ID.Valid {
+				matches[i].Participants[j].Lineup = lineups[matchLineupKey{int32(matches[i].ID), participant.ID.Int32}]
+			}
+		}
+	}
+
 	bracket := &models.TournamentBracket{
 		Matches: matches,
 	}
 	return bracket, nil
 }
 
+type matchLineupKey struct {
+	matchID       int32
+	participantID int32
+}
+
+func (s *TournamentService) getMatchLineups(ctx context.Context, tournamentID int) (map[matchLineupKey][]int32, error) {
+	lineups := map[matchLineupKey][]int32{}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT ml.match_id, ml.participant_id, ml.user_id FROM MatchLineup ml
+		JOIN Match m ON m.id = ml.match_id
+		JOIN Stage s ON s.id = m.stage_id
+		WHERE s.tournament_id = $1
+		ORDER BY ml.id
+	`, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key matchLineupKey
+		var userID int32
+		if err := rows.Scan(&key.matchID, &key.participantID, &userID); err != nil {
+			return nil, err
+		}
+		lineups[key] = append(lineups[key], userID)
+	}
+
+	return lineups, rows.Err()
+}
+
+// snapshotMatchLineup records the tournament lineup of every team in the match that
+// has no lineup yet and drops lineups of teams that are no longer in the match.
+func snapshotMatchLineup(ctx context.Context, tx pgx.Tx, matchID int32) error {
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM MatchLineup ml
+		WHERE ml.match_id = $1 AND NOT EXISTS (SELECT * FROM Match m
+			WHERE m.id = ml.match_id AND (m.first_participant_id = ml.participant_id OR m.second_participant_id = ml.participant_id))
+	`, matchID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO MatchLineup(match_id, participant_id, user_id)
+		SELECT m.id, tp.id, l.user_id FROM Match m
+		JOIN TournamentParticipant tp ON tp.id = m.first_participant_id OR tp.id = m.second_participant_id
+		JOIN TournamentLineup l ON l.participant_id = tp.id
+		WHERE m.id = $1
+		AND NOT EXISTS (SELECT * FROM MatchLineup ml WHERE ml.match_id = m.id AND ml.participant_id = tp.id)
+		ON CONFLICT DO NOTHING
+	`, matchID)
+	return err
+}
+
+// setMatchLineup replaces the recorded lineup of a team in the match. Only players
+// who were members of the team at the time of the match can be listed. An empty
+// lineup resets a match not played yet to the tournament lineup.
+func setMatchLineup(ctx context.Context, tx pgx.Tx, matchID, participantID int32, userIDs []int32) error {
+	if len(userIDs) == 0 {
+		var played bool
+		if err := tx.QueryRow(ctx, `
+			SELECT first_participant_is_winner OR second_participant_is_winner FROM Match WHERE id = $1
+		`, matchID).Scan(&played); err != nil {
+			return err
+		}
+		if played {
+			return errors.EmptyLineup
+		}
+
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM MatchLineup WHERE match_id = $1 AND participant_id = $2
+		`, matchID, participantID); err != nil {
+			return err
+		}
+		return snapshotMatchLineup(ctx, tx, matchID)
+	}
+
+	var members int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT teamp.user_id) FROM Match m
+		JOIN TournamentParticipant tp ON tp.id = $2 AND (tp.id = m.first_participant_id OR tp.id = m.second_participant_id)
+		JOIN TeamPlayer teamp ON teamp.team_id = tp.team_id
+		WHERE m.id = $1 AND teamp.user_id = ANY($3)
+		AND teamp.since IS NOT NULL AND teamp.since <= COALESCE(m."date", NOW())
+		AND (teamp.until IS NULL OR teamp.until >= date_trunc('day', COALESCE(m."date", NOW())))
+	`, matchID, participantID, userIDs).Scan(&members); err != nil {
+		return err
+	}
+	if members != len(uniqueIDs(userIDs)) {
+		return errors.InvalidLineup
+	}
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM MatchLineup WHERE match_id = $1 AND participant_id = $2
+	`, matchID, participantID); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO MatchLineup(match_id, participant_id, user_id)
+		SELECT $1, $2, user_id FROM unnest($3::INT[]) AS user_id
+		ON CONFLICT DO NOTHING
+	`, matchID, participantID, userIDs)
+	return err
+}
+
+func uniqueIDs(ids []int32) map[int32]bool {
+	unique := map[int32]bool{}
+	for _, id := range ids {
+		unique[id] = true
+	}
+	return unique
+}
+
 func (s *TournamentService) StartTournament(id string) error {
 	ctx := context.Background()
 	tournamentID, err := strconv.Atoi(id)
@@ -498,6 +673,9 @@ func (s *TournamentService) StartTournament(id string) error {
 	if err := s.createMatches(ctx, tx, tournamentID, participants); err != nil {
 		return err
 	}
+	if err := dropUnreachablePredictions(ctx, tx, tournamentID); err != nil {
+		return err
+	}
 
 	return tx.Commit(ctx)
 }
@@ -540,6 +718,10 @@ func (s *TournamentService) createMatches(ctx context.Context, tx pgx.Tx, tourna
 			return err
 		}
 
+		if err := snapshotMatchLineup(ctx, tx, matchID); err != nil {
+			return err
+		}
+
 		currentRoundMatchIDs = append(currentRoundMatchIDs, matchID)
 		matchCounter++
 	}
@@ -748,10 +930,10 @@ func (s *TournamentService) GetRequestsWithConflicts(ctx context.Context, tID in
 			confrows, err := s.db.Query(ctx, `
 			SELECT DISTINCT t.name FROM TournamentParticipant tp
 			JOIN Team t ON t.id = tp.team_id
-			JOIN TeamPlayer teamp ON teamp.team_id = t.id
-			WHERE tp.tournament_id = $1 AND t.id <> $2
-			AND teamp.user_id IN (SELECT teamp2.user_id FROM TeamPlayer teamp2 WHERE teamp2.team_id = $2)
-			`, tID, tpc.TeamID.Int32)
+			JOIN TournamentLineup l ON l.participant_id = tp.id
+			WHERE tp.tournament_id = $1 AND tp.id <> $2
+			AND l.user_id IN (SELECT l2.user_id FROM TournamentLineup l2 WHERE l2.participant_id = $2)
+			`, tID, tpc.ID)
 			if err == nil {
 				for confrows.Next() {
 					var conflict string
@@ -823,17 +1005,13 @@ func (s *TournamentService) GetTournamentsProfile(managerID int32, searchText st
 	return tournaments, nil
 }
 
-func (s *TournamentService) CheckTournamentBracket(id string, matches *models.TournamentBracket, manager int32, exists bool) error {
+func (s *TournamentService) CheckTournamentBracket(id string, matches *models.TournamentBracket) error {
 	ctx := context.Background()
 	_, err := strconv.Atoi(id)
 	if err != nil {
 		return fmt.Errorf("Invalid tournament ID")
 	}
 
-	if !exists {
-		return fmt.Errorf("Unathorized, cannot change matches")
-	}
-
 	for _, m := range matches.Matches {
 		var fr *string
 		var fw bool
@@ -843,6 +1021,13 @@ func (s *TournamentService) CheckTournamentBracket(id string, matches *models.To
 		fp := m.Participants[0]
 		sp := m.Participants[1]
 
+		// An empty lineup would be replaced by the whole tournament lineup
+		for _, p := range m.Participants[:2] {
+			if p.Lineup != nil && len(p.Lineup) == 0 && (fp.IsWinner || sp.IsWinner) {
+				return errors.EmptyLineup
+			}
+		}
+
 		err := s.db.QueryRow(ctx, `
 			SELECT first_participant_result_text, first_participant_is_winner, second_participant_result_text, second_participant_is_winner
 			FROM Match
@@ -940,6 +1125,18 @@ func (s *TournamentService) UpdateTournamentBracket(id string, matches *models.T
 		if err != nil {
 			return models.TournamentBracket{}, err
 		}
+
+		if err := snapshotMatchLineup(ctx, tx, mid); err != nil {
+			return models.TournamentBracket{}, err
+		}
+		for _, participant := range match.Participants[:2] {
+			if participant.ID.Valid && participant.Lineup != nil {
+				if err := setMatchLineup(ctx, tx, mid, participant.ID.Int32, participant.Lineup); err != nil {
+					return models.TournamentBracket{}, err
+				}
+			}
+		}
+
 		if next_match_id.Valid && sid.Valid && fid.Valid && (fwinner || swinner) {
 			winnerID := fid.Int32
 			if swinner {
@@ -992,6 +1189,14 @@ func (s *TournamentService) UpdateTournamentBracket(id string, matches *models.T
 		}
 	}
 
+	if err := scorePredictions(ctx, tx, tournamentID); err != nil {
+		return models.TournamentBracket{}, err
+	}
+
+	if err := s.finishTournament(ctx, tx, tournamentID); err != nil {
+		return models.TournamentBracket{}, err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return models.TournamentBracket{}, err
 	}
@@ -1020,18 +1225,194 @@ func (s *TournamentService) tryInitNewMatch(ctx context.Context, tx pgx.Tx, next
 		SET first_participant_id=$1, second_participant_id=$2
 		WHERE id = $3
 	`, next_first_id, next_second_id, next_match_id)
+	if err != nil {
+		return err
+	}
+
+	return snapshotMatchLineup(ctx, tx, next_match_id)
+}
+
+// finishTournament persists final placements once the final match has a winner.
+// Losers of a round share the same place, e.g. both semifinal losers are 3rd-4th.
+// Placements of a finished tournament are recomputed, so that a corrected bracket
+// is reflected in placements and prizes as well.
+func (s *TournamentService) finishTournament(ctx context.Context, tx pgx.Tx, tournamentID int) error {
+	var prize pgtype.Int4
+	var payout []int32
+	var finishedAt pgtype.Timestamp
+	if err := tx.QueryRow(ctx, `
+		SELECT prize, payout, finished_at FROM Tournament WHERE id = $1
+	`, tournamentID).Scan(&prize, &payout, &finishedAt); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT s.level, (SELECT MAX(level) FROM Stage s2 WHERE s2.tournament_id = s.tournament_id),
+		       m.first_participant_id, m.first_participant_is_winner,
+		       m.second_participant_id, m.second_participant_is_winner
+		FROM Match m
+		JOIN Stage s ON s.id = m.stage_id
+		WHERE s.tournament_id = $1
+		AND m.first_participant_id IS NOT NULL AND m.second_participant_id IS NOT NULL
+		AND (m.first_participant_is_winner OR m.second_participant_is_winner)
+	`, tournamentID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	places := map[int32]int32{}
+	finished := false
+	for rows.Next() {
+		var level, maxLevel, fid, sid int32
+		var fwinner, swinner bool
+		if err := rows.Scan(&level, &maxLevel, &fid, &fwinner, &sid, &swinner); err != nil {
+			return err
+		}
+
+		winner, loser := fid, sid
+		if swinner {
+			winner, loser = sid, fid
+		}
+
+		places[loser] = int32(1)<<(maxLevel-level) + 1
+		if level == maxLevel {
+			places[winner] = 1
+			finished = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
+	if finishedAt.Valid {
+		if _, err := tx.Exec(ctx, `DELETE FROM TournamentPlacement WHERE tournament_id = $1`, tournamentID); err != nil {
+			return err
+		}
+	}
+
+	if !finished {
+		if finishedAt.Valid {
+			_, err = tx.Exec(ctx, `UPDATE Tournament SET finished_at = NULL WHERE id = $1`, tournamentID)
+		}
+		return err
+	}
+
+	if len(payout) == 0 {
+		payout = []int32{100}
+	}
+
+	for participantID, place := range places {
+		tier := placeTier(place)
+		var award int32
+		if prize.Valid && tier < len(payout) {
+			award = prize.Int32 * payout[tier] / 100
+		}
+
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO TournamentPlacement(tournament_id, participant_id, place, prize)
+			VALUES ($1, $2, $3, $4)
+		`, tournamentID, participantID, place, award); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE Tournament SET finished_at = COALESCE(finished_at, NOW()) WHERE id = $1
+	`, tournamentID)
 	return err
 }
 
-func (s *TournamentService) GetTeamPlayerConstraint(id int) (pgtype.Int4, pgtype.Int4, error) {
+// placeTier maps a place onto its index in the payout table:
+// 1st -> 0, 2nd -> 1, 3rd-4th -> 2, 5th-8th -> 3, ...
+func placeTier(place int32) int {
+	tier := 0
+	for p := int32(1); p < place; p <<= 1 {
+		tier++
+	}
+	return tier
+}
+
+func placeText(place int32) string {
+	if place <= 2 {
+		return strconv.Itoa(int(place))
+	}
+	return fmt.Sprintf("%d-%d", place, 2*place-2)
+}
+
+// ValidPayout checks that the payout table has no more tiers than the bracket
+// has places and that it does not hand out more than the whole prize.
+func ValidPayout(payout []int32, participants int32) bool {
+	total := int32(0)
+	for tier, percentage := range payout {
+		tierSize := int32(1)
+		if tier > 1 {
+			tierSize = int32(1) << (tier - 1)
+		}
+		if tier > 1 && tierSize > participants/2 {
+			return false
+		}
+		total += percentage * tierSize
+	}
+	return total <= 100
+}
+
+func (s *TournamentService) GetTournamentPlacements(tID int32) ([]models.Placement, error) {
+	ctx := context.Background()
+
+	rows, err := s.db.Query(ctx, placementsQuery+`
+		WHERE pl.tournament_id = $1
+		ORDER BY pl.place, pl.id
+	`, tID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPlacements(rows)
+}
+
+const placementsQuery = `
+	SELECT t.id, t.name, t.discipline, pl.participant_id,
+	       COALESCE(team.name, u.name || ' ' || u.surname), pl.place, pl.prize, t.finished_at
+	FROM TournamentPlacement pl
+	JOIN Tournament t ON t.id = pl.tournament_id
+	JOIN TournamentParticipant tp ON tp.id = pl.participant_id
+	LEFT JOIN Team team ON team.id = tp.team_id
+	LEFT JOIN "User" u ON u.id = tp.player_id
+`
+
+func scanPlacements(rows pgx.Rows) ([]models.Placement, error) {
+	placements := []models.Placement{}
+
+	for rows.Next() {
+		var p models.Placement
+		if err := rows.Scan(
+			&p.TournamentID,
+			&p.TournamentName,
+			&p.Discipline,
+			&p.ParticipantID,
+			&p.Name,
+			&p.Place,
+			&p.Prize,
+			&p.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		p.PlaceText = placeText(p.Place)
+		placements = append(placements, p)
+	}
+
+	return placements, rows.Err()
+}
+
+func (s *TournamentService) GetTeamSizeLimits(id int) (models.TeamSizeLimits, error) {
 	ctx := context.Background()
-	var min pgtype.Int4
-	var max pgtype.Int4
+	var limits models.TeamSizeLimits
 	err := s.db.QueryRow(ctx, `
-		SELECT min_team_limit, max_team_limit
+		SELECT min_team_limit, max_team_limit, max_substitutes
 		FROM Tournament
 		WHERE id = $1;
-	`, id).Scan(&min, &max)
-	return min, max, err
+	`, id).Scan(&limits.MinLimit, &limits.MaxLimit, &limits.MaxSubstitutes)
+	return limits, err
 }
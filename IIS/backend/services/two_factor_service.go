@@ -0,0 +1,243 @@
+/**
+ * IIS Project
+ * @author Albert Tikaiev
+ */
+package services
+
+import (
+	"backend/internal/errors"
+	"backend/internal/jwt"
+	"backend/internal/totp"
+	"context"
+	"crypto/rand"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const totpIssuer = "IIS Project"
+const recoveryCodeCount = 10
+
+type TwoFactorService struct {
+	db *pgxpool.Pool
+}
+
+func NewTwoFactorService(db *pgxpool.Pool) *TwoFactorService {
+	return &TwoFactorService{db}
+}
+
+// TwoFactorRequired tells whether the user has to pass the second factor on login,
+// which is always the case for admins.
+func TwoFactorRequired(role string, enabled bool) bool {
+	return enabled || role == "Admin"
+}
+
+// BeginEnrollment stores a new secret, which becomes active once a code is confirmed.
+func (s *TwoFactorService) BeginEnrollment(ctx context.Context, userID int32) (string, string, error) {
+	var email string
+	var enabled bool
+	err := s.db.QueryRow(ctx, `
+		SELECT email, totp_enabled FROM "User" WHERE id = $1
+	`, userID).Scan(&email, &enabled)
+	if err == pgx.ErrNoRows {
+		return "", "", errors.DBNotFound
+	} else if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", errors.NotAcceptable
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := s.db.Exec(ctx, `
+		UPDATE "User" SET totp_secret = $1, totp_last_step = NULL WHERE id = $2
+	`, secret, userID); err != nil {
+		return "", "", err
+	}
+
+	return secret, totp.ProvisioningURI(totpIssuer, email, secret), nil
+}
+
+// ConfirmEnrollment enables 2FA when the code matches the pending secret and returns recovery codes.
+func (s *TwoFactorService) ConfirmEnrollment(ctx context.Context, userID int32, code string) ([]string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var secret pgtype.Text
+	var enabled bool
+	err = tx.QueryRow(ctx, `
+		SELECT totp_secret, totp_enabled FROM "User" WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&secret, &enabled)
+	if err != nil {
+		return nil, err
+	}
+	if enabled || !secret.Valid {
+		return nil, errors.NotAcceptable
+	}
+
+	step, ok := totp.Validate(secret.String, code, time.Now())
+	if !ok {
+		return nil, errors.InvalidCode
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE "User" SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2
+	`, step, userID); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit(ctx)
+}
+
+// VerifyCode accepts either a current TOTP code or an unused recovery code.
+func (s *TwoFactorService) VerifyCode(ctx context.Context, userID int32, code string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := verifyCode(ctx, tx, userID, code); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func verifyCode(ctx context.Context, tx pgx.Tx, userID int32, code string) error {
+	var secret pgtype.Text
+	var enabled bool
+	var lastStep pgtype.Int8
+	err := tx.QueryRow(ctx, `
+		SELECT totp_secret, totp_enabled, totp_last_step FROM "User" WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return err
+	}
+	if !enabled || !secret.Valid {
+		return errors.NotAcceptable
+	}
+
+	if step, ok := totp.Validate(secret.String, code, time.Now()); ok {
+		// Every code can be used only once
+		if lastStep.Valid && step <= lastStep.Int64 {
+			return errors.InvalidCode
+		}
+		_, err := tx.Exec(ctx, `UPDATE "User" SET totp_last_step = $1 WHERE id = $2`, step, userID)
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE RecoveryCode SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, jwt.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.InvalidCode
+	}
+
+	return nil
+}
+
+// Disable turns 2FA off after the code is verified, admins cannot disable it.
+func (s *TwoFactorService) Disable(ctx context.Context, userID int32, code string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := verifyCode(ctx, tx, userID, code); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE "User" SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL WHERE id = $1
+	`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM RecoveryCode WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, the old ones stop working.
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID int32, code string) ([]string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := verifyCode(ctx, tx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int32) ([]string, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM RecoveryCode WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO RecoveryCode(user_id, code_hash) VALUES ($1, $2)
+		`, userID, jwt.HashToken(normalizeRecoveryCode(code))); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCode returns code like "k7f3q-9xm2p"
+func generateRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz023456789"
+
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i := range buf {
+		buf[i] = alphabet[buf[i]&31]
+	}
+
+	return string(buf[:5]) + "-" + string(buf[5:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
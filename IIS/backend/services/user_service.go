@@ -5,6 +5,7 @@
 package services
 
 import (
+	"backend/internal/errors"
 	"backend/models"
 	"context"
 
@@ -38,7 +39,7 @@ func (s *UserService) GetAllUsers(page, limit int, searchText string) (models.Pa
 	}
 
 	rows, err := s.db.Query(ctx, `
-	SELECT id, email, role, name, surname FROM "User"
+	SELECT id, email, role, name, surname, status, status_reason, status_until, status_changed_by, status_changed_at FROM "User"
 	WHERE role = 'Registered' AND ($1 = '' OR similarity(name || ' ' || surname, $1) > 0.05)
 	ORDER BY CASE WHEN $1 = '' THEN id ELSE similarity(name, $1) END DESC
 	LIMIT $2 OFFSET $3
@@ -56,7 +57,12 @@ func (s *UserService) GetAllUsers(page, limit int, searchText string) (models.Pa
 			&user.Email,
 			&user.Role,
 			&user.Name,
-			&user.Surname); err != nil {
+			&user.Surname,
+			&user.Status,
+			&user.StatusReason,
+			&user.StatusUntil,
+			&user.StatusChangedBy,
+			&user.StatusChangedAt); err != nil {
 			return ans, err
 		}
 		users = append(users, user)
@@ -77,8 +83,10 @@ func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*models
 	var user models.User
 
 	err := s.db.QueryRow(ctx,
-		`SELECT id, email, password, role, name, surname FROM "User" WHERE email=$1`, email,
-	).Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.Name, &user.Surname)
+		`SELECT id, email, password, role, name, surname, email_verified, totp_enabled, status, status_reason, status_until
+		FROM "User" WHERE email=$1`, email,
+	).Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.Name, &user.Surname, &user.EmailVerified, &user.TOTPEnabled,
+		&user.Status, &user.StatusReason, &user.StatusUntil)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -94,8 +102,10 @@ func (s *UserService) GetUserById(ctx context.Context, id int32) (*models.User,
 	var user models.User
 
 	err := s.db.QueryRow(ctx,
-		`SELECT id, email, password, role, name, surname FROM "User" WHERE id=$1`, id,
-	).Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.Name, &user.Surname)
+		`SELECT id, email, password, role, name, surname, email_verified, totp_enabled, status, status_reason, status_until
+		FROM "User" WHERE id=$1`, id,
+	).Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.Name, &user.Surname, &user.EmailVerified, &user.TOTPEnabled,
+		&user.Status, &user.StatusReason, &user.StatusUntil)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -126,31 +136,31 @@ func (s *UserService) CreateUser(ctx context.Context, tx pgx.Tx, req models.Regi
 	return &out, nil
 }
 
-func (s *UserService) AdminUpdateUser(ctx context.Context, id int32, req models.AdminUpdateUserRequest) error {
-	var err error
-	if req.Password == "" {
-		_, err = s.db.Exec(ctx, `
-		UPDATE "User"
-		SET email = $1
-		WHERE id = $2
-	`, req.Email, id)
-	} else {
-		_, err = s.db.Exec(ctx, `
-		UPDATE "User"
-		SET email = $1, password = $2
-		WHERE id = $3
-	`, req.Email, req.Password, id)
+// AdminUpdateUser sets the email and, when given, the password hash. A changed email
+// is not verified by anyone yet, it returns whether the user has to confirm it.
+func (s *UserService) AdminUpdateUser(ctx context.Context, id int32, req models.AdminUpdateUserRequest) (bool, error) {
+	var changed bool
+	err := s.db.QueryRow(ctx, `
+		UPDATE "User" u
+		SET email = $1, password = COALESCE(NULLIF($2, ''), u.password),
+		    email_verified = u.email_verified AND prev.email = $1
+		FROM (SELECT email FROM "User" WHERE id = $3) prev
+		WHERE u.id = $3
+		RETURNING prev.email <> $1
+	`, req.Email, req.Password, id).Scan(&changed)
+	if err == pgx.ErrNoRows {
+		return false, errors.DBNotFound
 	}
 
-	return err
+	return changed, err
 }
 
 func (s *UserService) UpdateUser(ctx context.Context, id int32, req models.UpdateUserRequest) error {
 	_, err := s.db.Exec(ctx, `
 		UPDATE "User"
-		SET name = $1, surname = $2, email = $3
-		WHERE id = $4
-	`, req.Name, req.Surname, req.Email, id)
+		SET name = $1, surname = $2
+		WHERE id = $3
+	`, req.Name, req.Surname, id)
 
 	return err
 }
@@ -160,7 +170,7 @@ func (s *UserService) SearchUserByEmail(ctx context.Context, text string) ([]mod
 
 	rows, err := s.db.Query(ctx, `
 		SELECT id, email, name, surname FROM "User"
-    WHERE Role = 'Registered' AND similarity(email, $1) > 0.05
+    WHERE Role = 'Registered' AND status = 'Active' AND similarity(email, $1) > 0.05
     ORDER BY similarity(email, $1) DESC
     LIMIT 10
 	`, text)
@@ -1,10 +1,35 @@
+DROP TABLE IF EXISTS ParticipantActivityStatistic CASCADE;
+DROP TABLE IF EXISTS ParticipantDisciplineStatistic CASCADE;
 DROP TABLE IF EXISTS ParticipantStatistic CASCADE;
+DROP TABLE IF EXISTS TournamentPlacement CASCADE;
+DROP TABLE IF EXISTS MatchLineup CASCADE;
+DROP TABLE IF EXISTS EarnedAchievement CASCADE;
+DROP TABLE IF EXISTS Achievement CASCADE;
+DROP TABLE IF EXISTS Prediction CASCADE;
 DROP TABLE IF EXISTS Match CASCADE;
 DROP TABLE IF EXISTS Stage CASCADE;
 DROP TABLE IF EXISTS TournamentParticipant CASCADE;
 DROP TABLE IF EXISTS TeamPlayer CASCADE;
 DROP TABLE IF EXISTS Tournament CASCADE;
 DROP TABLE IF EXISTS Team CASCADE;
+DROP TABLE IF EXISTS PendingTeamInvite CASCADE;
+DROP TABLE IF EXISTS LineupSubstitution CASCADE;
+DROP TABLE IF EXISTS TournamentLineup CASCADE;
+DROP TABLE IF EXISTS TeamOwnershipTransfer CASCADE;
+DROP TABLE IF EXISTS TeamInviteCode CASCADE;
+DROP TABLE IF EXISTS AuditLog CASCADE;
+DROP TABLE IF EXISTS ImpersonationAction CASCADE;
+DROP TABLE IF EXISTS Impersonation CASCADE;
+DROP TABLE IF EXISTS DataExport CASCADE;
+DROP TABLE IF EXISTS LoginAttempt CASCADE;
+DROP TABLE IF EXISTS RoleAssignment CASCADE;
+DROP TABLE IF EXISTS PersonalAccessToken CASCADE;
+DROP TABLE IF EXISTS OIDCState CASCADE;
+DROP TABLE IF EXISTS UserIdentity CASCADE;
+DROP TABLE IF EXISTS RecoveryCode CASCADE;
+DROP TABLE IF EXISTS UserToken CASCADE;
+DROP TABLE IF EXISTS RefreshToken CASCADE;
+DROP TABLE IF EXISTS Session CASCADE;
 DROP TABLE IF EXISTS "User" CASCADE;
 
 CREATE EXTENSION IF NOT EXISTS pg_trgm;
@@ -15,7 +40,16 @@ CREATE TABLE "User" (
     password VARCHAR NOT NULL,
     role VARCHAR CHECK ( role in ('Admin', 'Registered')) NOT NULL DEFAULT 'Registered',
     name VARCHAR,
-    surname VARCHAR
+    surname VARCHAR,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_secret VARCHAR DEFAULT NULL,
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_step BIGINT DEFAULT NULL,
+    status VARCHAR CHECK ( status in ('Active', 'Suspended', 'Banned', 'Deleted')) NOT NULL DEFAULT 'Active',
+    status_reason VARCHAR DEFAULT NULL,
+    status_until TIMESTAMPTZ DEFAULT NULL,
+    status_changed_by INT REFERENCES "User"(id) DEFAULT NULL,
+    status_changed_at TIMESTAMPTZ DEFAULT NULL
 );
 
 CREATE TABLE Team(
@@ -23,7 +57,8 @@ CREATE TABLE Team(
     name VARCHAR NOT NULL ,
     description VARCHAR NOT NULL DEFAULT '',
     since DATE NOT NULL,
-    manager_id INT REFERENCES "User"(id)
+    manager_id INT REFERENCES "User"(id),
+    archived_at TIMESTAMP DEFAULT NULL
 );
 
 CREATE TABLE TeamPlayer(
@@ -32,7 +67,8 @@ CREATE TABLE TeamPlayer(
     team_id INT REFERENCES Team(id) NOT NULL,
     since TIMESTAMP,
     until TIMESTAMP,
-    state VARCHAR CHECK ( state in ('Invited', 'Active', 'Inactive')) NOT NULL DEFAULT 'Invited'
+    state VARCHAR CHECK ( state in ('Invited', 'Requested', 'Active', 'Inactive')) NOT NULL DEFAULT 'Invited',
+    squad VARCHAR CHECK ( squad in ('Starter', 'Substitute')) NOT NULL DEFAULT 'Starter'
 );
 
 CREATE TABLE Tournament (
@@ -45,7 +81,12 @@ CREATE TABLE Tournament (
     type VARCHAR CHECK ( type in ('Person', 'Team')) NOT NULL,
     prize INT,
     min_team_limit INT DEFAULT NULL,
-    max_team_limit INT DEFAULT NULL
+    max_team_limit INT DEFAULT NULL,
+    max_substitutes INT DEFAULT NULL,
+    payout INT[] DEFAULT NULL,
+    finished_at TIMESTAMP DEFAULT NULL,
+    starts_at TIMESTAMP DEFAULT NULL,
+    lineup_lock_hours INT NOT NULL DEFAULT 24
 );
 
 CREATE TABLE TournamentParticipant(
@@ -75,3 +116,330 @@ CREATE TABLE Match(
     second_participant_is_winner BOOLEAN DEFAULT FALSE,
     "date" TIMESTAMP
 );
+
+CREATE TABLE TournamentPlacement(
+    id SERIAL PRIMARY KEY,
+    tournament_id INT NOT NULL REFERENCES Tournament(id) ON DELETE CASCADE,
+    participant_id INT NOT NULL REFERENCES TournamentParticipant(id) ON DELETE CASCADE,
+    place INT NOT NULL,
+    prize INT NOT NULL DEFAULT 0,
+    UNIQUE (tournament_id, participant_id)
+);
+
+CREATE TABLE MatchLineup(
+    id SERIAL PRIMARY KEY,
+    match_id INT NOT NULL REFERENCES Match(id) ON DELETE CASCADE,
+    participant_id INT NOT NULL REFERENCES TournamentParticipant(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES "User"(id),
+    UNIQUE (match_id, user_id)
+);
+
+-- Achievement rules: a subject earns the achievement once its metric reaches the threshold
+CREATE TABLE Achievement(
+    id SERIAL PRIMARY KEY,
+    code VARCHAR NOT NULL UNIQUE,
+    name VARCHAR NOT NULL,
+    description VARCHAR NOT NULL DEFAULT '',
+    subject VARCHAR CHECK ( subject in ('Player', 'Team', 'Any')) NOT NULL DEFAULT 'Any',
+    metric VARCHAR CHECK ( metric in ('matches_played', 'matches_won', 'win_streak', 'tournaments_won', 'podiums', 'undefeated_tournaments', 'disciplines')) NOT NULL,
+    threshold INT NOT NULL CHECK ( threshold > 0 ),
+    discipline VARCHAR DEFAULT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE EarnedAchievement(
+    id SERIAL PRIMARY KEY,
+    achievement_id INT NOT NULL REFERENCES Achievement(id) ON DELETE CASCADE,
+    user_id INT REFERENCES "User"(id),
+    team_id INT REFERENCES Team(id),
+    -- Date of the match or tournament which reached the threshold, not of the evaluation
+    earned_at TIMESTAMP NOT NULL,
+    CHECK ( (user_id IS NULL) <> (team_id IS NULL) ),
+    UNIQUE (achievement_id, user_id),
+    UNIQUE (achievement_id, team_id)
+);
+
+INSERT INTO Achievement(code, name, description, metric, threshold) VALUES
+  ('first_tournament_win', 'First Trophy', 'Win a tournament', 'tournaments_won', 1),
+  ('win_streak_10', 'Unstoppable', 'Win 10 matches in a row', 'win_streak', 10),
+  ('undefeated_tournament', 'Flawless', 'Win a tournament without losing a single match', 'undefeated_tournaments', 1),
+  ('five_disciplines', 'All-Rounder', 'Play in five different disciplines', 'disciplines', 5);
+
+-- Spectator pick for the winner of a match, points are NULL until the match is decided.
+-- The match is given by its stage level and its position within the stage, so the whole
+-- bracket can be predicted before the tournament starts and its matches are generated.
+CREATE TABLE Prediction(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "User"(id),
+    tournament_id INT NOT NULL REFERENCES Tournament(id) ON DELETE CASCADE,
+    level INT NOT NULL CHECK (level >= 1),
+    slot INT NOT NULL CHECK (slot >= 1),
+    participant_id INT NOT NULL REFERENCES TournamentParticipant(id) ON DELETE CASCADE,
+    points INT DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, tournament_id, level, slot)
+);
+
+-- Precomputed profile statistics of a player or a team, refreshed whenever their results change
+CREATE TABLE ParticipantStatistic(
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES "User"(id) ON DELETE CASCADE,
+    team_id INT REFERENCES Team(id) ON DELETE CASCADE,
+    wins INT NOT NULL DEFAULT 0,
+    loses INT NOT NULL DEFAULT 0,
+    winnings INT NOT NULL DEFAULT 0,
+    refreshed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ( (user_id IS NULL) <> (team_id IS NULL) ),
+    UNIQUE (user_id),
+    UNIQUE (team_id)
+);
+
+CREATE TABLE ParticipantDisciplineStatistic(
+    statistic_id INT NOT NULL REFERENCES ParticipantStatistic(id) ON DELETE CASCADE,
+    discipline VARCHAR NOT NULL,
+    tournaments INT NOT NULL,
+    PRIMARY KEY (statistic_id, discipline)
+);
+
+CREATE TABLE ParticipantActivityStatistic(
+    statistic_id INT NOT NULL REFERENCES ParticipantStatistic(id) ON DELETE CASCADE,
+    month DATE NOT NULL,
+    personal INT NOT NULL DEFAULT 0,
+    teams INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (statistic_id, month)
+);
+
+-- Login session of a device, revoking it invalidates all of its refresh tokens
+CREATE TABLE Session(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "User"(id) ON DELETE CASCADE,
+    user_agent VARCHAR NOT NULL DEFAULT '',
+    ip VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL
+);
+
+-- Refresh tokens are stored hashed, a token is rotated on every use
+CREATE TABLE RefreshToken(
+    id SERIAL PRIMARY KEY,
+    session_id INT NOT NULL REFERENCES Session(id) ON DELETE CASCADE,
+    token_hash VARCHAR NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    rotated_at TIMESTAMP DEFAULT NULL
+);
+
+-- Single-use emailed tokens, email holds the address being confirmed
+CREATE TABLE UserToken(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "User"(id) ON DELETE CASCADE,
+    purpose VARCHAR CHECK ( purpose in ('PasswordReset', 'EmailVerification')) NOT NULL,
+    token_hash VARCHAR NOT NULL UNIQUE,
+    email VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL
+);
+
+-- One-time codes for signing in without the authenticator app
+CREATE TABLE RecoveryCode(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "User"(id) ON DELETE CASCADE,
+    code_hash VARCHAR NOT NULL UNIQUE,
+    used_at TIMESTAMP DEFAULT NULL
+);
+
+-- Accounts of external OpenID Connect providers linked to the user
+CREATE TABLE UserIdentity(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "User"(id) ON DELETE CASCADE,
+    provider VARCHAR NOT NULL,
+    subject VARCHAR NOT NULL,
+    email VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, subject)
+);
+
+-- Pending OpenID Connect logins between the redirect and the callback
+CREATE TABLE OIDCState(
+    state_hash VARCHAR PRIMARY KEY,
+    provider VARCHAR NOT NULL,
+    nonce VARCHAR NOT NULL,
+    code_verifier VARCHAR NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Tokens for scripts and integrations, sent as "Authorization: Bearer"
+CREATE TABLE PersonalAccessToken(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "User"(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    token_hash VARCHAR NOT NULL UNIQUE,
+    scopes VARCHAR[] NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP DEFAULT NULL,
+    last_used_at TIMESTAMP DEFAULT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL
+);
+
+-- Roles granted by owners (managers) of a tournament or a team, exactly one of them is set
+CREATE TABLE RoleAssignment(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "User"(id) ON DELETE CASCADE,
+    role VARCHAR CHECK ( role in ('CoOrganizer', 'Referee', 'Captain', 'CoManager')) NOT NULL,
+    tournament_id INT REFERENCES Tournament(id) ON DELETE CASCADE,
+    team_id INT REFERENCES Team(id) ON DELETE CASCADE,
+    granted_by INT REFERENCES "User"(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ( (tournament_id IS NULL) <> (team_id IS NULL) ),
+    UNIQUE (user_id, role, tournament_id),
+    UNIQUE (user_id, role, team_id)
+);
+
+-- A team has at most one captain
+CREATE UNIQUE INDEX role_assignment_captain_idx ON RoleAssignment(team_id) WHERE role = 'Captain';
+
+-- Failed logins per account or IP address, used when LOGIN_ATTEMPT_STORE=postgres
+CREATE TABLE LoginAttempt(
+    key VARCHAR PRIMARY KEY,
+    failures INT NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL
+);
+
+-- Personal data export requested by the user, the ZIP archive is kept until it expires
+CREATE TABLE DataExport(
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "User"(id) ON DELETE CASCADE,
+    state VARCHAR CHECK ( state in ('Pending', 'Ready', 'Failed')) NOT NULL DEFAULT 'Pending',
+    archive BYTEA DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP DEFAULT NULL,
+    expires_at TIMESTAMP DEFAULT NULL
+);
+
+-- Admin acting as another user for support, every request made during it is recorded
+CREATE TABLE Impersonation(
+    id SERIAL PRIMARY KEY,
+    admin_id INT NOT NULL REFERENCES "User"(id),
+    user_id INT NOT NULL REFERENCES "User"(id),
+    reason VARCHAR NOT NULL,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE TABLE ImpersonationAction(
+    id SERIAL PRIMARY KEY,
+    impersonation_id INT NOT NULL REFERENCES Impersonation(id) ON DELETE CASCADE,
+    method VARCHAR NOT NULL,
+    path VARCHAR NOT NULL,
+    status INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Who changed what, entities are snapshot before and after the change.
+-- The log is append-only, it references no tournaments or teams so that it survives their deletion.
+CREATE TABLE AuditLog(
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INT REFERENCES "User"(id),
+    impersonator_id INT REFERENCES "User"(id),
+    action VARCHAR NOT NULL,
+    entity_type VARCHAR NOT NULL DEFAULT '',
+    entity_id INT DEFAULT NULL,
+    tournament_id INT DEFAULT NULL,
+    before JSONB DEFAULT NULL,
+    after JSONB DEFAULT NULL,
+    method VARCHAR NOT NULL,
+    path VARCHAR NOT NULL,
+    status INT NOT NULL,
+    ip VARCHAR NOT NULL DEFAULT '',
+    user_agent VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_log_tournament_idx ON AuditLog(tournament_id, created_at);
+CREATE INDEX audit_log_entity_idx ON AuditLog(entity_type, entity_id);
+
+-- Entries are never changed or removed. The only exception is redacting personal data
+-- from snapshots of a deleted user, which the transaction has to announce by setting
+-- audit.redact_user to the user's ID, every other column has to stay the same.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+       AND OLD.entity_type = 'user'
+       AND OLD.entity_id::text = current_setting('audit.redact_user', TRUE)
+       AND (to_jsonb(NEW) - 'before' - 'after') = (to_jsonb(OLD) - 'before' - 'after') THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'AuditLog is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON AuditLog
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON AuditLog
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- Shareable codes letting any registered user join the team, limited in time and optionally in uses
+CREATE TABLE TeamInviteCode(
+    id SERIAL PRIMARY KEY,
+    team_id INT REFERENCES Team(id) ON DELETE CASCADE NOT NULL,
+    code VARCHAR NOT NULL UNIQUE,
+    created_by INT REFERENCES "User"(id) NOT NULL,
+    max_uses INT DEFAULT NULL,
+    uses INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Handing the team to one of its players, the new manager has to accept it
+CREATE TABLE TeamOwnershipTransfer(
+    id SERIAL PRIMARY KEY,
+    team_id INT REFERENCES Team(id) ON DELETE CASCADE NOT NULL,
+    from_user_id INT REFERENCES "User"(id) NOT NULL,
+    to_user_id INT REFERENCES "User"(id) NOT NULL,
+    state VARCHAR CHECK ( state in ('Pending', 'Accepted', 'Rejected', 'Cancelled')) NOT NULL DEFAULT 'Pending',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX team_ownership_transfer_pending_idx ON TeamOwnershipTransfer(team_id) WHERE state = 'Pending';
+
+-- Players a team registered for the tournament. Lineups lock lineup_lock_hours before
+-- starts_at, or when the bracket is generated, then only approved substitutions change them.
+CREATE TABLE TournamentLineup(
+    id SERIAL PRIMARY KEY,
+    participant_id INT NOT NULL REFERENCES TournamentParticipant(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES "User"(id),
+    UNIQUE (participant_id, user_id)
+);
+
+CREATE TABLE LineupSubstitution(
+    id SERIAL PRIMARY KEY,
+    participant_id INT NOT NULL REFERENCES TournamentParticipant(id) ON DELETE CASCADE,
+    out_user_id INT NOT NULL REFERENCES "User"(id),
+    in_user_id INT NOT NULL REFERENCES "User"(id),
+    reason VARCHAR NOT NULL,
+    state VARCHAR CHECK ( state in ('Pending', 'Approved', 'Rejected')) NOT NULL DEFAULT 'Pending',
+    requested_by INT REFERENCES "User"(id),
+    resolved_by INT REFERENCES "User"(id),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP DEFAULT NULL
+);
+
+-- Invites to emails without an account, they turn into TeamPlayer invites when an account verifies the email
+CREATE TABLE PendingTeamInvite(
+    id SERIAL PRIMARY KEY,
+    team_id INT NOT NULL REFERENCES Team(id) ON DELETE CASCADE,
+    email VARCHAR NOT NULL,
+    name VARCHAR,
+    squad VARCHAR CHECK ( squad in ('Starter', 'Substitute')) NOT NULL DEFAULT 'Starter',
+    invited_by INT REFERENCES "User"(id),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL DEFAULT NOW() + INTERVAL '30 days'
+);
+
+CREATE UNIQUE INDEX pending_team_invite_email_idx ON PendingTeamInvite(team_id, LOWER(email));
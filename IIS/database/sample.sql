@@ -92,6 +92,18 @@ INSERT INTO Match(
   (2, 'Match 6', 7, 6, '0', FALSE, 8, '3', TRUE, '2025-10-10 18:30:00'),
   (3, 'Match 7', NULL, 3, '3', TRUE, 6, '2', FALSE, '2025-10-10 20:00:00');
 
+INSERT INTO TournamentPlacement(tournament_id, participant_id, place, prize) VALUES
+  (1, 3, 1, 1000),
+  (1, 6, 2, 0),
+  (1, 1, 3, 0),
+  (1, 8, 3, 0),
+  (1, 2, 5, 0),
+  (1, 4, 5, 0),
+  (1, 5, 5, 0),
+  (1, 7, 5, 0);
+
+UPDATE Tournament SET finished_at = '2025-10-10 20:00:00' WHERE id = 1;
+
 -- Chess tournament
 INSERT INTO Tournament(name, discipline, expected_members, manager_id, state, type, prize, min_team_limit, max_team_limit) VALUES
   ('FIT chess', 'Chess', 16, 3, 'Accepted', 'Person', 500, NULL, NULL);
@@ -190,6 +202,18 @@ INSERT INTO Match(
   (9, 'Match 6', 29, 29, '15', TRUE, 31, '12', FALSE, '2025-10-21 18:00:00'),
   (10, 'Match 7', NULL, 25, '15', TRUE, 29, '13', FALSE, '2025-10-22 19:00:00');
 
+INSERT INTO TournamentPlacement(tournament_id, participant_id, place, prize) VALUES
+  (3, 25, 1, 1500),
+  (3, 29, 2, 0),
+  (3, 27, 3, 0),
+  (3, 31, 3, 0),
+  (3, 26, 5, 0),
+  (3, 28, 5, 0),
+  (3, 30, 5, 0),
+  (3, 32, 5, 0);
+
+UPDATE Tournament SET finished_at = '2025-10-22 19:00:00' WHERE id = 3;
+
 -- Rocket League team tournament
 INSERT INTO Tournament(name, discipline, expected_members, manager_id, state, type, prize, min_team_limit, max_team_limit) VALUES
   ('Rocket Master', 'Rocket League', 8, 6, 'Accepted', 'Team', 2000, 2, 4);
@@ -306,3 +330,30 @@ INSERT INTO Match(
   (18, 'Match 5', 50, 49, '3 beers', TRUE, 51, '1 beers', FALSE, '2025-11-11 18:30:00'),
   (18, 'Match 6', 50, 54, '0 beers', FALSE, 56, '3 beers', TRUE, '2025-11-11 18:30:00'),
   (19, 'Match 7', NULL, 49, '20 beers', TRUE, 56, '2 beers', FALSE, '2025-11-11 20:00:00');
+
+INSERT INTO TournamentPlacement(tournament_id, participant_id, place, prize) VALUES
+  (6, 49, 1, 5000),
+  (6, 56, 2, 0),
+  (6, 51, 3, 0),
+  (6, 54, 3, 0),
+  (6, 50, 5, 0),
+  (6, 52, 5, 0),
+  (6, 53, 5, 0),
+  (6, 55, 5, 0);
+
+UPDATE Tournament SET finished_at = '2025-11-11 20:00:00' WHERE id = 6;
+
+-- Teams registered all their players for the tournaments
+INSERT INTO TournamentLineup(participant_id, user_id)
+SELECT tp.id, teamp.user_id FROM TournamentParticipant tp
+JOIN TeamPlayer teamp ON teamp.team_id = tp.team_id AND teamp.state = 'Active'
+WHERE tp.state = 'Accepted';
+
+-- Team matches are credited only to the players rostered for them
+INSERT INTO MatchLineup(match_id, participant_id, user_id)
+SELECT m.id, tp.id, l.user_id FROM Match m
+JOIN TournamentParticipant tp ON tp.id = m.first_participant_id OR tp.id = m.second_participant_id
+JOIN TournamentLineup l ON l.participant_id = tp.id;
+
+-- Sample accounts are treated as already confirmed
+UPDATE "User" SET email_verified = TRUE;